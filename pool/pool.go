@@ -143,6 +143,17 @@ func (p *Pool) Shutdown(traceID string) {
 	p.wg.Wait()
 }
 
+// IsRunning reports if the pool can still accept work. It returns false
+// once Shutdown has been called.
+func (p *Pool) IsRunning() bool {
+	select {
+	case <-p.shutdown:
+		return false
+	default:
+		return true
+	}
+}
+
 // Do waits for the goroutine pool to take the work to be executed.
 func (p *Pool) Do(traceID string, work Worker) {
 	dw := doWork{
@@ -72,6 +72,16 @@ func TestPool(t *testing.T) {
 
 		time.Sleep(100 * time.Millisecond)
 
+		if !p.IsRunning() {
+			t.Fatal("\tShould be running before shutdown.", failed)
+		}
+		t.Log("\tShould be running before shutdown.", success)
+
 		p.Shutdown("TestPool")
+
+		if p.IsRunning() {
+			t.Fatal("\tShould not be running after shutdown.", failed)
+		}
+		t.Log("\tShould not be running after shutdown.", success)
 	}
 }
@@ -0,0 +1,63 @@
+package tcp
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidAddr is returned by SplitAddr when an address isn't a host
+// and a numeric port.
+var ErrInvalidAddr = errors.New("Invalid Address")
+
+// SplitAddr splits a client address, like the ones used as keys by Do and
+// reported by Connections, into its host and port. IPv4 addresses are in
+// the "host:port" form and IPv6 addresses in the "[host]:port" or
+// "[host%zone]:port" form. The host is returned without the brackets and
+// keeps the zone. Use it instead of splitting addresses by hand so every
+// feature treats the IPv6 forms the same.
+func SplitAddr(addr string) (host string, port int, err error) {
+	h, p, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", 0, ErrInvalidAddr
+	}
+
+	port, err = strconv.Atoi(p)
+	if err != nil || port < 0 || port > 65535 {
+		return "", 0, ErrInvalidAddr
+	}
+
+	return h, port, nil
+}
+
+// tcpAddr returns the host and port of an address. Addresses that aren't a
+// *net.TCPAddr are parsed from their string form, so wrapped connections and
+// listeners still work. Nil is returned if the address isn't a host and port.
+func tcpAddr(addr net.Addr) *net.TCPAddr {
+	if addr == nil {
+		return nil
+	}
+
+	if a, ok := addr.(*net.TCPAddr); ok {
+		return a
+	}
+
+	host, port, err := SplitAddr(addr.String())
+	if err != nil {
+		return nil
+	}
+
+	// Split the zone from an ipv6 host, like "fe80::1%eth0".
+	var zone string
+	if i := strings.IndexByte(host, '%'); i >= 0 {
+		host, zone = host[:i], host[i+1:]
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil
+	}
+
+	return &net.TCPAddr{IP: ip, Port: port, Zone: zone}
+}
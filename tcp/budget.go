@@ -0,0 +1,49 @@
+package tcp
+
+import "math"
+
+// budgetPools returns the pool sizes derived from the pool budget. Each
+// pool is given its share of the budget, rounded, and always at least one
+// routine.
+func (cfg *Config) budgetPools() OptIntPool {
+	share := cfg.PoolRecvShare
+	if share == 0 {
+		share = defaultPoolRecvShare
+	}
+
+	recvMin, sendMin := splitBudget(cfg.PoolBudgetMin, share)
+	recvMax, sendMax := splitBudget(cfg.PoolBudgetMax, share)
+
+	// A pool can't have a max below its min.
+	if recvMax < recvMin {
+		recvMax = recvMin
+	}
+	if sendMax < sendMin {
+		sendMax = sendMin
+	}
+
+	return OptIntPool{
+		RecvMinPoolSize: func() int { return recvMin },
+		RecvMaxPoolSize: func() int { return recvMax },
+		SendMinPoolSize: func() int { return sendMin },
+		SendMaxPoolSize: func() int { return sendMax },
+	}
+}
+
+// splitBudget splits a number of routines between the recv and send pools.
+func splitBudget(total int, share float64) (recv int, send int) {
+	recv = int(math.Round(float64(total) * share))
+	if recv > total-1 {
+		recv = total - 1
+	}
+	if recv < 1 {
+		recv = 1
+	}
+
+	send = total - recv
+	if send < 1 {
+		send = 1
+	}
+
+	return recv, send
+}
@@ -0,0 +1,120 @@
+package tcp
+
+import (
+	"errors"
+	"net"
+	"strings"
+)
+
+// ErrInvalidCIDR is returned when an allow or deny list has an entry that
+// isn't a CIDR, like "10.0.0.0/8", or an IP address.
+var ErrInvalidCIDR = errors.New("Invalid CIDR")
+
+// cidrs is a list of address ranges.
+type cidrs []*net.IPNet
+
+// parseCIDRs parses a list of CIDRs. An IP address without a prefix
+// length is a range holding only that address.
+func parseCIDRs(list []string) (cidrs, error) {
+	nets := make(cidrs, 0, len(list))
+
+	for _, s := range list {
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, ErrInvalidCIDR
+			}
+
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, ErrInvalidCIDR
+		}
+
+		nets = append(nets, n)
+	}
+
+	return nets, nil
+}
+
+// contains reports if the ip is in any of the ranges.
+func (c cidrs) contains(ip net.IP) bool {
+	for _, n := range c {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// SetAllowCIDRs replaces the list of address ranges connections are
+// accepted from. An empty list accepts connections from any address that
+// isn't denied. Only connections accepted after the call are checked,
+// unless DropDeniedConns is set.
+func (t *TCP) SetAllowCIDRs(list []string) error {
+	nets, err := parseCIDRs(list)
+	if err != nil {
+		return err
+	}
+
+	t.allowCIDRs.Store(nets)
+	t.dropDenied()
+	return nil
+}
+
+// SetDenyCIDRs replaces the list of address ranges connections are
+// dropped from. Only connections accepted after the call are checked,
+// unless DropDeniedConns is set.
+func (t *TCP) SetDenyCIDRs(list []string) error {
+	nets, err := parseCIDRs(list)
+	if err != nil {
+		return err
+	}
+
+	t.denyCIDRs.Store(nets)
+	t.dropDenied()
+	return nil
+}
+
+// allowed reports if connections from the address are accepted. The deny
+// list is checked first. Addresses that aren't an IP can only be accepted
+// when there is no allow list.
+func (t *TCP) allowed(addr net.Addr) bool {
+	allow := t.allowCIDRs.Load().(cidrs)
+	deny := t.denyCIDRs.Load().(cidrs)
+
+	a := tcpAddr(addr)
+	if a == nil {
+		return len(allow) == 0
+	}
+
+	if deny.contains(a.IP) {
+		return false
+	}
+
+	return len(allow) == 0 || allow.contains(a.IP)
+}
+
+// dropDenied drops the connections that are no longer allowed when
+// DropDeniedConns is set.
+func (t *TCP) dropDenied() {
+	if !t.DropDeniedConns {
+		return
+	}
+
+	for _, c := range t.copyClients() {
+		if !t.allowed(c.conn.RemoteAddr()) {
+			t.Event(c.traceID, "dropDenied", "*******> DROPPING CONNECTION Remote[ %s ] DUE TO CIDR", c.ipAddress)
+			c.drop(CloseDropped)
+		}
+	}
+}
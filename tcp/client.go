@@ -1,25 +1,120 @@
 package tcp
 
 import (
-	"bytes"
+	"bufio"
+	"context"
+	"errors"
 	"io"
 	"net"
-	"strconv"
+	"os"
 	"sync"
 	"sync/atomic"
+	"syscall"
 	"time"
 )
 
 // client represents a single networked connection.
 type client struct {
 	traceID   string
+	id        uint64
 	t         *TCP
 	conn      net.Conn
 	ipAddress string
+	tcpAddr   *net.TCPAddr // Remote address, nil when it isn't a host and port.
 	isIPv6    bool
-	reader    io.Reader
-	writer    io.Writer
-	wg        sync.WaitGroup
+	bind      *errConn // Connection handed to the ConnHandler.
+	reader    *bufio.Reader
+	bound     atomic.Value // *binding
+	dconn     *deadlineConn
+	pacer     *pacer
+	seq       uint64
+	joined    time.Time
+	firstByte time.Time // Deadline for the first request, zero when there is none.
+
+	pending      int64
+	pendingMax   int64
+	pendingBytes int64 // Bytes in responses waiting to be written.
+	reason       int32
+	lastActivity int64 // Unix nano of the last read or successful write.
+	inFlight     int64 // Requests read that haven't been processed.
+	writeErrs    int64 // Writes that failed in a row.
+
+	sendMu  sync.Mutex
+	gen     uint64 // Responses from an older generation are cancelled.
+	writing int64  // Number of responses being written.
+
+	upgradeMu sync.Mutex
+	upgrade   *handlers // Handlers to switch to before the next read.
+
+	writeMu      sync.Mutex
+	unflushed    *binding    // Binding written to since it was last flushed.
+	flushTimer   *time.Timer // Flushes unflushed after FlushInterval, nil when not scheduled.
+	flushStopped bool        // The connection is being removed, nothing is scheduled.
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	readCtx  context.Context    // Cancelled when the connection is hijacked.
+	stopRead context.CancelFunc // Stops the read routine waiting on the recv pool.
+}
+
+// binding is the set of handlers and the writer a connection is using.
+type binding struct {
+	h      *handlers // Handlers for this connection, nil uses the TCP handlers.
+	writer io.Writer
+	next   io.Writer // Writer bound by the ConnHandler when writer is a buffer the connection owns.
+}
+
+// errConn records the first write error since the error was last taken,
+// so an error the RespHandler didn't report can be carried into the
+// response.
+type errConn struct {
+	net.Conn
+
+	mu  sync.Mutex
+	err error
+}
+
+// Write implements the io.Writer interface.
+func (c *errConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.record(err)
+	return n, err
+}
+
+// record keeps err unless an error is already recorded.
+func (c *errConn) record(err error) {
+	if err == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.err == nil {
+		c.err = err
+	}
+}
+
+// take returns the recorded error and clears it.
+func (c *errConn) take() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	err := c.err
+	c.err = nil
+	return err
+}
+
+// Unwrap returns the accepted connection, which is the *net.TCPConn, or
+// the *tls.Conn when there is a TLSConfig, unless WrapConn replaced it.
+func (c *errConn) Unwrap() net.Conn {
+	if dc, ok := c.Conn.(*deadlineConn); ok {
+		return dc.Conn
+	}
+
+	return c.Conn
 }
 
 // newClient creates a new client for an incoming connection.
@@ -27,104 +122,615 @@ func newClient(traceID string, t *TCP, conn net.Conn) *client {
 	ipAddress := conn.RemoteAddr().String()
 	t.Event(traceID, "newClient", "IPAddress[%s]", ipAddress)
 
+	// Writes go through a connection that manages the write deadline
+	// when there is a write timeout.
+	var dconn *deadlineConn
+	bind := &errConn{Conn: conn}
+	if t.WriteTimeout > 0 || t.WriteProgressTimeout > 0 {
+		dconn = &deadlineConn{Conn: conn, progress: t.WriteProgressTimeout}
+		bind.Conn = dconn
+	}
+
+	// The client must send its first request before the first byte
+	// timeout, which covers the bind.
+	var firstByte time.Time
+	if t.FirstByteTimeout > 0 {
+		firstByte = time.Now().Add(t.FirstByteTimeout)
+		conn.SetReadDeadline(firstByte)
+	}
+
 	// Ask the user to bind the reader and writer they want to
 	// use for this connection.
-	r, w := t.ConnHandler.Bind(traceID, conn)
+	r, w := t.loadHandlers().conn.Bind(traceID, bind)
 
 	c := client{
 		traceID:   traceID,
 		t:         t,
 		conn:      conn,
 		ipAddress: ipAddress,
-		reader:    r,
-		writer:    w,
+		bind:      bind,
+		reader:    t.newReader(r),
+		dconn:     dconn,
+		joined:    time.Now(),
+		firstByte: firstByte,
 	}
+	c.lastActivity = c.joined.UnixNano()
+	w, next := t.newWriter(w)
+	c.bound.Store(&binding{writer: w, next: next})
+
+	// Writes are paced when there is a write rate limit.
+	if t.WriteRateLimit > 0 {
+		c.pacer = &pacer{rate: float64(t.WriteRateLimit)}
+	}
+
+	// The context is cancelled when the connection is removed.
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	c.readCtx, c.stopRead = context.WithCancel(c.ctx)
 
-	// Check to see if this connection is ipv6.
-	if raddr := conn.RemoteAddr().(*net.TCPAddr); raddr.IP.To4() == nil {
+	// Check to see if this connection is ipv6. Connections that don't
+	// have a host and port, like a wrapped connection, are neither.
+	if c.tcpAddr = tcpAddr(conn.RemoteAddr()); c.tcpAddr != nil && c.tcpAddr.IP.To4() == nil {
 		c.isIPv6 = true
 	}
 
 	// Launch a goroutine for this connection.
 	c.wg.Add(1)
+	atomic.AddInt64(&t.goroutines, 1)
 	go c.read()
 
 	return &c
 }
 
+// loadBinding returns the handlers and writer the connection is using.
+func (c *client) loadBinding() *binding {
+	return c.bound.Load().(*binding)
+}
+
+// loadHandlers returns the handlers the connection is using.
+func (c *client) loadHandlers() *handlers {
+	return c.loadBinding().handlers(c.t)
+}
+
+// upgradeTo sets the handlers to switch to and interrupts the read
+// routine so the switch happens before anything else is read. The read
+// deadline is set under the lock so it can't be reset before it is set.
+func (c *client) upgradeTo(h *handlers) {
+	c.upgradeMu.Lock()
+	defer c.upgradeMu.Unlock()
+
+	c.upgrade = h
+	c.conn.SetReadDeadline(time.Now())
+}
+
+// upgradePending reports if the handlers are waiting to be switched.
+func (c *client) upgradePending() bool {
+	c.upgradeMu.Lock()
+	defer c.upgradeMu.Unlock()
+
+	return c.upgrade != nil
+}
+
+// applyUpgrade switches to the handlers set by upgradeTo, binding a new
+// reader and writer. Handlers that are nil are kept. Called from the
+// read routine.
+func (c *client) applyUpgrade() {
+	c.upgradeMu.Lock()
+	defer c.upgradeMu.Unlock()
+
+	if c.upgrade == nil {
+		return
+	}
+
+	hs := *c.loadHandlers()
+	if c.upgrade.conn != nil {
+		hs.conn = c.upgrade.conn
+	}
+	if c.upgrade.req != nil {
+		hs.req = c.upgrade.req
+	}
+	if c.upgrade.resp != nil {
+		hs.resp = c.upgrade.resp
+	}
+
+	c.upgrade = nil
+	c.conn.SetReadDeadline(time.Time{})
+
+	r, w := hs.conn.Bind(c.traceID, c.bind)
+	c.reader = c.t.newReader(r)
+	w, next := c.t.newWriter(w)
+	c.bound.Store(&binding{h: &hs, writer: w, next: next})
+
+	c.t.Event(c.traceID, "applyUpgrade", "Handlers Upgraded : IPAddress[ %s ]", c.ipAddress)
+}
+
+// flush flushes the writer, and then the writer bound by the ConnHandler
+// when the connection owns the buffer in front of it.
+func (b *binding) flush() error {
+	for _, w := range []io.Writer{b.writer, b.next} {
+		if f, ok := w.(flusher); ok {
+			if err := f.Flush(); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// write writes the response with the binding's writer. Only one response
+// is written to a connection at a time. With a FlushInterval, the writer is
+// flushed later by a timer, unless the connection is about to be closed.
+func (c *client) write(traceID string, r *Response, b *binding) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	// The connection belongs to the caller once it is hijacked.
+	if c.hijacked() {
+		return ErrClientGone
+	}
+
+	// A write that failed is an error for the response, even when the
+	// RespHandler didn't set Err.
+	c.bind.take()
+	b.handlers(c.t).resp.Write(traceID, r, b.writer)
+	if err := c.bind.take(); err != nil && r.Err == nil {
+		r.Err = err
+	}
+
+	// Anything written with the binding used before an upgrade has to
+	// go out first.
+	if c.unflushed != nil && c.unflushed != b {
+		if err := c.unflushed.flush(); err != nil {
+			return err
+		}
+		c.unflushed = nil
+	}
+
+	if c.t.FlushInterval == 0 || r.CloseAfterWrite {
+		c.unflushed = nil
+		return b.flush()
+	}
+
+	c.unflushed = b
+	if c.flushTimer == nil && !c.flushStopped {
+		c.flushTimer = time.AfterFunc(c.t.FlushInterval, c.timedFlush)
+	}
+
+	return nil
+}
+
+// timedFlush flushes what has been written since the last flush. A failed
+// flush counts as a failed write.
+func (c *client) timedFlush() {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	c.flushTimer = nil
+	if c.flushStopped || c.unflushed == nil {
+		return
+	}
+
+	err := c.flushUnflushed()
+	if err != nil {
+		c.t.Event(c.traceID, "flush", "ERROR : %v", err)
+	}
+	c.wrote(err)
+}
+
+// stopFlush flushes what has been written since the last flush, when
+// requested, and stops the flush timer for good.
+func (c *client) stopFlush(flush bool) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	c.flushStopped = true
+	if c.flushTimer != nil {
+		c.flushTimer.Stop()
+		c.flushTimer = nil
+	}
+
+	if flush && c.unflushed != nil {
+		if err := c.flushUnflushed(); err != nil {
+			c.t.Event(c.traceID, "flush", "ERROR : %v", err)
+		}
+	}
+	c.unflushed = nil
+}
+
+// flushUnflushed flushes the binding written to since the last flush. The
+// write deadline of the last response may have passed by now, so the flush
+// is given a WriteTimeout of its own. Called with writeMu held.
+func (c *client) flushUnflushed() error {
+	if c.dconn != nil && c.t.WriteTimeout > 0 {
+		c.dconn.setDeadline(time.Now().Add(c.t.WriteTimeout))
+	}
+
+	err := c.unflushed.flush()
+	c.unflushed = nil
+	return err
+}
+
+// handlers returns the handlers for the binding.
+func (b *binding) handlers(t *TCP) *handlers {
+	if b.h != nil {
+		return b.h
+	}
+	return t.loadHandlers()
+}
+
+// track adds a response waiting to be written and returns the generation
+// the response belongs to.
+func (c *client) track() uint64 {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+
+	c.addPending()
+	return c.gen
+}
+
+// startWrite reports if a response from the specified generation can be
+// written. Responses that can are counted as being written until done.
+func (c *client) startWrite(gen uint64) bool {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+
+	if gen != c.gen {
+		return false
+	}
+
+	c.writing++
+	return true
+}
+
+// stopWrite stops counting a response as being written, so it can be
+// cancelled while it waits to be submitted again.
+func (c *client) stopWrite() {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+
+	c.writing--
+}
+
+// done stops tracking a response, which may have been written.
+func (c *client) done(written bool) {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+
+	atomic.AddInt64(&c.pending, -1)
+	if written {
+		c.writing--
+	}
+}
+
+// cancelPending cancels every response that isn't already being written
+// and returns the number cancelled.
+func (c *client) cancelPending() int {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+
+	c.gen++
+	return int(atomic.LoadInt64(&c.pending) - c.writing)
+}
+
+// addPending tracks a response waiting to be written and maintains
+// the high water mark.
+func (c *client) addPending() {
+	n := atomic.AddInt64(&c.pending, 1)
+
+	for {
+		max := atomic.LoadInt64(&c.pendingMax)
+		if n <= max || atomic.CompareAndSwapInt64(&c.pendingMax, max, n) {
+			return
+		}
+	}
+}
+
+// reserve counts bytes for a response waiting to be written and reports
+// if they fit under MaxPendingBytesPerConn. A response larger than the
+// limit is still accepted when nothing else is waiting, so it can be sent.
+func (c *client) reserve(n int64) bool {
+	total := atomic.AddInt64(&c.pendingBytes, n)
+
+	if max := c.t.MaxPendingBytesPerConn; max > 0 && total > max && total != n {
+		atomic.AddInt64(&c.pendingBytes, -n)
+		return false
+	}
+
+	return true
+}
+
+// wrote counts the writes that fail in a row and closes the connection
+// once MaxWriteErrors is reached. A successful write resets the count.
+func (c *client) wrote(err error) {
+	if err == nil {
+		atomic.StoreInt64(&c.writeErrs, 0)
+		return
+	}
+
+	n := atomic.AddInt64(&c.writeErrs, 1)
+	if c.t.MaxWriteErrors > 0 && n == int64(c.t.MaxWriteErrors) {
+		c.t.Event(c.traceID, "write", "*******> DROPPING CONNECTION Remote[ %s ] AFTER %d WRITE ERRORS : %v", c.ipAddress, n, err)
+		c.close(CloseWriteErrors)
+	}
+}
+
+// touch records activity on the connection.
+func (c *client) touch(now time.Time) {
+	atomic.StoreInt64(&c.lastActivity, now.UnixNano())
+}
+
+// remoteAddr returns a copy of the remote address the caller can keep.
+func (c *client) remoteAddr() *net.TCPAddr {
+	if c.tcpAddr == nil {
+		return nil
+	}
+
+	addr := *c.tcpAddr
+	return &addr
+}
+
+// info returns a snapshot of information about the connection.
+func (c *client) info() ConnInfo {
+	return ConnInfo{
+		Addr:         c.ipAddress,
+		ID:           c.id,
+		ConnectedAt:  c.joined,
+		LastActivity: time.Unix(0, atomic.LoadInt64(&c.lastActivity)),
+		Pending:      atomic.LoadInt64(&c.pending),
+		PendingMax:   atomic.LoadInt64(&c.pendingMax),
+		PendingBytes: atomic.LoadInt64(&c.pendingBytes),
+		InFlight:     atomic.LoadInt64(&c.inFlight),
+	}
+}
+
 // drop closes the client connection and read operation.
-func (c *client) drop() {
-	// Close the connection.
-	c.conn.Close()
+func (c *client) drop(reason CloseReason) {
+	c.close(reason)
 	c.wg.Wait()
 
 	c.t.Event(c.traceID, "drop", "Client Dropped")
 }
 
+// close closes the client connection without waiting for the read
+// operation, which removes the connection once it sees the close. A
+// hijacked connection is left open for its new owner.
+func (c *client) close(reason CloseReason) {
+	c.setReason(reason)
+	if c.hijacked() {
+		return
+	}
+	c.conn.Close()
+}
+
+// gone reports if the connection has been removed or is closing.
+func (c *client) gone() bool {
+	return atomic.LoadInt32(&c.reason) != 0 || c.ctx.Err() != nil
+}
+
+// setReason records why the connection is being closed. Only the
+// first reason recorded is kept.
+func (c *client) setReason(reason CloseReason) {
+	atomic.CompareAndSwapInt32(&c.reason, 0, int32(reason))
+}
+
 // read waits for a message and sends it to the user for procesing.
 func (c *client) read() {
 	c.t.Event(c.traceID, "read", "Read Processing")
 
+	// backoff is how long to wait before retrying after a temporary error.
+	var backoff time.Duration
+
+	// waitFirst is set while the first byte timeout is running.
+	waitFirst := c.t.FirstByteTimeout > 0
+
 close:
 	for {
+		// Stop reading once a request has hijacked the connection.
+		if c.hijacked() {
+			break close
+		}
+
+		// Switch handlers if a request asked to upgrade.
+		c.applyUpgrade()
+
+		// Pause reading while the recv pool is saturated so TCP flow
+		// control pushes back on the client instead of buffering here.
+		c.backpressure()
+
 		// Wait for a message to arrive.
-		data, length, err := c.t.ReqHandler.Read(c.traceID, c.ipAddress, c.reader)
+		b := c.loadBinding()
+		data, length, err := c.readRequest(b, waitFirst)
 		timeRead := time.Now()
 
 		if err != nil {
+			// The read was interrupted to hand over the connection.
+			if c.hijacked() {
+				break close
+			}
+
+			// The read was interrupted to upgrade the handlers.
+			if c.upgradePending() {
+				continue
+			}
+
 			if atomic.LoadInt32(&c.t.shuttingDown) == 0 {
 				c.t.Event(c.traceID, "read", "ERROR : %v", err)
 			}
 
-			// temporary is declared to test for the existence of
-			// the method coming from the net package.
-			type temporary interface {
-				Temporary() bool
+			if err == io.EOF {
+				c.setReason(CloseClientEOF)
+				break close
 			}
 
-			if e, ok := err.(temporary); ok {
-				if !e.Temporary() {
-					break close
-				}
+			// The client connected and never sent a request.
+			if waitFirst && errors.Is(err, os.ErrDeadlineExceeded) {
+				c.t.Event(c.traceID, "read", "*******> DROPPING CONNECTION Remote[ %s ] DUE TO FIRST BYTE TIMEOUT %v", c.ipAddress, c.t.FirstByteTimeout)
+				c.setReason(CloseFirstByteTimeout)
+				break close
 			}
 
-			if err == io.EOF {
+			// The client is trickling a request in too slowly.
+			if c.t.MaxReadDuration > 0 && errors.Is(err, os.ErrDeadlineExceeded) {
+				c.t.Event(c.traceID, "read", "*******> DROPPING CONNECTION Remote[ %s ] DUE TO MAX READ DURATION %v", c.ipAddress, c.t.MaxReadDuration)
+				c.setReason(CloseReadTimeout)
+				break close
+			}
+
+			// Only temporary errors are retried, anything else means
+			// the connection can't be trusted anymore.
+			if e, ok := err.(temporary); !ok || !e.Temporary() {
+				c.setReason(readReason(err))
 				break close
 			}
 
+			// Backoff before trying again so a failing connection
+			// doesn't spin.
+			if backoff == 0 {
+				backoff = minReadBackoff
+			} else if backoff *= 2; backoff > maxReadBackoff {
+				backoff = maxReadBackoff
+			}
+			time.Sleep(backoff)
+
 			continue
 		}
 
-		// Convert the IP:socket for populating TCPAddr value.
-		parts := bytes.Split([]byte(c.ipAddress), []byte(":"))
-		ipAddress := string(parts[0])
-		port, _ := strconv.Atoi(string(parts[1]))
+		backoff = 0
+		c.touch(timeRead)
+
+		// The client has sent its first request, so stop the first
+		// byte timeout.
+		if waitFirst {
+			waitFirst = false
+			c.conn.SetReadDeadline(time.Time{})
+		}
+
+		// Requests on this connection are numbered starting at 1.
+		c.seq++
 
 		// Create the request.
 		r := Request{
-			TCP: c.t,
-			TCPAddr: &net.TCPAddr{
-				IP:   net.ParseIP(ipAddress),
-				Port: port,
-				Zone: c.t.tcpAddr.Zone,
-			},
-			IsIPv6: c.isIPv6,
-			ReadAt: timeRead,
-			Data:   data,
-			Length: length,
+			TCP:     c.t,
+			TCPAddr: c.remoteAddr(),
+			IsIPv6:  c.isIPv6,
+			ReadAt:  timeRead,
+			Seq:     c.seq,
+			Data:    data,
+			Length:  length,
+			Conn:    c.conn,
+
+			client:   c,
+			bound:    b,
+			buffered: len(data),
 		}
 
-		// Send this to the user work pool for processing.
-		c.t.recv.Do(c.traceID, &r)
+		// The request data is buffered and the request is in flight
+		// until it has been processed.
+		atomic.AddInt64(&c.t.buffered, int64(r.buffered))
+		atomic.AddInt64(&c.inFlight, 1)
+
+		// Send this to the user work pool for processing. Give up if
+		// the connection is hijacked while waiting, since the request
+		// hijacking it may hold the only routine in the pool.
+		if err := c.t.doRecvCancel(c.readCtx, c.traceID, &r); err != nil {
+			atomic.AddInt64(&c.t.buffered, -int64(r.buffered))
+			atomic.AddInt64(&c.inFlight, -1)
+
+			c.t.Event(c.traceID, "read", "Request Discarded : Connection Hijacked : Seq[ %d ]", r.Seq)
+			break close
+		}
 	}
 
 	c.t.Event(c.traceID, "read", "Shutting Down Client Routine")
 
 	// Remove from the list of connections.
-	c.t.remove(c.traceID, c.conn)
+	c.t.remove(c.traceID, c.conn, CloseReason(atomic.LoadInt32(&c.reason)))
 
+	atomic.AddInt64(&c.t.goroutines, -1)
 	c.wg.Done()
 
 	c.t.Event(c.traceID, "read", "Client Routine Down")
 	return
 }
+
+// readRequest calls the ReqHandler to read the next request. With a
+// MaxReadDuration, the connection can be idle for as long as it likes
+// between requests, but once the first byte of a request arrives the
+// whole request must be read within the duration.
+func (c *client) readRequest(b *binding, waitFirst bool) ([]byte, int, error) {
+	h := b.handlers(c.t).req
+	if c.t.MaxReadDuration <= 0 {
+		return h.Read(c.traceID, c.ipAddress, c.reader)
+	}
+
+	if _, err := c.reader.Peek(1); err != nil {
+		return nil, 0, err
+	}
+
+	// The first byte timeout still applies if it ends sooner.
+	deadline := time.Now().Add(c.t.MaxReadDuration)
+	if waitFirst && c.firstByte.Before(deadline) {
+		deadline = c.firstByte
+	}
+	c.setReadDeadline(deadline)
+
+	data, length, err := h.Read(c.traceID, c.ipAddress, c.reader)
+	if err == nil {
+		c.setReadDeadline(time.Time{})
+	}
+
+	return data, length, err
+}
+
+// setReadDeadline sets the read deadline, unless the read is being
+// interrupted to upgrade the handlers or hand over the connection.
+func (c *client) setReadDeadline(t time.Time) {
+	c.upgradeMu.Lock()
+	defer c.upgradeMu.Unlock()
+
+	if c.upgrade == nil && !c.hijacked() {
+		c.conn.SetReadDeadline(t)
+	}
+}
+
+// backpressure blocks while the number of requests waiting to be accepted
+// by the recv pool, the number of requests in flight for the connection or
+// the number of bytes buffered is at or above the configured limit. A
+// paused connection can't see the client go away, so it stops waiting once
+// the connection is being dropped.
+func (c *client) backpressure() {
+	if c.t.MaxRecvPending <= 0 && c.t.MaxBufferedBytes <= 0 && c.t.MaxInFlightPerConn <= 0 {
+		return
+	}
+
+	for c.saturated() {
+		if atomic.LoadInt32(&c.t.shuttingDown) == 1 || atomic.LoadInt32(&c.reason) != 0 {
+			return
+		}
+		time.Sleep(backpressurePoll)
+	}
+}
+
+// saturated reports if the recv pool has too many requests waiting, the
+// connection has too many requests unprocessed or too many bytes are
+// buffered across all connections.
+func (c *client) saturated() bool {
+	if c.t.MaxRecvPending > 0 && c.t.StatsRecv().Pending >= int64(c.t.MaxRecvPending) {
+		return true
+	}
+
+	if c.t.MaxInFlightPerConn > 0 && atomic.LoadInt64(&c.inFlight) >= int64(c.t.MaxInFlightPerConn) {
+		return true
+	}
+
+	return c.t.bufferFull()
+}
+
+// readReason classifies the error that stopped the read routine.
+func readReason(err error) CloseReason {
+	if errors.Is(err, syscall.ECONNRESET) {
+		return CloseReset
+	}
+
+	return CloseReadError
+}
@@ -0,0 +1,89 @@
+package tcp
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"net"
+)
+
+// CompressingRespHandler wraps a RespHandler and compresses the response
+// data before it is written. The compressed data is swapped into the
+// response only while the wrapped handler runs, so the original data is
+// restored before Complete is called and any Err set by the handler is
+// kept.
+//
+// Each response is compressed into a stream of its own. With a
+// WriteBufferSize or FlushInterval, several compressed responses are
+// coalesced into one write to the connection, but the client still sees
+// one complete gzip or deflate stream per response. Data and Buffers are
+// compressed together into a single slice, so a response with Buffers is
+// no longer sent with a vectored write.
+type CompressingRespHandler struct {
+	RespHandler RespHandler            // Handler used to write the response.
+	MinSize     int                    // Responses smaller than this are written uncompressed.
+	Level       int                    // Compression level, 0 uses the default level.
+	Deflate     bool                   // Use raw deflate instead of gzip.
+	Accept      func(r *Response) bool // Reports if the client accepts compressed data, nil means always.
+}
+
+// Write implements the RespHandler interface. If the response is too small
+// or can't be compressed, it is written as is.
+func (h CompressingRespHandler) Write(traceID string, r *Response, writer io.Writer) {
+	if r.size() < h.MinSize || (h.Accept != nil && !h.Accept(r)) {
+		h.RespHandler.Write(traceID, r, writer)
+		return
+	}
+
+	// Data and Buffers are compressed as one stream.
+	data, err := h.compress(append(net.Buffers{r.Data}, r.Buffers...))
+	if err != nil {
+		if r.tcp != nil {
+			r.tcp.Event(traceID, "CompressingRespHandler", "ERROR : %v", err)
+		}
+		h.RespHandler.Write(traceID, r, writer)
+		return
+	}
+
+	origData, origBuffers, origLength := r.Data, r.Buffers, r.Length
+	r.Data = data
+	r.Buffers = nil
+	r.Length = len(data)
+
+	h.RespHandler.Write(traceID, r, writer)
+
+	r.Data, r.Buffers, r.Length = origData, origBuffers, origLength
+}
+
+// compress returns the compressed version of the data. Closing the
+// compressor flushes all the data into the buffer.
+func (h CompressingRespHandler) compress(data net.Buffers) ([]byte, error) {
+	level := h.Level
+	if level == 0 {
+		level = flate.DefaultCompression
+	}
+
+	var buf bytes.Buffer
+
+	var cw io.WriteCloser
+	var err error
+	if h.Deflate {
+		cw, err = flate.NewWriter(&buf, level)
+	} else {
+		cw, err = gzip.NewWriterLevel(&buf, level)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := data.WriteTo(cw); err != nil {
+		return nil, err
+	}
+
+	if err := cw.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
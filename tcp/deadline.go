@@ -0,0 +1,67 @@
+package tcp
+
+import (
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// writeChunkSize is the most written to a deadlineConn before the write
+// deadline is moved forward.
+const writeChunkSize = 16 * 1024
+
+// deadlineConn sets the write deadline before writing to the connection.
+// With a progress timeout, large writes are split into chunks and the
+// deadline is moved forward as each chunk is written, so a slow peer that
+// is making progress isn't treated like one that has stalled.
+type deadlineConn struct {
+	net.Conn
+	progress time.Duration // Time allowed for each chunk to be written, 0 for none.
+	deadline int64         // Absolute write deadline in Unix nanoseconds, 0 for none.
+}
+
+// Write implements the io.Writer interface.
+func (c *deadlineConn) Write(p []byte) (int, error) {
+	var written int
+
+	for len(p) > 0 {
+		n := len(p)
+		if c.progress > 0 && n > writeChunkSize {
+			n = writeChunkSize
+		}
+
+		c.Conn.SetWriteDeadline(c.next())
+
+		m, err := c.Conn.Write(p[:n])
+		written += m
+		if err != nil {
+			return written, err
+		}
+
+		p = p[n:]
+	}
+
+	return written, nil
+}
+
+// setDeadline sets the absolute deadline for writes to the connection.
+func (c *deadlineConn) setDeadline(t time.Time) {
+	atomic.StoreInt64(&c.deadline, t.UnixNano())
+}
+
+// next returns the deadline for the next chunk, the sooner of the
+// progress timeout and the absolute deadline.
+func (c *deadlineConn) next() time.Time {
+	var d time.Time
+	if c.progress > 0 {
+		d = time.Now().Add(c.progress)
+	}
+
+	if abs := atomic.LoadInt64(&c.deadline); abs != 0 {
+		if t := time.Unix(0, abs); d.IsZero() || t.Before(d) {
+			d = t
+		}
+	}
+
+	return d
+}
@@ -4,6 +4,9 @@
 // of received data and sending data happens on a configured routine pool, so concurrency
 // is handled.
 //
+// The udp package provides the same pool based model for datagrams, with handlers
+// keyed by the remote address instead of a connection.
+//
 // There are three interfaces that need to be implemented to use the package. These
 // interfaces provide the API for processing data.
 //
@@ -14,7 +17,19 @@
 //     }
 //
 // The ConnHandler interface is implemented by the user to bind the client connection
-// to a reader and writer for processing.
+// to a reader and writer for processing. Bind is not given the accepted connection
+// itself but a wrapper that applies the write timeouts and records write errors, so
+// a type assertion for *net.TCPConn or *tls.Conn fails. The wrapper has an Unwrap
+// method that returns the accepted connection:
+//
+//     if u, ok := conn.(interface{ Unwrap() net.Conn }); ok {
+//         if tc, ok := u.Unwrap().(*net.TCPConn); ok {
+//             tc.SetNoDelay(false)
+//         }
+//     }
+//
+// Read and write through the connection given to Bind so the timeouts and errors
+// are still handled.
 //
 // ReqHandler
 //
@@ -26,14 +41,61 @@
 //     type Request struct {
 //         TCP       *TCP
 //         TCPAddr   *net.TCPAddr
+//         IsIPv6    bool
+//         ReadAt    time.Time
+//         Seq       uint64
 //         Data      []byte
 //         Length    int
+//         Conn      net.Conn
 //     }
 //
 // The ReqHandler interface is implemented by the user to implement the processing
-// of request messages from the client. Read is provided an ipaddress and the user-defined
-// reader and must return the data read off the wire and the length. Returning io.EOF or a non
-// temporary error will show down the listener.
+// of request messages from the client. Read is provided an ipaddress and a *bufio.Reader
+// owned by the connection that wraps the user-defined reader, and must return the data
+// read off the wire and the length. Bytes buffered past the end of one request are kept
+// for the next call to Read, so Read must take the bytes it needs from the reader it is
+// given rather than wrapping it in a buffer of its own. Returning a temporary error causes Read to be called again
+// after a short backoff. Returning io.EOF or any other error will close the connection.
+//
+// The Conn field gives handlers access to the underlying connection for things like
+// reading socket options. Handlers must not close it directly, since the connection
+// is still owned by the TCP value. Return an error from Read, call DropConnection with
+// the address of the connection, or set CloseAfterWrite on the final response to have
+// it closed.
+//
+// Set WrapConn to wrap each accepted connection before it is handed to Bind, for
+// things like counting bytes or injecting latency in tests. The wrapped connection is
+// used for everything from then on. When a TLSConfig is set, WrapConn is applied after
+// TLS, so it is given the *tls.Conn and sees the decrypted data.
+//
+// The TLSConfig is used as given and never copied into a fixed set of certificates,
+// so GetCertificate and GetConfigForClient are called for every handshake. This lets
+// a certificate manager like autocert pick or renew certificates while the listener
+// is running, by setting GetCertificate to the manager's GetCertificate method.
+//
+// The TLS handshake is run before a connection is added, in a routine of its own so
+// accepting isn't held up. A client that fails the handshake, or doesn't finish it
+// within HandshakeTimeout, is closed without being counted in AcceptedTotal or having
+// Bind called. HandshakeTimeout defaults to 10 seconds.
+//
+// Set MaxConcurrentHandshakes to limit the TLS handshakes running at once, since each
+// one costs CPU and a flood of them can starve the clients already connected. A
+// connection that can't start its handshake within a brief wait is dropped and counted
+// in Drops under DropReasonHandshakes.
+//
+// When the TLSConfig sets MinVersion, a client that only supports older versions fails
+// the handshake with ErrTLSVersionRejected and is counted in Drops under
+// DropReasonTLSVersion, so clients still attempting old TLS can be audited. The client
+// is closed before it is added, so it isn't counted as accepted or bound.
+//
+// A connection can switch to a new set of handlers mid stream, like after a STARTTLS
+// request, by calling Request.UpgradeHandlers from Process. Only that connection is
+// affected, and responses built with Request.NewResponse are written with the writer
+// the request was read with.
+//
+// A connection that has to become a raw byte stream, like a tunnel, can be taken over
+// by calling Request.Hijack from Process. The connection is removed with CloseHijacked
+// but left open, and from then on the caller owns it and must close it.
 //
 // RespHandler
 //
@@ -42,14 +104,129 @@
 //     }
 //
 //     type Response struct {
-//         TCPAddr   *net.TCPAddr
-//         Data      []byte
-//         Length    int
+//         TCPAddr         *net.TCPAddr
+//         Seq             uint64
+//         ReadAt          time.Time
+//         Data            []byte
+//         Buffers         net.Buffers
+//         Length          int
+//         Deadline        time.Time
+//         Complete        func(r *Response)
+//         Err             error
+//         Sent            chan error
+//         CloseAfterWrite bool
 //     }
 //
 // The RespHandler interface is implemented by the user to implement the processing
 // of the response messages to the client. Write is provided the user-defined
-// writer and the data to write.
+// writer and the data to write. If the writer has a Flush method, it is flushed
+// after Write returns and before Complete is called, with any error reported in
+// the Err field. A write to the connection that fails during Write is reported in
+// Err as well, when Write doesn't set Err itself. A response with a Deadline that has passed by the time it is taken
+// off the send pool is not written and Complete is called with ErrStale. The same
+// goes for a response to a client that was removed while it waited, with ErrClientGone.
+//
+// Set WriteBufferSize to have each connection own a *bufio.Writer of that size in front
+// of the writer returned by Bind. Write is then given the *bufio.Writer, so the small
+// writes a RespHandler makes for one response are sent with a single write to the
+// connection. The connection owns the buffer, so Write must not keep it. The buffer,
+// and then the writer returned by Bind, are flushed after Write returns and before
+// Complete is called, so a completed response has been handed to the kernel.
+//
+// Set FlushInterval to batch responses under load instead. Each connection then flushes
+// on a timer, at most FlushInterval after a response is written, which bounds how long a
+// response can sit in the buffer when traffic is light. Complete is called once the
+// response is in the buffer, and a response with CloseAfterWrite is still flushed right
+// away. Only one response is written to a connection at a time.
+//
+// To wait for a response with select, set Sent to a channel with a buffer of at least
+// one. The value of Err is sent on it after Complete is called. The send is skipped
+// when the channel has no room, so the send pool is never blocked. Do returns
+// ErrSentUnbuffered for a channel with no buffer. Errors returned by Do are not sent.
+//
+// A response made of several segments, like a header and a body, can carry them in
+// Buffers instead of copying them into Data. Response.WriteTo writes Data followed by
+// Buffers, with a single vectored write when the writer is the connection itself.
+//
+// Set CloseAfterWrite to close the connection once the response has been written and
+// flushed, like for a final error message. This avoids racing a separate drop against
+// the write. The connection is not closed if the write fails.
+//
+// Set RequestMiddleware to check or decode every request in one place, like to
+// validate an auth token, instead of doing it in each ReqHandler. It is called from
+// the recv pool right before Process. Returning nil has the request processed.
+// Returning ErrSkipRequest skips the request and keeps the connection. Returning any
+// other error skips the request and drops the connection, and requests from that
+// connection already waiting on the recv pool are still given to the middleware.
+//
+// Set ResponseMiddleware to apply a behavior shared by every response in one place,
+// like adding a protocol header, instead of adding it to each RespHandler. It is
+// called from the send pool right before the RespHandler, and can change the response
+// in place. It is not called for responses that are cancelled, stale or for a client
+// that is gone.
+//
+// Each request read from a connection is given a sequence number, starting at 1
+// for every new connection. Use Request.NewResponse to build a response that
+// carries the sequence number and read time of the request. Responses are written
+// from a pool of routines, so responses to the same client may be written in a
+// different order than the requests were read. Clients that need ordering must
+// use the sequence number to restore it. Writes are not held back to put them in
+// order, since a request that is never answered would stall every response after it.
+//
+// The work pools grow from their min toward their max number of routines as load
+// increases, and shrink back to the min as soon as they go idle. There is no way to
+// warm a pool above its min ahead of traffic, since the first idle submission would
+// shrink it again. To avoid paying for routine creation on a cold start, set the min
+// pool sizes to the number of routines the first burst needs. The sizes are functions,
+// so they can be lowered once traffic settles, trading idle routines for latency.
+//
+// Instead of the four pool sizes, PoolBudgetMin and PoolBudgetMax can set the total
+// number of routines across both pools, with PoolRecvShare deciding how much of it
+// goes to the recv pool. A service that mostly reads, for example, could give the
+// recv pool a share of 0.75. Each pool is always given at least one routine.
+//
+// Set FirstByteTimeout to drop clients that connect and never send anything. It
+// covers the time from the connection being added, through Bind, until the first
+// request is read. Once a request has been read the timeout no longer applies.
+//
+// Set MaxReadDuration to drop clients that trickle a request in a byte at a time to
+// hold the connection open. The connection can be idle between requests for as long
+// as it likes, but once the first byte of a request arrives, Read must return the
+// whole request within the duration.
+//
+// Set AcceptFilters to decide which connections are accepted with a chain of checks
+// that can each be written and tested on their own. The filters are called in order
+// after the built in checks, like the rate limit and AdmitFunc, and the first filter
+// that doesn't allow a connection has it dropped with the reason it returned. With a
+// TLSConfig, the filters are given the connection before TLS.
+//
+// Set ProbeFunc to answer health probes, like a load balancer connecting or sending
+// a tiny payload, without the connection being added as a client. It is called in a
+// routine of its own for every new connection that passes the filters, so accepting
+// isn't held up. Reads from the connection time out after ProbeTimeout, which defaults
+// to 100ms, and a read deadline can't be set past it. With a TLSConfig, ProbeFunc is
+// given the connection before TLS and the handshake runs after it in the same routine,
+// which counts toward MaxConcurrentHandshakes. When it reports the connection was a probe, the
+// connection is closed and only counted in Stats. Otherwise the deadline is cleared
+// and anything it read is replayed to the ConnHandler. A connection ProbeFunc read
+// nothing from is used as is.
+//
+// Call PauseAccept to stop accepting new connections for a short time, like during a
+// quick reconfiguration, while existing connections keep being serviced. Unlike
+// DropConnections, paused connections are not accepted and closed, they wait in the
+// listen backlog until accepting resumes.
+//
+// Set AllowCIDRs and DenyCIDRs to accept connections only from some address ranges,
+// or to drop connections from others. The deny list is checked first. The lists can be
+// replaced while running with SetAllowCIDRs and SetDenyCIDRs, like to ban a range that
+// is attacking the service. The new lists apply to connections accepted after the call.
+// Connections already accepted are kept, unless DropDeniedConns is set, in which case
+// any connection the new lists don't allow is dropped right away.
+//
+// Read from Events to keep an external registry of connections in step with the
+// manager. Each connection gets a Connected event when it joins and a Disconnected
+// event with the close reason when it is removed. Events are dropped when the channel
+// is full, so a registry that can't miss one should use the Disconnect hook instead.
 //
 // Sample Application
 //
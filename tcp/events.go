@@ -0,0 +1,56 @@
+package tcp
+
+import "time"
+
+// eventsBuffer is the number of connection events held for Events before
+// events are dropped.
+const eventsBuffer = 256
+
+// ConnEventType identifies what happened to a client connection.
+type ConnEventType int
+
+// Set of connection event types.
+const (
+	Connected    ConnEventType = iota + 1 // Connection was added to the manager.
+	Disconnected                          // Connection was removed from the manager.
+)
+
+// String returns a short description of the event type.
+func (e ConnEventType) String() string {
+	switch e {
+	case Connected:
+		return "connected"
+	case Disconnected:
+		return "disconnected"
+	}
+
+	return "unknown"
+}
+
+// ConnEvent describes a client connection being added to or removed from
+// the manager.
+type ConnEvent struct {
+	Type   ConnEventType
+	Addr   string      // Remote address of the connection.
+	ID     uint64      // ID of the connection, unique for the TCP value.
+	Time   time.Time   // When the event happened.
+	Reason CloseReason // Why the connection was removed, 0 for Connected.
+}
+
+// Events returns a channel that receives an event each time a client
+// connection is added to or removed from the manager. The channel has a
+// buffer and events are dropped when it is full, so joining and removing
+// connections never wait on a reader that isn't keeping up. Consumers that
+// can't miss an event should use the ConnHandler and Disconnect hooks. The
+// channel is never closed.
+func (t *TCP) Events() <-chan ConnEvent {
+	return t.events
+}
+
+// event sends the connection event without blocking.
+func (t *TCP) event(e ConnEvent) {
+	select {
+	case t.events <- e:
+	default:
+	}
+}
@@ -0,0 +1,53 @@
+package tcp
+
+import (
+	"net"
+	"time"
+)
+
+// CloseListener closes the listener out from under the accept routine,
+// which forces a non temporary accept error.
+func (t *TCP) CloseListener() {
+	t.listenerMu.Lock()
+	{
+		t.listener.Close()
+	}
+	t.listenerMu.Unlock()
+}
+
+// FailListen makes every attempt to re-establish the listener fail
+// with the specified error.
+func (t *TCP) FailListen(err error) {
+	t.listenFn = func(addr *net.TCPAddr) (net.Listener, error) {
+		return nil, err
+	}
+}
+
+// PoolSizes returns the min and max number of routines of the recv and
+// send pools.
+func (t *TCP) PoolSizes() (recvMin, recvMax, sendMin, sendMax int) {
+	t.recvMu.RLock()
+	defer t.recvMu.RUnlock()
+	t.sendMu.RLock()
+	defer t.sendMu.RUnlock()
+
+	return t.recv.MinRoutines(), t.recv.MaxRoutines(), t.send.MinRoutines(), t.send.MaxRoutines()
+}
+
+// UseListener makes the listener the one established by Start.
+func (t *TCP) UseListener(l net.Listener) {
+	t.listenFn = func(addr *net.TCPAddr) (net.Listener, error) {
+		return l, nil
+	}
+}
+
+// RollingRate returns the rate of the events at the specified times over
+// the window ending at now.
+func RollingRate(events []time.Time, now time.Time) float64 {
+	var r rollingCount
+	for _, e := range events {
+		r.add(e)
+	}
+
+	return r.rate(now)
+}
@@ -0,0 +1,26 @@
+//go:build linux
+// +build linux
+
+package tcp
+
+import "syscall"
+
+// tcpFastOpen is the TCP_FASTOPEN socket option, which the syscall
+// package doesn't declare.
+const tcpFastOpen = 0x17
+
+// fastOpenSupported reports if TCP Fast Open can be enabled.
+const fastOpenSupported = true
+
+// setFastOpen enables TCP Fast Open on the socket with a queue for the
+// specified number of pending fast open requests.
+func setFastOpen(rc syscall.RawConn, queue int) error {
+	var serr error
+	if err := rc.Control(func(fd uintptr) {
+		serr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_TCP, tcpFastOpen, queue)
+	}); err != nil {
+		return err
+	}
+
+	return serr
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package tcp
+
+import "syscall"
+
+// fastOpenSupported reports if TCP Fast Open can be enabled.
+const fastOpenSupported = false
+
+// setFastOpen is only supported on linux.
+func setFastOpen(rc syscall.RawConn, queue int) error {
+	return ErrFastOpenNotSupported
+}
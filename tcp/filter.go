@@ -0,0 +1,94 @@
+package tcp
+
+import (
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// AcceptFilter is called for each accepted connection and reports if the
+// connection is allowed. The reason is reported to OnDrop when it is not,
+// an empty reason is reported as DropReasonFilter.
+type AcceptFilter func(conn net.Conn) (allow bool, reason string)
+
+// acceptFilters returns the chain of filters each accepted connection must
+// pass, in order. The built in checks come first, followed by the filters
+// provided by the user.
+func (t *TCP) acceptFilters(traceID string) []AcceptFilter {
+	var filters []AcceptFilter
+
+	// Check if we are being asked to drop all new connections.
+	filters = append(filters, func(conn net.Conn) (bool, string) {
+		if drop := atomic.LoadInt32(&t.dropConns); drop == 1 {
+			t.Event(traceID, "accept", "*******> DROPPING CONNECTION")
+			return false, DropReasonDropConns
+		}
+		return true, ""
+	})
+
+	// Check the remote address is in an allowed range.
+	filters = append(filters, func(conn net.Conn) (bool, string) {
+		if !t.allowed(conn.RemoteAddr()) {
+			t.Event(traceID, "accept", "*******> DROPPING CONNECTION Remote[ %v ] DUE TO CIDR", conn.RemoteAddr())
+			return false, DropReasonCIDR
+		}
+		return true, ""
+	})
+
+	// Check if rate limit is enabled.
+	if t.RateLimit != nil {
+		filters = append(filters, func(conn net.Conn) (bool, string) {
+			now := time.Now()
+
+			// We will only accept 1 connection per duration after the
+			// burst is used up. Any connection above that must be
+			// dropped.
+			if !t.connLimit.allow(now, t.RateLimit(), t.RateLimitBurst) {
+				t.Event(traceID, "accept", "*******> DROPPING CONNECTION Local[ %v ] Remote[ %v ] DUE TO RATE LIMIT %v", conn.LocalAddr(), conn.RemoteAddr(), t.RateLimit())
+				return false, DropReasonRateLimit
+			}
+			return true, ""
+		})
+	}
+
+	// Shed the connection while the host is overloaded.
+	if t.LoadShedFunc != nil {
+		filters = append(filters, func(conn net.Conn) (bool, string) {
+			if t.LoadShedFunc() {
+				t.Event(traceID, "accept", "*******> DROPPING CONNECTION Remote[ %v ] LOAD SHED", conn.RemoteAddr())
+				return false, DropReasonLoadShed
+			}
+			return true, ""
+		})
+	}
+
+	// Let the user decide if there is capacity for the connection.
+	if t.AdmitFunc != nil {
+		filters = append(filters, func(conn net.Conn) (bool, string) {
+			if !t.AdmitFunc(conn.RemoteAddr().String()) {
+				t.Event(traceID, "accept", "*******> DROPPING CONNECTION Remote[ %v ] NOT ADMITTED", conn.RemoteAddr())
+				atomic.AddUint64(&t.notAdmitted, 1)
+				return false, DropReasonAdmit
+			}
+			return true, ""
+		})
+	}
+
+	return append(filters, t.AcceptFilters...)
+}
+
+// filterConn runs the connection through the chain of filters, stopping at
+// the first one that doesn't allow it. It reports the reason the connection
+// is not allowed.
+func filterConn(filters []AcceptFilter, conn net.Conn) (bool, string) {
+	for _, f := range filters {
+		if allow, reason := f(conn); !allow {
+			if reason == "" {
+				reason = DropReasonFilter
+			}
+			return false, reason
+		}
+	}
+
+	return true, ""
+}
@@ -1,16 +1,31 @@
 package tcp
 
 import (
+	"context"
 	"io"
 	"net"
+	"sync/atomic"
 	"time"
 )
 
+// handlers holds the set of handlers currently used by a TCP value.
+type handlers struct {
+	conn ConnHandler
+	req  ReqHandler
+	resp RespHandler
+}
+
+//==============================================================================
+
 // ConnHandler is implemented by the user to bind the connection
 // to a reader and writer for processing.
 type ConnHandler interface {
 
-	// Bind is called to set the reader and writer.
+	// Bind is called to set the reader and writer. The conn is a wrapper
+	// that applies the write timeouts and records write errors, so read
+	// and write through it. It has an Unwrap() net.Conn method that
+	// returns the accepted connection for things like SetNoDelay or
+	// ConnectionState.
 	Bind(traceID string, conn net.Conn) (io.Reader, io.Writer)
 }
 
@@ -20,14 +35,15 @@ type ConnHandler interface {
 // of request messages from the client.
 type ReqHandler interface {
 
-	// Read is provided a request and a user-defined reader for each client
-	// connection on its own routine. Read must read a full request and return
-	// the populated request value.
-	// Returning io.EOF or a non temporary error will show down the connection.
-
-	// Read is provided an ipaddress and the user-defined reader and must return
-	// the data read off the wire and the length. Returning io.EOF or a non
-	// temporary error will show down the listener.
+	// Read is provided an ipaddress and a reader for each client connection
+	// on its own routine and must return the data read off the wire and the
+	// length. The reader is a *bufio.Reader owned by the connection that wraps
+	// the user-defined reader, so data buffered past the end of a request is
+	// still available on the next call. Read must not wrap the reader in
+	// another buffer that outlives the call, since bytes read ahead into it
+	// are lost. Returning an error with a Temporary method that reports true
+	// causes Read to be called again after a short backoff. Any other error,
+	// including io.EOF, will close the connection.
 	Read(traceID string, ipAddress string, reader io.Reader) ([]byte, int, error)
 
 	// Process is used to handle the processing of the request. This method
@@ -35,20 +51,122 @@ type ReqHandler interface {
 	Process(traceID string, r *Request)
 }
 
+// ReqContextHandler can be implemented by a ReqHandler to have requests
+// processed with a context that is cancelled when the client connection
+// is removed. When implemented, ProcessContext is called instead of Process.
+type ReqContextHandler interface {
+
+	// ProcessContext is used to handle the processing of the request. Work
+	// for a client that is gone can be abandoned once ctx is done.
+	ProcessContext(ctx context.Context, traceID string, r *Request)
+}
+
 // Request is the message received by the client.
 type Request struct {
 	TCP     *TCP
 	TCPAddr *net.TCPAddr
 	IsIPv6  bool
 	ReadAt  time.Time
+	Seq     uint64 // Sequence number of the request on this connection, starting at 1.
 	Data    []byte
 	Length  int
+	Conn    net.Conn // Connection the request was read from, must not be closed directly.
+
+	client   *client
+	bound    *binding
+	ctx      context.Context
+	buffered int
+}
+
+// Context returns the context for the client connection the request was
+// read from. It is cancelled when the client connection is removed. While
+// the request is processed, it carries the span started by StartSpan.
+func (r *Request) Context() context.Context {
+	if r.ctx != nil {
+		return r.ctx
+	}
+	if r.client == nil {
+		return context.Background()
+	}
+	return r.client.ctx
+}
+
+// NewResponse creates a response for the client that sent this request. The
+// sequence number and read time are carried over so the response can be
+// correlated with the request that triggered it. The response is written
+// with the writer and handler the request was read with, even if the
+// connection has been upgraded since.
+func (r *Request) NewResponse(data []byte) *Response {
+	return &Response{
+		TCPAddr: r.TCPAddr,
+		Seq:     r.Seq,
+		ReadAt:  r.ReadAt,
+		Data:    data,
+		Length:  len(data),
+
+		bound: r.bound,
+		ctx:   r.ctx,
+	}
+}
+
+// UpgradeHandlers switches the connection the request was read from to a
+// new set of handlers, like after a STARTTLS or version negotiation. Nil
+// handlers are not changed. The ConnHandler binds a new reader and writer
+// before the next read, and other connections are not affected. Call it
+// from Process before sending the response that tells the client to switch,
+// and build that response with NewResponse so it is still written with the
+// old writer. Anything the client sent after the request and before the
+// switch is discarded.
+func (r *Request) UpgradeHandlers(conn ConnHandler, req ReqHandler, resp RespHandler) {
+	if r.client == nil {
+		return
+	}
+
+	r.client.upgradeTo(&handlers{conn: conn, req: req, resp: resp})
 }
 
 // Work implements the worker interface for processing received messages.
 // This is called from a routine in the work pool.
 func (r *Request) Work(traceID string, id int) {
-	r.TCP.ReqHandler.Process(traceID, r)
+	h := r.TCP.loadHandlers().req
+	if r.bound != nil {
+		h = r.bound.handlers(r.TCP).req
+	}
+
+	// Time how long the handler takes to process the request. The data
+	// is no longer counted as buffered or in flight once it has been
+	// processed.
+	start := time.Now()
+	defer func() {
+		r.TCP.process.record(time.Since(start))
+		atomic.AddInt64(&r.TCP.buffered, -int64(r.buffered))
+		if r.client != nil {
+			atomic.AddInt64(&r.client.inFlight, -1)
+		}
+	}()
+
+	var end func()
+	r.ctx, end = r.TCP.startSpan(r.Context(), SpanProcess, SpanInfo{RemoteAddr: r.TCPAddr.String(), Seq: r.Seq})
+	defer end()
+
+	// Let the user check the request before it is processed. Any error
+	// other than ErrSkipRequest means the client can't be trusted.
+	if r.TCP.RequestMiddleware != nil {
+		if err := r.TCP.RequestMiddleware(r); err != nil {
+			if err != ErrSkipRequest && r.client != nil {
+				r.TCP.Event(traceID, "Work", "*******> DROPPING CONNECTION Remote[ %s ] DUE TO REQUEST MIDDLEWARE : %v", r.client.ipAddress, err)
+				r.client.close(CloseDropped)
+			}
+			return
+		}
+	}
+
+	if ch, ok := h.(ReqContextHandler); ok {
+		ch.ProcessContext(r.Context(), traceID, r)
+		return
+	}
+
+	h.Process(traceID, r)
 }
 
 //==============================================================================
@@ -61,23 +179,211 @@ type RespHandler interface {
 	Write(traceID string, r *Response, writer io.Writer)
 }
 
-// Response is message to send to the client.
+// flusher is declared to test for writers that buffer data, like the
+// bufio package's Writer.
+type flusher interface {
+	Flush() error
+}
+
+// Response is message to send to the client. Responses are written by the
+// send pool and no ordering is guaranteed between responses for the same
+// client. Use Seq to correlate a response with its request.
 type Response struct {
 	TCPAddr  *net.TCPAddr
+	Seq      uint64    // Sequence number of the request being answered.
+	ReadAt   time.Time // Time the request being answered was read.
 	Data     []byte
+	Buffers  net.Buffers // Segments written after Data without copying them together.
 	Length   int
-	Complete func(r *Response)
+	Deadline time.Time         // Response is stale and not written after this time, zero is never.
+	Complete func(r *Response) // Called once the response has been written and flushed.
+	Err      error             // Error writing or flushing the response, set before Complete is called.
+	Sent     chan error        // Sent the value of Err after Complete is called, must have room for it.
+
+	CloseAfterWrite bool // Close the connection once the response has been written and flushed.
 
-	tcp     *TCP
-	client  *client
-	traceID string
+	tcp      *TCP
+	client   *client
+	traceID  string
+	gen      uint64
+	writing  bool
+	paced    bool // The write rate limit has been waited for.
+	bound    *binding
+	ctx      context.Context
+	buffered int
 }
 
 // Work implements the worker interface for sending messages to the client.
 // This is called from a routine in the work pool.
 func (r *Response) Work(traceID string, id int) {
-	r.tcp.RespHandler.Write(traceID, r, r.client.writer)
+	// A response waiting on the write rate limit is still pending.
+	var requeued bool
+	defer func() {
+		if !requeued {
+			r.release()
+		}
+	}()
+
+	// Responses cancelled while waiting are not written.
+	if r.writing = r.client.startWrite(r.gen); !r.writing {
+		r.Err = ErrCancelled
+		r.finish()
+		return
+	}
+
+	// Don't write to a connection that is gone, the write can only fail.
+	if r.client.gone() {
+		r.Err = ErrClientGone
+		r.finish()
+		return
+	}
+
+	// Don't spend the bandwidth on a response nobody wants anymore.
+	if !r.Deadline.IsZero() && time.Now().After(r.Deadline) {
+		r.Err = ErrStale
+		r.finish()
+		return
+	}
+
+	// Let the user change the response before it is written, only
+	// once for a response that waited on the write rate limit.
+	if r.tcp.ResponseMiddleware != nil && !r.paced {
+		r.tcp.ResponseMiddleware(r)
+	}
+
+	// Wait for the write rate limit to allow the data to be sent. The
+	// wait happens off the send pool so a slow connection doesn't hold
+	// a routine the other connections need.
+	if r.client.pacer != nil && !r.paced {
+		r.paced = true
+		if d := r.client.pacer.reserve(r.size()); d > 0 {
+			r.client.stopWrite()
+			r.writing = false
+			requeued = true
+
+			atomic.AddInt64(&r.tcp.goroutines, 1)
+			go r.requeue(traceID, d)
+			return
+		}
+	}
+
+	// The whole response must be written before the write timeout.
+	if r.client.dconn != nil && r.tcp.WriteTimeout > 0 {
+		r.client.dconn.setDeadline(time.Now().Add(r.tcp.WriteTimeout))
+	}
+
+	// The span is a child of the request span for responses built
+	// with NewResponse.
+	ctx := r.ctx
+	if ctx == nil {
+		ctx = r.client.ctx
+	}
+	_, end := r.tcp.startSpan(ctx, SpanWrite, SpanInfo{RemoteAddr: r.client.ipAddress, Seq: r.Seq})
+
+	// Use the writer the connection is bound to, unless the response
+	// belongs to a request read before the connection was upgraded.
+	b := r.bound
+	if b == nil {
+		b = r.client.loadBinding()
+	}
+
+	// Make sure the bytes have left the process before the response
+	// is reported as complete, unless flushes are on a timer.
+	if err := r.client.write(traceID, r, b); err != nil {
+		r.Err = err
+	}
+
+	end()
+
+	// Drop a connection that keeps failing to be written to.
+	r.client.wrote(r.Err)
+
+	if r.Err == nil {
+		r.client.touch(time.Now())
+
+		// The bytes have been handed to the socket, so closing now
+		// can't cut the response off.
+		if r.CloseAfterWrite {
+			r.client.close(CloseDropped)
+		}
+	}
+
+	r.finish()
+}
+
+// requeue submits the response to the send pool again once the write rate
+// limit allows it to be written. The response is finished with
+// ErrClientGone if the connection goes away first.
+func (r *Response) requeue(traceID string, d time.Duration) {
+	defer atomic.AddInt64(&r.tcp.goroutines, -1)
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		if err := r.tcp.doSendCancel(r.client.ctx, traceID, r); err == nil {
+			return
+		}
+	case <-r.client.ctx.Done():
+	}
+
+	r.Err = ErrClientGone
+	r.finish()
+	r.release()
+}
+
+// finish reports the response is done with, calling Complete and sending
+// the error on Sent.
+func (r *Response) finish() {
 	if r.Complete != nil {
 		r.Complete(r)
 	}
+
+	// Never block the send pool on a caller that isn't listening.
+	if r.Sent != nil {
+		select {
+		case r.Sent <- r.Err:
+		default:
+		}
+	}
+}
+
+// WriteTo writes Data followed by Buffers to the writer. When the writer is
+// the *net.TCPConn, like when the ConnHandler binds the connection itself
+// as the writer, everything is written with a single vectored write.
+func (r *Response) WriteTo(w io.Writer) (int64, error) {
+	bufs := make(net.Buffers, 0, len(r.Buffers)+1)
+	if len(r.Data) > 0 {
+		bufs = append(bufs, r.Data)
+	}
+	bufs = append(bufs, r.Buffers...)
+
+	// Write to the connection under the error recorder so a vectored
+	// write is still used when the connection supports it.
+	if ec, ok := w.(*errConn); ok {
+		n, err := bufs.WriteTo(ec.Conn)
+		ec.record(err)
+		return n, err
+	}
+
+	return bufs.WriteTo(w)
+}
+
+// size returns the number of bytes in Data and Buffers.
+func (r *Response) size() int {
+	n := len(r.Data)
+	for _, b := range r.Buffers {
+		n += len(b)
+	}
+
+	return n
+}
+
+// release stops tracking the response as pending for the client.
+func (r *Response) release() {
+	atomic.AddInt64(&r.tcp.buffered, -int64(r.buffered))
+	atomic.AddInt64(&r.tcp.pendingResps, -1)
+	atomic.AddInt64(&r.client.pendingBytes, -int64(r.buffered))
+	r.client.done(r.writing)
 }
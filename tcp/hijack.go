@@ -0,0 +1,76 @@
+package tcp
+
+import (
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// Hijack takes the connection the request was read from away from the TCP
+// value, like http.Hijacker, so it can be used as a raw byte stream. Call it
+// from Process once the connection has to switch to something the handlers
+// can't frame, like a tunnel. It stops the read routine and removes the
+// connection from the manager with CloseHijacked, which cancels the request
+// context and calls OnDisconnect, but the connection is left open.
+//
+// From then on the caller owns the connection and must close it. Requests
+// already handed to the recv pool are still processed, but a request that
+// was read and is still waiting for a routine in the pool is discarded, so
+// a busy pool can't hold up Hijack. Responses that haven't been written are
+// completed with ErrClientGone, and the TCP value doesn't read from, write
+// to, time out or close the connection. Anything the ConnHandler's
+// reader had buffered is returned first by the connection's Read. As with
+// UpgradeHandlers, the client must wait for the server to tell it to switch
+// before it sends raw bytes, since anything sent with or right after the
+// request may have already been read as requests.
+//
+// ErrClientGone is returned if the connection is being closed.
+func (r *Request) Hijack() (net.Conn, error) {
+	if r.client == nil {
+		return nil, ErrClientGone
+	}
+
+	return r.client.hijack()
+}
+
+// hijack stops the read routine and removes the connection without closing
+// it. The connection returned replays the bytes left in the reader.
+func (c *client) hijack() (net.Conn, error) {
+	c.upgradeMu.Lock()
+	{
+		if !atomic.CompareAndSwapInt32(&c.reason, 0, int32(CloseHijacked)) {
+			c.upgradeMu.Unlock()
+			return nil, ErrClientGone
+		}
+
+		// Interrupt the read routine so it sees the connection has
+		// been hijacked, even when it is waiting on the recv pool.
+		c.conn.SetReadDeadline(time.Now())
+		c.stopRead()
+	}
+	c.upgradeMu.Unlock()
+
+	c.wg.Wait()
+
+	// Wait for a response being written to finish. Responses written
+	// after this see the connection is hijacked and are not written, so
+	// the write deadline can't be set again once it is cleared.
+	c.writeMu.Lock()
+	c.conn.SetDeadline(time.Time{})
+	c.writeMu.Unlock()
+
+	c.t.Event(c.traceID, "hijack", "Connection Hijacked : IPAddress[ %s ]", c.ipAddress)
+
+	n := c.reader.Buffered()
+	if n == 0 {
+		return c.conn, nil
+	}
+
+	seen, _ := c.reader.Peek(n)
+	return &probeConn{Conn: c.conn, seen: append([]byte(nil), seen...), replay: true}, nil
+}
+
+// hijacked reports if the connection has been hijacked.
+func (c *client) hijacked() bool {
+	return atomic.LoadInt32(&c.reason) == int32(CloseHijacked)
+}
@@ -0,0 +1,94 @@
+package tcp
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+)
+
+// ErrInvalidJSON is returned when a line does not hold a valid JSON value.
+var ErrInvalidJSON = errors.New("Invalid JSON")
+
+// JSONLineReqHandler is a ReqHandler for newline delimited JSON. Each line
+// is read into the request data and handed to the Processor. Use
+// Request.DecodeJSON to unmarshal it.
+type JSONLineReqHandler struct {
+	Processor   Processor // Called for each JSON value read.
+	MaxLineSize int       // Lines longer than this close the connection, 0 means no limit.
+}
+
+// Read implements the ReqHandler interface. Blank lines are skipped and a
+// line that is not valid JSON closes the connection.
+func (h JSONLineReqHandler) Read(traceID string, ipAddress string, reader io.Reader) ([]byte, int, error) {
+	read := ReadLine(h.MaxLineSize)
+
+	for {
+		data, length, err := read(traceID, ipAddress, reader)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		if len(bytes.TrimSpace(data)) == 0 {
+			continue
+		}
+
+		if !json.Valid(data) {
+			return nil, 0, ErrInvalidJSON
+		}
+
+		return data, length, nil
+	}
+}
+
+// Process implements the ReqHandler interface.
+func (h JSONLineReqHandler) Process(traceID string, r *Request) {
+	h.Processor.Process(traceID, r)
+}
+
+// DecodeJSON unmarshals the request data into v.
+func (r *Request) DecodeJSON(v interface{}) error {
+	return json.Unmarshal(r.Data, v)
+}
+
+// NewJSONResponse creates a response for the request with v marshaled
+// as the response data.
+func (r *Request) NewJSONResponse(v interface{}) (*Response, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.NewResponse(data), nil
+}
+
+//==============================================================================
+
+// JSONLineRespHandler is a RespHandler for newline delimited JSON. The
+// response data must be a single JSON value, which is written followed by
+// a newline.
+type JSONLineRespHandler struct {
+	MaxLineSize int // Responses longer than this are not written, 0 means no limit.
+}
+
+// Write implements the RespHandler interface. Responses that can't be
+// written have Err set.
+func (h JSONLineRespHandler) Write(traceID string, r *Response, writer io.Writer) {
+	if h.MaxLineSize > 0 && len(r.Data) > h.MaxLineSize {
+		r.Err = ErrLineTooLong
+		return
+	}
+
+	if !json.Valid(r.Data) {
+		r.Err = ErrInvalidJSON
+		return
+	}
+
+	line := make([]byte, len(r.Data)+1)
+	copy(line, r.Data)
+	line[len(r.Data)] = '\n'
+
+	if _, err := writer.Write(line); err != nil {
+		r.Err = err
+	}
+}
@@ -0,0 +1,68 @@
+package tcp
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// latencyWindow is how long the min and max latency are kept before
+// they start over.
+const latencyWindow = time.Minute
+
+// latency tracks an exponentially weighted moving average of a duration
+// along with the min and max seen in the current window. Each sample is
+// recorded with atomics so it is cheap to call on every request.
+type latency struct {
+	ewma  int64 // Moving average in nanoseconds, 0 until the first sample.
+	min   int64 // Min in nanoseconds for the current window.
+	max   int64 // Max in nanoseconds for the current window.
+	start int64 // Start of the current window in Unix nanoseconds.
+}
+
+// record adds a sample. The average gives each new sample a weight of 1/8.
+func (l *latency) record(d time.Duration) {
+	n := int64(d)
+
+	for {
+		old := atomic.LoadInt64(&l.ewma)
+
+		avg := n
+		if old != 0 {
+			avg = old + (n-old)/8
+		}
+
+		if atomic.CompareAndSwapInt64(&l.ewma, old, avg) {
+			break
+		}
+	}
+
+	// Start a new window once the current one has passed. Only the
+	// routine that moves the window resets the min and max.
+	now := time.Now().UnixNano()
+	if start := atomic.LoadInt64(&l.start); now-start > int64(latencyWindow) {
+		if atomic.CompareAndSwapInt64(&l.start, start, now) {
+			atomic.StoreInt64(&l.min, n)
+			atomic.StoreInt64(&l.max, n)
+			return
+		}
+	}
+
+	for {
+		min := atomic.LoadInt64(&l.min)
+		if min != 0 && n >= min || atomic.CompareAndSwapInt64(&l.min, min, n) {
+			break
+		}
+	}
+
+	for {
+		max := atomic.LoadInt64(&l.max)
+		if n <= max || atomic.CompareAndSwapInt64(&l.max, max, n) {
+			break
+		}
+	}
+}
+
+// stat returns the average and the min and max for the current window.
+func (l *latency) stat() (avg, min, max time.Duration) {
+	return time.Duration(atomic.LoadInt64(&l.ewma)), time.Duration(atomic.LoadInt64(&l.min)), time.Duration(atomic.LoadInt64(&l.max))
+}
@@ -0,0 +1,70 @@
+package tcp
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+)
+
+// Set of error variables for reading lines.
+var (
+	ErrLineTooLong    = errors.New("Line too long")
+	ErrNotBufioReader = errors.New("Reader is not a *bufio.Reader")
+)
+
+// ReadLine returns a ReadFunc that reads a single newline terminated line
+// and returns it without the line ending. Lines longer than max bytes fail
+// with ErrLineTooLong, which closes the connection. A max of 0 means no
+// limit. The reader must be a *bufio.Reader, like the one the connection
+// hands to Read, so bytes past the end of the line are kept for the next
+// call. Any other reader fails with ErrNotBufioReader.
+func ReadLine(max int) ReadFunc {
+	return func(traceID string, ipAddress string, reader io.Reader) ([]byte, int, error) {
+		br, ok := reader.(*bufio.Reader)
+		if !ok {
+			return nil, 0, ErrNotBufioReader
+		}
+
+		line, err := readLine(br, max)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		return line, len(line), nil
+	}
+}
+
+// readLine reads up to the next newline. ReadSlice is used so a line that
+// is too long is rejected without buffering all of it.
+func readLine(br *bufio.Reader, max int) ([]byte, error) {
+	var line []byte
+
+	for {
+		frag, err := br.ReadSlice('\n')
+		if max > 0 && len(line)+len(frag) > max+2 {
+			return nil, ErrLineTooLong
+		}
+
+		line = append(line, frag...)
+
+		switch err {
+		case nil:
+			line = bytes.TrimSuffix(line[:len(line)-1], []byte("\r"))
+			if max > 0 && len(line) > max {
+				return nil, ErrLineTooLong
+			}
+			return line, nil
+
+		case bufio.ErrBufferFull:
+			continue
+
+		case io.EOF:
+			// A partial line at the end of the stream is not a request.
+			return nil, io.EOF
+
+		default:
+			return nil, err
+		}
+	}
+}
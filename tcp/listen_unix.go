@@ -0,0 +1,27 @@
+//go:build !windows
+// +build !windows
+
+package tcp
+
+import (
+	"net"
+	"syscall"
+)
+
+// setBacklog changes the size of the listen backlog. Calling listen again
+// on a socket that is already listening only updates the backlog.
+func setBacklog(listener *net.TCPListener, backlog int) error {
+	rc, err := listener.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var lerr error
+	if err := rc.Control(func(fd uintptr) {
+		lerr = syscall.Listen(int(fd), backlog)
+	}); err != nil {
+		return err
+	}
+
+	return lerr
+}
@@ -0,0 +1,14 @@
+//go:build windows
+// +build windows
+
+package tcp
+
+import (
+	"errors"
+	"net"
+)
+
+// setBacklog is not supported on windows. The OS default backlog is used.
+func setBacklog(listener *net.TCPListener, backlog int) error {
+	return errors.New("Listen backlog is not supported on windows")
+}
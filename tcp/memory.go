@@ -0,0 +1,139 @@
+package tcp
+
+import (
+	"errors"
+	"net"
+	"sync"
+)
+
+// ErrNotListening is returned by a Connector when the TCP value it
+// connects to is not accepting connections.
+var ErrNotListening = errors.New("In-memory TCP is not listening")
+
+// NewInMemory creates a new manager that accepts connections from the returned
+// Connector instead of the network. No port is bound, but connections go
+// through the same join, read and send code as network connections, which
+// makes it useful for testing handlers. Each connection is given a unique
+// loopback address.
+func NewInMemory(traceID string, name string, cfg Config) (*TCP, *Connector, error) {
+	if cfg.NetType == "" {
+		cfg.NetType = "tcp"
+	}
+
+	if cfg.Addr == "" {
+		cfg.Addr = "127.0.0.1:0"
+	}
+
+	t, err := New(traceID, name, cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var c Connector
+	t.listenFn = c.listen
+
+	return t, &c, nil
+}
+
+//==============================================================================
+
+// Connector creates client connections to a TCP value created by NewInMemory.
+type Connector struct {
+	mu       sync.Mutex
+	listener *memListener
+	port     int
+}
+
+// Connect creates a new connection to the TCP value and returns the client
+// side of the connection.
+func (c *Connector) Connect() (net.Conn, error) {
+	c.mu.Lock()
+	l := c.listener
+	c.port++
+	port := c.port
+	c.mu.Unlock()
+
+	if l == nil {
+		return nil, ErrNotListening
+	}
+
+	raddr := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port}
+	server, client := net.Pipe()
+
+	select {
+	case l.conns <- &memConn{Conn: server, laddr: l.addr, raddr: raddr}:
+		return &memConn{Conn: client, laddr: raddr, raddr: l.addr}, nil
+
+	case <-l.done:
+		server.Close()
+		client.Close()
+		return nil, ErrNotListening
+	}
+}
+
+// listen replaces the current listener with a new one for the address.
+func (c *Connector) listen(addr *net.TCPAddr) (net.Listener, error) {
+	l := memListener{
+		addr:  addr,
+		conns: make(chan net.Conn),
+		done:  make(chan struct{}),
+	}
+
+	c.mu.Lock()
+	c.listener = &l
+	c.mu.Unlock()
+
+	return &l, nil
+}
+
+//==============================================================================
+
+// memListener implements net.Listener for connections made by a Connector.
+type memListener struct {
+	addr  *net.TCPAddr
+	conns chan net.Conn
+	done  chan struct{}
+	once  sync.Once
+}
+
+// Accept waits for the next connection from the Connector.
+func (l *memListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+
+	case <-l.done:
+		return nil, &net.OpError{Op: "accept", Net: "memory", Addr: l.addr, Err: errors.New("use of closed in-memory listener")}
+	}
+}
+
+// Close stops the listener from accepting connections.
+func (l *memListener) Close() error {
+	l.once.Do(func() { close(l.done) })
+	return nil
+}
+
+// Addr returns the address of the listener.
+func (l *memListener) Addr() net.Addr {
+	return l.addr
+}
+
+//==============================================================================
+
+// memConn is one side of an in-memory connection. It reports TCP addresses
+// so it is handled like a network connection.
+type memConn struct {
+	net.Conn
+	laddr *net.TCPAddr
+	raddr *net.TCPAddr
+}
+
+// LocalAddr returns the local address of the connection.
+func (c *memConn) LocalAddr() net.Addr {
+	return c.laddr
+}
+
+// RemoteAddr returns the remote address of the connection.
+func (c *memConn) RemoteAddr() net.Addr {
+	return c.raddr
+}
@@ -0,0 +1,89 @@
+package tcp
+
+import (
+	"io"
+	"sync"
+)
+
+// Processor is implemented by types that process requests. Every
+// ReqHandler is a Processor.
+type Processor interface {
+	Process(traceID string, r *Request)
+}
+
+// ProcessFunc is an adapter to allow the use of a function as a Processor.
+type ProcessFunc func(traceID string, r *Request)
+
+// Process calls f(traceID, r).
+func (f ProcessFunc) Process(traceID string, r *Request) {
+	f(traceID, r)
+}
+
+// ReadFunc reads a full request off the wire. It has the same contract as
+// the ReqHandler Read method.
+type ReadFunc func(traceID string, ipAddress string, reader io.Reader) ([]byte, int, error)
+
+//==============================================================================
+
+// MultiplexReqHandler is a ReqHandler that dispatches each request to the
+// Processor registered for its message type. The message type is the first
+// byte of the request data. Requests with no data or an unregistered type
+// are sent to the default Processor.
+type MultiplexReqHandler struct {
+	read ReadFunc
+	def  Processor
+
+	mu         sync.RWMutex
+	processors map[byte]Processor
+}
+
+// NewMultiplexReqHandler creates a handler that reads requests with the
+// specified function and sends unknown message types to the default
+// Processor. If the default is nil, unknown message types are dropped.
+func NewMultiplexReqHandler(read ReadFunc, def Processor) *MultiplexReqHandler {
+	return &MultiplexReqHandler{
+		read:       read,
+		def:        def,
+		processors: make(map[byte]Processor),
+	}
+}
+
+// Handle registers the Processor for the specified message type, replacing
+// any Processor already registered.
+func (m *MultiplexReqHandler) Handle(msgType byte, p Processor) {
+	m.mu.Lock()
+	{
+		m.processors[msgType] = p
+	}
+	m.mu.Unlock()
+}
+
+// Read implements the ReqHandler interface.
+func (m *MultiplexReqHandler) Read(traceID string, ipAddress string, reader io.Reader) ([]byte, int, error) {
+	return m.read(traceID, ipAddress, reader)
+}
+
+// Process implements the ReqHandler interface by dispatching the request
+// to the Processor registered for its message type.
+func (m *MultiplexReqHandler) Process(traceID string, r *Request) {
+	p := m.def
+
+	if len(r.Data) > 0 {
+		m.mu.RLock()
+		{
+			if mp, ok := m.processors[r.Data[0]]; ok {
+				p = mp
+			}
+		}
+		m.mu.RUnlock()
+	}
+
+	if p == nil {
+		if r.TCP != nil {
+			r.TCP.Event(traceID, "Process", "ERROR : Unknown Message Type : IPAddress[ %s ]", r.TCPAddr)
+		}
+		return
+	}
+
+	p.Process(traceID, r)
+}
@@ -0,0 +1,34 @@
+package tcp
+
+import (
+	"sync"
+	"time"
+)
+
+// pacer spaces out the writes to a connection so no more than a set number
+// of bytes per second are written. A response that has to wait is handed
+// back to the send pool once it is allowed to be written, so a low rate
+// never ties up a routine in the send pool.
+type pacer struct {
+	rate float64 // Bytes per second.
+
+	mu   sync.Mutex
+	next time.Time // Time the next write is allowed to start.
+}
+
+// reserve claims the time needed to write n bytes and returns how long
+// to wait before they are allowed to be written.
+func (p *pacer) reserve(n int) time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	if p.next.Before(now) {
+		p.next = now
+	}
+
+	start := p.next
+	p.next = start.Add(time.Duration(float64(n) / p.rate * float64(time.Second)))
+
+	return start.Sub(now)
+}
@@ -0,0 +1,80 @@
+package tcp
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/ardanlabs/kit/pool"
+)
+
+// SwapPools redirects new work to the specified pools, like freshly sized
+// ones, without dropping any connections. Work already submitted to the old
+// pools completes on them. Old pools created by New are shut down in the
+// background once that work is done, and old pools the user provided are
+// left running. The new pools are owned by the user from then on, so Stop
+// does not shut them down.
+func (t *TCP) SwapPools(traceID string, recv *pool.Pool, send *pool.Pool) error {
+	if recv == nil || send == nil {
+		return ErrInvalidPoolConfiguration
+	}
+
+	if !recv.IsRunning() || !send.IsRunning() {
+		return ErrPoolNotRunning
+	}
+
+	// Waits for the responses being submitted to the old send pool. The
+	// send pool is swapped first since recv work submits to it.
+	t.sendMu.Lock()
+	oldSend := t.send
+	t.send = send
+	t.sendMu.Unlock()
+
+	// Waits for the requests being submitted to the old recv pool.
+	t.recvMu.Lock()
+	oldRecv, userPools := t.recv, t.userPools
+	t.recv = recv
+	t.userPools = true
+	t.recvMu.Unlock()
+
+	t.Event(traceID, "SwapPools", "Pools Swapped")
+
+	if !userPools {
+		atomic.AddInt64(&t.goroutines, 1)
+		go func() {
+			oldRecv.Shutdown(traceID)
+			oldSend.Shutdown(traceID)
+
+			t.Event(traceID, "SwapPools", "Old Pools Shutdown")
+			atomic.AddInt64(&t.goroutines, -1)
+		}()
+	}
+
+	return nil
+}
+
+// doRecvCancel submits a request to the recv pool or gives up when the
+// context is done. The pool can't be swapped until the pool takes the work.
+func (t *TCP) doRecvCancel(ctx context.Context, traceID string, r *Request) error {
+	t.recvMu.RLock()
+	defer t.recvMu.RUnlock()
+
+	return t.recv.DoCancel(ctx, traceID, r)
+}
+
+// doSend submits a response to the send pool. The pool can't be swapped
+// until the pool takes the work.
+func (t *TCP) doSend(traceID string, r *Response) {
+	t.sendMu.RLock()
+	defer t.sendMu.RUnlock()
+
+	t.send.Do(traceID, r)
+}
+
+// doSendCancel submits a response to the send pool or gives up when the
+// context is done.
+func (t *TCP) doSendCancel(ctx context.Context, traceID string, r *Response) error {
+	t.sendMu.RLock()
+	defer t.sendMu.RUnlock()
+
+	return t.send.DoCancel(ctx, traceID, r)
+}
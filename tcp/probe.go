@@ -0,0 +1,84 @@
+package tcp
+
+import (
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// probeConn records the bytes ProbeFunc reads from a connection, so they
+// can be replayed to the ConnHandler when the connection isn't a probe.
+// While probing, a read deadline can't be set past the probe deadline.
+type probeConn struct {
+	net.Conn
+	seen     []byte
+	replay   bool
+	deadline time.Time // Probe deadline, zero once probing is done.
+}
+
+// SetDeadline implements the net.Conn interface.
+func (c *probeConn) SetDeadline(t time.Time) error {
+	if err := c.Conn.SetWriteDeadline(t); err != nil {
+		return err
+	}
+	return c.SetReadDeadline(t)
+}
+
+// SetReadDeadline implements the net.Conn interface.
+func (c *probeConn) SetReadDeadline(t time.Time) error {
+	if !c.deadline.IsZero() && (t.IsZero() || t.After(c.deadline)) {
+		t = c.deadline
+	}
+	return c.Conn.SetReadDeadline(t)
+}
+
+// Read implements the net.Conn interface.
+func (c *probeConn) Read(b []byte) (int, error) {
+	if c.replay && len(c.seen) > 0 {
+		n := copy(b, c.seen)
+		c.seen = c.seen[n:]
+		return n, nil
+	}
+
+	n, err := c.Conn.Read(b)
+	if !c.replay {
+		c.seen = append(c.seen, b[:n]...)
+	}
+
+	return n, err
+}
+
+// probe gives the connection to ProbeFunc and reports if it was a probe,
+// in which case the connection has been closed. Otherwise it returns the
+// connection to use from then on, which replays anything ProbeFunc read.
+// Reads by ProbeFunc time out after the ProbeTimeout, so a client that
+// sends nothing can't hold up its routine.
+func (t *TCP) probe(traceID string, conn net.Conn) (net.Conn, bool) {
+	timeout := t.ProbeTimeout
+	if timeout == 0 {
+		timeout = defaultProbeTimeout
+	}
+
+	pc := probeConn{Conn: conn, deadline: time.Now().Add(timeout)}
+	conn.SetReadDeadline(pc.deadline)
+
+	probed := t.ProbeFunc(&pc)
+
+	pc.deadline = time.Time{}
+	conn.SetReadDeadline(time.Time{})
+
+	if probed {
+		t.Event(traceID, "probe", "Probe Handled : Remote[ %v ]", conn.RemoteAddr())
+		atomic.AddUint64(&t.probes, 1)
+		conn.Close()
+		return nil, true
+	}
+
+	// Nothing has to be replayed, so the connection is used as is.
+	if len(pc.seen) == 0 {
+		return conn, false
+	}
+
+	pc.replay = true
+	return &pc, false
+}
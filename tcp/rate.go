@@ -0,0 +1,41 @@
+package tcp
+
+import "time"
+
+// rateWindow is how far back the rolling counters look.
+const rateWindow = 60 * time.Second
+
+// rollingCount counts events over the last rateWindow in one second
+// buckets. It isn't safe for concurrent use, the caller holds a lock.
+type rollingCount struct {
+	counts [int(rateWindow / time.Second)]uint64
+	secs   [int(rateWindow / time.Second)]int64 // Unix second each bucket counts.
+}
+
+// add counts an event at the specified time.
+func (r *rollingCount) add(now time.Time) {
+	s := now.Unix()
+	i := s % int64(len(r.counts))
+
+	if r.secs[i] != s {
+		r.secs[i] = s
+		r.counts[i] = 0
+	}
+
+	r.counts[i]++
+}
+
+// rate returns the events per second over the window ending at the
+// specified time.
+func (r *rollingCount) rate(now time.Time) float64 {
+	s := now.Unix()
+
+	var total uint64
+	for i, sec := range r.secs {
+		if s-sec < int64(len(r.counts)) {
+			total += r.counts[i]
+		}
+	}
+
+	return float64(total) / rateWindow.Seconds()
+}
@@ -0,0 +1,61 @@
+package tcp
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// limiter decides if a connection is accepted under the connection rate
+// limit. It allows a burst of connections and then one per duration, the
+// burst refilling at the same rate. Only the accept routine calls allow,
+// the fields are read with atomics for the stats.
+type limiter struct {
+	next      int64  // Unix nano the burst is fully used up to, a theoretical arrival time.
+	allowed   uint64 // Connections the limit let through.
+	throttled uint64 // Connections the limit dropped.
+}
+
+// allow reports if a connection arriving now is accepted when one
+// connection is allowed every duration with the specified burst.
+func (l *limiter) allow(now time.Time, every time.Duration, burst int) bool {
+	if burst < 1 {
+		burst = 1
+	}
+
+	// The connection is accepted as long as the burst hasn't been used
+	// up past now. With a burst of 1 this is one per duration.
+	next := time.Unix(0, atomic.LoadInt64(&l.next))
+	if next.Add(-time.Duration(burst-1) * every).After(now) {
+		atomic.AddUint64(&l.throttled, 1)
+		return false
+	}
+
+	if next.Before(now) {
+		next = now
+	}
+	atomic.StoreInt64(&l.next, next.Add(every).UnixNano())
+	atomic.AddUint64(&l.allowed, 1)
+
+	return true
+}
+
+// tokens returns the number of connections that would be accepted at
+// once if they arrived now.
+func (l *limiter) tokens(now time.Time, every time.Duration, burst int) int {
+	if burst < 1 {
+		burst = 1
+	}
+
+	used := time.Unix(0, atomic.LoadInt64(&l.next)).Sub(now)
+	if used <= 0 || every <= 0 {
+		return burst
+	}
+
+	// A connection isn't given back until its whole duration has passed.
+	n := burst - int((used+every-1)/every)
+	if n < 0 {
+		return 0
+	}
+
+	return n
+}
@@ -0,0 +1,50 @@
+package tcp
+
+// CloseReason describes why a client connection was removed.
+type CloseReason int32
+
+// Set of reasons a client connection is removed.
+const (
+	CloseClientEOF        CloseReason = iota + 1 // Client closed the connection.
+	CloseReadError                               // Reading from the connection failed.
+	CloseDropped                                 // Connection was dropped by the server.
+	CloseShutdown                                // Server is shutting down.
+	CloseReset                                   // Client reset the connection.
+	CloseFirstByteTimeout                        // Client sent nothing before the first byte timeout.
+	CloseReadTimeout                             // Client took longer than MaxReadDuration to send a request.
+	CloseWriteErrors                             // Writes to the client failed MaxWriteErrors times in a row.
+	CloseHijacked                                // Connection was taken over with Request.Hijack and left open.
+)
+
+// String returns a short description of the reason.
+func (r CloseReason) String() string {
+	switch r {
+	case CloseClientEOF:
+		return "client_eof"
+	case CloseReadError:
+		return "read_error"
+	case CloseDropped:
+		return "dropped"
+	case CloseShutdown:
+		return "shutdown"
+	case CloseReset:
+		return "reset"
+	case CloseFirstByteTimeout:
+		return "first_byte_timeout"
+	case CloseReadTimeout:
+		return "read_timeout"
+	case CloseWriteErrors:
+		return "write_errors"
+	case CloseHijacked:
+		return "hijacked"
+	}
+
+	return "unknown"
+}
+
+// Clean reports if the client closed the connection gracefully. Resets and
+// read errors are network faults, while drops and shutdowns are decided by
+// the server.
+func (r CloseReason) Clean() bool {
+	return r == CloseClientEOF
+}
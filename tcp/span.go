@@ -0,0 +1,36 @@
+package tcp
+
+import "context"
+
+// Names of the spans started with the StartSpan hook.
+const (
+	SpanProcess = "tcp.process" // Span around processing a request.
+	SpanWrite   = "tcp.write"   // Span around writing a response.
+)
+
+// SpanInfo describes the request or response a span was started for.
+type SpanInfo struct {
+	RemoteAddr string // Address of the client.
+	Seq        uint64 // Sequence number of the request on the connection.
+}
+
+// spanKey is the context key for the SpanInfo.
+type spanKey struct{}
+
+// SpanInfoFromContext returns the SpanInfo in the context passed to the
+// StartSpan hook, so the span can be given attributes for the connection
+// and request.
+func SpanInfoFromContext(ctx context.Context) (SpanInfo, bool) {
+	info, ok := ctx.Value(spanKey{}).(SpanInfo)
+	return info, ok
+}
+
+// startSpan starts a span with the StartSpan hook. The returned function
+// ends the span. Without a hook, the context is returned as is.
+func (t *TCP) startSpan(ctx context.Context, name string, info SpanInfo) (context.Context, func()) {
+	if t.StartSpan == nil {
+		return ctx, func() {}
+	}
+
+	return t.StartSpan(context.WithValue(ctx, spanKey{}, info), name)
+}
@@ -0,0 +1,271 @@
+package tcp
+
+import (
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/ardanlabs/kit/pool"
+)
+
+// TCPStat contains a snapshot of the stats for a TCP value.
+type TCPStat struct {
+	Name             string             // Name of the TCP value.
+	Labels           map[string]string  // Labels that identify the TCP value.
+	Connections      int                // Current number of client connections.
+	AcceptedTotal    uint64             // Number of connections accepted since New.
+	AcceptedTLS      uint64             // Number of those connections that are TLS.
+	AcceptedPlain    uint64             // Number of those connections that are plaintext.
+	Resets           uint64             // Number of calls to ResetConnections.
+	NotAdmitted      uint64             // Number of connections AdmitFunc did not admit.
+	Probes           uint64             // Number of connections ProbeFunc handled as a probe.
+	Drops            map[string]uint64  // Number of connections dropped by reason.
+	DropRates        map[string]float64 // Connections dropped per second over the last minute by reason.
+	BufferedBytes    int64              // Bytes read or waiting to be written that haven't been handled yet.
+	PendingResponses int64              // Responses waiting to be written across all clients.
+	RateAllowed      uint64             // Number of connections the rate limit let through.
+	RateThrottled    uint64             // Number of connections the rate limit dropped.
+	RateTokens       int                // Connections the rate limit would accept at once right now, 0 without a rate limit.
+	ProcessAvg       time.Duration      // Moving average of the time taken to process a request.
+	ProcessMin       time.Duration      // Min time taken to process a request in the last minute.
+	ProcessMax       time.Duration      // Max time taken to process a request in the last minute.
+	Recv             pool.Stat          // Snapshot of the recv pool stats.
+	Send             pool.Stat          // Snapshot of the send pool stats.
+}
+
+// Stats returns the current snapshot of the stats.
+func (t *TCP) Stats() TCPStat {
+	t.clientsMu.Lock()
+	conns := len(t.clients)
+	t.clientsMu.Unlock()
+
+	avg, min, max := t.process.stat()
+
+	return TCPStat{
+		Name:             t.Name,
+		Labels:           t.Labels(),
+		Connections:      conns,
+		AcceptedTotal:    t.AcceptedTotal(),
+		AcceptedTLS:      atomic.LoadUint64(&t.acceptedTLS),
+		AcceptedPlain:    atomic.LoadUint64(&t.acceptedPlain),
+		Resets:           atomic.LoadUint64(&t.resets),
+		NotAdmitted:      atomic.LoadUint64(&t.notAdmitted),
+		Probes:           atomic.LoadUint64(&t.probes),
+		Drops:            t.Drops(),
+		DropRates:        t.DropRates(),
+		BufferedBytes:    atomic.LoadInt64(&t.buffered),
+		PendingResponses: atomic.LoadInt64(&t.pendingResps),
+		RateAllowed:      atomic.LoadUint64(&t.connLimit.allowed),
+		RateThrottled:    atomic.LoadUint64(&t.connLimit.throttled),
+		RateTokens:       t.rateTokens(),
+		ProcessAvg:       avg,
+		ProcessMin:       min,
+		ProcessMax:       max,
+		Recv:             t.StatsRecv(),
+		Send:             t.StatsSend(),
+	}
+}
+
+// TCPStatDelta contains the change in the counters of a TCP value since
+// the previous call to StatsDelta, along with the current snapshot for
+// the gauges like the number of connections.
+type TCPStatDelta struct {
+	Interval      time.Duration     // Time since the previous call, or since New for the first call.
+	AcceptedTotal uint64            // Number of connections accepted.
+	AcceptedTLS   uint64            // Number of those connections that are TLS.
+	AcceptedPlain uint64            // Number of those connections that are plaintext.
+	Resets        uint64            // Number of calls to ResetConnections.
+	NotAdmitted   uint64            // Number of connections AdmitFunc did not admit.
+	Probes        uint64            // Number of connections ProbeFunc handled as a probe.
+	RateAllowed   uint64            // Number of connections the rate limit let through.
+	RateThrottled uint64            // Number of connections the rate limit dropped.
+	Drops         map[string]uint64 // Number of connections dropped by reason, reasons with no drops are left out.
+	RecvExecuted  int64             // Number of pieces of work the recv pool executed.
+	SendExecuted  int64             // Number of pieces of work the send pool executed.
+	Current       TCPStat           // Current snapshot of the stats.
+}
+
+// StatsDelta returns the change in the counters since the previous call,
+// or since New for the first call. It is meant for a single consumer,
+// like a metrics scraper, since each call starts a new interval.
+func (t *TCP) StatsDelta() TCPStatDelta {
+	t.deltaMu.Lock()
+	defer t.deltaMu.Unlock()
+
+	now := time.Now()
+	cur := t.Stats()
+	last := t.deltaLast
+
+	d := TCPStatDelta{
+		Interval:      now.Sub(t.deltaAt),
+		AcceptedTotal: cur.AcceptedTotal - last.AcceptedTotal,
+		AcceptedTLS:   cur.AcceptedTLS - last.AcceptedTLS,
+		AcceptedPlain: cur.AcceptedPlain - last.AcceptedPlain,
+		Resets:        cur.Resets - last.Resets,
+		NotAdmitted:   cur.NotAdmitted - last.NotAdmitted,
+		Probes:        cur.Probes - last.Probes,
+		RateAllowed:   cur.RateAllowed - last.RateAllowed,
+		RateThrottled: cur.RateThrottled - last.RateThrottled,
+		Drops:         make(map[string]uint64),
+		RecvExecuted:  executedDelta(cur.Recv.Executed, last.Recv.Executed),
+		SendExecuted:  executedDelta(cur.Send.Executed, last.Send.Executed),
+		Current:       cur,
+	}
+
+	for reason, n := range cur.Drops {
+		if n > last.Drops[reason] {
+			d.Drops[reason] = n - last.Drops[reason]
+		}
+	}
+
+	t.deltaLast = cur
+	t.deltaAt = now
+
+	return d
+}
+
+// executedDelta returns the work a pool executed since the last snapshot.
+// A pool swapped in with SwapPools starts counting over, so everything it
+// executed is new.
+func executedDelta(cur, last int64) int64 {
+	if cur < last {
+		return cur
+	}
+
+	return cur - last
+}
+
+// rateTokens returns the number of connections the rate limit would accept
+// at once right now.
+func (t *TCP) rateTokens() int {
+	if t.RateLimit == nil {
+		return 0
+	}
+
+	return t.connLimit.tokens(time.Now(), t.RateLimit(), t.RateLimitBurst)
+}
+
+// GoroutineCount returns the number of goroutines the TCP value owns. This
+// is the accept routine, a read routine for each connection and the
+// routines of the pools it created. User provided pools are not counted,
+// and neither is a connection count report waiting to fire. Use it in
+// tests to check nothing is left running after Stop.
+func (t *TCP) GoroutineCount() int {
+	t.recvMu.RLock()
+	recv, userPools := t.recv, t.userPools
+	t.recvMu.RUnlock()
+
+	t.sendMu.RLock()
+	send := t.send
+	t.sendMu.RUnlock()
+
+	n := int(atomic.LoadInt64(&t.goroutines))
+	if !userPools {
+		n += poolGoroutines(recv) + poolGoroutines(send)
+	}
+
+	return n
+}
+
+// poolGoroutines returns the number of goroutines a pool is running, its
+// workers and the manager while the pool is running.
+func poolGoroutines(p *pool.Pool) int {
+	n := int(p.Stats().Routines)
+	if p.IsRunning() {
+		n++
+	}
+
+	return n
+}
+
+// Labels returns a copy of the labels that identify the TCP value.
+func (t *TCP) Labels() map[string]string {
+	labels := make(map[string]string, len(t.labels))
+	for k, v := range t.labels {
+		labels[k] = v
+	}
+
+	return labels
+}
+
+// Drops returns the number of connections dropped since New by the reason
+// they were dropped.
+func (t *TCP) Drops() map[string]uint64 {
+	t.dropsMu.Lock()
+	defer t.dropsMu.Unlock()
+
+	drops := make(map[string]uint64, len(t.drops))
+	for reason, n := range t.drops {
+		drops[reason] = n
+	}
+
+	return drops
+}
+
+// DropRates returns the number of connections dropped per second over the
+// last minute by the reason they were dropped. Reasons with no drops in
+// the last minute are reported as 0 once they have been seen.
+func (t *TCP) DropRates() map[string]float64 {
+	t.dropsMu.Lock()
+	defer t.dropsMu.Unlock()
+
+	now := time.Now()
+
+	rates := make(map[string]float64, len(t.dropRates))
+	for reason, r := range t.dropRates {
+		rates[reason] = r.rate(now)
+	}
+
+	return rates
+}
+
+// AcceptedTotal returns the number of connections accepted since New.
+func (t *TCP) AcceptedTotal() uint64 {
+	return atomic.LoadUint64(&t.acceptedTotal)
+}
+
+//==============================================================================
+
+// ConnInfo contains information about a client connection.
+type ConnInfo struct {
+	Addr         string    // Remote address of the connection.
+	ID           uint64    // ID of the connection, unique for the TCP value.
+	ConnectedAt  time.Time // Time the connection was accepted.
+	LastActivity time.Time // Time of the last read or successful write.
+	Pending      int64     // Number of responses waiting to be written.
+	PendingMax   int64     // High water mark of responses waiting to be written.
+	PendingBytes int64     // Bytes in responses waiting to be written.
+	InFlight     int64     // Number of requests read that haven't been processed.
+}
+
+// Connections returns a snapshot of information about each client connection.
+func (t *TCP) Connections() []ConnInfo {
+	clients := t.copyClients()
+
+	infos := make([]ConnInfo, len(clients))
+	for i, c := range clients {
+		infos[i] = c.info()
+	}
+
+	return infos
+}
+
+// ConnectionsOlderThan returns a snapshot of information about each client
+// connection accepted more than d ago, oldest first. Use it with
+// DropConnection to recycle long lived connections.
+func (t *TCP) ConnectionsOlderThan(d time.Duration) []ConnInfo {
+	cutoff := time.Now().Add(-d)
+
+	var infos []ConnInfo
+	for _, c := range t.copyClients() {
+		if c.joined.Before(cutoff) {
+			infos = append(infos, c.info())
+		}
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].ConnectedAt.Before(infos[j].ConnectedAt)
+	})
+
+	return infos
+}
@@ -1,12 +1,17 @@
 package tcp
 
 import (
+	"bufio"
+	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
+	"io"
 	"net"
 	"strconv"
 	"sync"
 	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/ardanlabs/kit/pool"
@@ -20,6 +25,150 @@ var (
 	ErrInvalidReqHandler        = errors.New("Invalid Request Handler Configuration")
 	ErrInvalidRespHandler       = errors.New("Invalid Response Handler Configuration")
 	ErrInvalidPoolConfiguration = errors.New("Invalid Pool Configuration")
+	ErrInvalidPoolBudget        = errors.New("Invalid Pool Budget Configuration")
+	ErrPoolNotRunning           = errors.New("Pool Has Been Shutdown")
+	ErrInvalidListenBacklog     = errors.New("Invalid Listen Backlog Configuration")
+	ErrInvalidLinger            = errors.New("Invalid Linger Configuration")
+	ErrInvalidFastOpenQueue     = errors.New("Invalid Fast Open Queue Configuration")
+	ErrInvalidReadBufferSize    = errors.New("Invalid Read Buffer Size Configuration")
+	ErrInvalidWriteBufferSize   = errors.New("Invalid Write Buffer Size Configuration")
+	ErrInvalidFlushInterval     = errors.New("Invalid Flush Interval Configuration")
+	ErrInvalidRateLimitBurst    = errors.New("Invalid Rate Limit Burst Configuration")
+	ErrInvalidWriteRateLimit    = errors.New("Invalid Write Rate Limit Configuration")
+	ErrInvalidWriteTimeout      = errors.New("Invalid Write Timeout Configuration")
+	ErrInvalidFirstByteTimeout  = errors.New("Invalid First Byte Timeout Configuration")
+	ErrInvalidMaxReadDuration   = errors.New("Invalid Max Read Duration Configuration")
+	ErrInvalidDuplicateWait     = errors.New("Invalid Duplicate Wait Configuration")
+	ErrInvalidMaxWriteErrors    = errors.New("Invalid Max Write Errors Configuration")
+	ErrInvalidLeakThreshold     = errors.New("Invalid Leak Threshold Configuration")
+	ErrInvalidConnCountDebounce = errors.New("Invalid Connection Count Debounce Configuration")
+	ErrInvalidTLSConfiguration  = errors.New("Invalid TLS Configuration")
+	ErrInvalidHandshakeTimeout  = errors.New("Invalid Handshake Timeout Configuration")
+	ErrInvalidProbeTimeout      = errors.New("Invalid Probe Timeout Configuration")
+	ErrInvalidMaxHandshakes     = errors.New("Invalid Max Concurrent Handshakes Configuration")
+)
+
+// ErrFastOpenNotSupported is returned by Validate when FastOpen is set on
+// a system other than linux.
+var ErrFastOpenNotSupported = errors.New("TCP Fast Open is not supported on this system")
+
+// ErrTLSVersionRejected is returned to the TLS handshake when the client
+// only supports versions below the configured MinVersion.
+var ErrTLSVersionRejected = errors.New("TLS version rejected")
+
+// ErrSNINotAllowed is returned to the TLS handshake when the server name
+// sent by the client is not allowed.
+var ErrSNINotAllowed = errors.New("Server name not allowed")
+
+// acceptErrsBuffer is the number of accept errors held for AcceptErrors
+// before errors are dropped.
+const acceptErrsBuffer = 16
+
+// defaultConnCountDebounce is the time changes to the connection count
+// are collected for when a debounce is not configured.
+const defaultConnCountDebounce = 100 * time.Millisecond
+
+// defaultFastOpenQueue is the number of pending fast open requests when a
+// queue length is not configured.
+const defaultFastOpenQueue = 256
+
+// defaultHandshakeTimeout is the time allowed for a TLS handshake when a
+// timeout is not configured.
+const defaultHandshakeTimeout = 10 * time.Second
+
+// defaultProbeTimeout is the time ProbeFunc has to read from a connection
+// when a timeout is not configured. Accepting waits on it, so it is short.
+const defaultProbeTimeout = 100 * time.Millisecond
+
+// handshakeWait is how long a TLS connection waits for one of the
+// MaxConcurrentHandshakes to finish before it is dropped.
+const handshakeWait = 100 * time.Millisecond
+
+// defaultPoolRecvShare is the share of the pool budget given to the recv
+// pool when a share is not configured.
+const defaultPoolRecvShare = 0.5
+
+// defaultReadBufferSize is the size of the read buffer for each
+// connection when one is not configured.
+const defaultReadBufferSize = 4096
+
+// ErrCancelled is reported in Response.Err when the response was not
+// written because it was cancelled with CancelPending.
+var ErrCancelled = errors.New("Response was cancelled")
+
+// ErrBufferFull is returned when a response is sent while the number of
+// bytes buffered is at or above MaxBufferedBytes.
+var ErrBufferFull = errors.New("Too many bytes buffered")
+
+// ErrConnBufferFull is returned when a response is sent to a client that
+// would be left with more than MaxPendingBytesPerConn bytes waiting to be
+// written.
+var ErrConnBufferFull = errors.New("Too many bytes waiting to be written to the client")
+
+// ErrTooManyPending is returned when a response is sent while the number of
+// responses waiting to be written across all clients is at
+// MaxPendingResponses.
+var ErrTooManyPending = errors.New("Too many responses waiting to be written")
+
+// ErrSentUnbuffered is returned when a response is sent with a Sent
+// channel that has no buffer, since the error could never be delivered.
+var ErrSentUnbuffered = errors.New("Sent channel has no buffer")
+
+// ErrStale is reported in Response.Err when the response was not written
+// because its deadline had passed.
+var ErrStale = errors.New("Response is stale")
+
+// ErrClientGone is reported in Response.Err when the response was not
+// written because the client connection had been removed or was closing.
+var ErrClientGone = errors.New("Client connection has been removed")
+
+// ErrSkipRequest is returned by a RequestMiddleware to have the request
+// skipped without dropping the connection.
+var ErrSkipRequest = errors.New("Request skipped")
+
+// ErrStopped is returned when work is submitted after the TCP value
+// has been stopped.
+var ErrStopped = errors.New("This TCP has been stopped")
+
+// Set of reasons reported to OnDrop when a connection is dropped.
+const (
+	DropReasonDropConns  = "drop_connections" // DropConnections has been set.
+	DropReasonRateLimit  = "rate_limit"       // Connection came in under the rate limit.
+	DropReasonDuplicate  = "duplicate"        // Remote address is already connected.
+	DropReasonSNI        = "sni"              // TLS server name is not allowed.
+	DropReasonTLSVersion = "tls_version"      // Client only supports TLS versions below MinVersion.
+	DropReasonHandshakes = "handshakes"       // MaxConcurrentHandshakes were running for too long.
+	DropReasonAdmit      = "admit"            // AdmitFunc did not admit the connection.
+	DropReasonCIDR       = "cidr"             // Remote address is denied or not allowed.
+	DropReasonFilter     = "filter"           // An AcceptFilter did not allow the connection without a reason.
+	DropReasonLoadShed   = "load_shed"        // LoadShedFunc reported the host is overloaded.
+)
+
+// temporary is declared to test for the existence of the method coming
+// from the net package.
+type temporary interface {
+	Temporary() bool
+}
+
+// Set of intervals used when polling for a state change.
+const (
+	drainPoll        = 100 * time.Millisecond // How often StopGraceful checks connections for pending work.
+	backpressurePoll = 10 * time.Millisecond  // How often a paused read checks the recv pool.
+	pausePoll        = 10 * time.Millisecond  // How often a paused accept routine checks to resume.
+	duplicatePoll    = time.Millisecond       // How often join checks if a connection with the same address is gone.
+)
+
+// Set of limits for the backoff between reads after a temporary error.
+const (
+	minReadBackoff = 5 * time.Millisecond
+	maxReadBackoff = time.Second
+)
+
+// Set of limits for the backoff between accepts after the listener returns
+// no connection and no error.
+const (
+	minAcceptBackoff = 5 * time.Millisecond
+	maxAcceptBackoff = time.Second
 )
 
 //==============================================================================
@@ -32,23 +181,64 @@ type TCP struct {
 	ipAddress string
 	port      int
 	tcpAddr   *net.TCPAddr
+	labels    map[string]string
 
-	listener   *net.TCPListener
+	listener   net.Listener
 	listenerMu sync.Mutex
+	listenFn   func(addr *net.TCPAddr) (net.Listener, error)
 
 	clients   map[string]*client
 	clientsMu sync.Mutex
 
+	acceptErrs chan error
+	events     chan ConnEvent
+	connIDs    uint64 // Last ID given to a connection.
+
+	countMu      sync.Mutex
+	countPending bool // A report of the connection count is scheduled.
+	countLast    int  // Connection count last reported.
+
+	handlers   atomic.Value // *handlers
+	handlersMu sync.Mutex
+
+	allowCIDRs atomic.Value // cidrs
+	denyCIDRs  atomic.Value // cidrs
+
 	recv      *pool.Pool
 	send      *pool.Pool
 	userPools bool
+	recvMu    sync.RWMutex // Held for reading while work is submitted to recv, guards userPools.
+	sendMu    sync.RWMutex // Held for reading while work is submitted to send.
 
-	wg sync.WaitGroup
+	wg         sync.WaitGroup
+	goroutines int64 // Number of goroutines running, not counting the pools.
 
 	dropConns    int32
+	acceptPaused int32
 	shuttingDown int32
-
-	lastAcceptedConnection time.Time
+	stopped      int32
+	leaking      int32
+
+	acceptedTotal uint64
+	acceptedTLS   uint64
+	acceptedPlain uint64
+	probes        uint64
+	resets        uint64
+	notAdmitted   uint64
+	buffered      int64
+	pendingResps  int64 // Responses waiting to be written across all clients.
+	process       latency
+
+	drops     map[string]uint64        // Number of connections dropped by reason.
+	dropRates map[string]*rollingCount // Connections dropped over the last minute by reason.
+	dropsMu   sync.Mutex
+
+	connLimit  limiter
+	handshakes chan struct{} // Holds a value for each handshake running, nil for no limit.
+
+	deltaLast TCPStat   // Snapshot taken by the last call to StatsDelta.
+	deltaAt   time.Time // When the last snapshot was taken, New for the first call.
+	deltaMu   sync.Mutex
 }
 
 // New creates a new manager to service clients.
@@ -64,6 +254,17 @@ func New(traceID string, name string, cfg Config) (*TCP, error) {
 		return nil, err
 	}
 
+	// A user provided pool that has been shutdown would fail the first
+	// time work is submitted, so catch it here.
+	if cfg.RecvPool != nil && (!cfg.RecvPool.IsRunning() || !cfg.SendPool.IsRunning()) {
+		return nil, ErrPoolNotRunning
+	}
+
+	// Derive the pool sizes from the budget when one is configured.
+	if cfg.PoolBudgetMax > 0 {
+		cfg.OptIntPool = cfg.budgetPools()
+	}
+
 	// Need a work pool to handle the received messages.
 	var recv *pool.Pool
 	if cfg.RecvPool != nil {
@@ -103,6 +304,12 @@ func New(traceID string, name string, cfg Config) (*TCP, error) {
 		userPools = true
 	}
 
+	// Copy the labels so the caller can't change them after New.
+	labels := map[string]string{"name": name}
+	for k, v := range cfg.Labels {
+		labels[k] = v
+	}
+
 	// Create a TCP for this ipaddress and port.
 	t := TCP{
 		Config: cfg,
@@ -111,14 +318,34 @@ func New(traceID string, name string, cfg Config) (*TCP, error) {
 		ipAddress: tcpAddr.IP.String(),
 		port:      tcpAddr.Port,
 		tcpAddr:   tcpAddr,
+		labels:    labels,
+		deltaAt:   time.Now(),
 
-		clients: make(map[string]*client),
+		clients:    make(map[string]*client),
+		acceptErrs: make(chan error, acceptErrsBuffer),
+		events:     make(chan ConnEvent, eventsBuffer),
 
 		recv:      recv,
 		send:      send,
 		userPools: userPools,
 	}
 
+	if cfg.MaxConcurrentHandshakes > 0 {
+		t.handshakes = make(chan struct{}, cfg.MaxConcurrentHandshakes)
+	}
+
+	t.handlers.Store(&handlers{
+		conn: cfg.ConnHandler,
+		req:  cfg.ReqHandler,
+		resp: cfg.RespHandler,
+	})
+
+	// The lists were checked by Validate.
+	allow, _ := parseCIDRs(cfg.AllowCIDRs)
+	deny, _ := parseCIDRs(cfg.DenyCIDRs)
+	t.allowCIDRs.Store(allow)
+	t.denyCIDRs.Store(deny)
+
 	return &t, nil
 }
 
@@ -136,119 +363,597 @@ func (t *TCP) Start(traceID string) error {
 			t.listenerMu.Unlock()
 			return errors.New("This TCP has already been started")
 		}
+
+		// Start a listener for the specified addr and port. This is done
+		// before the accept routine is launched so an error can be returned.
+		listener, err := t.listen(traceID, t.tcpAddr)
+		if err != nil {
+			t.listenerMu.Unlock()
+			return err
+		}
+
+		t.listener = listener
 	}
 	t.listenerMu.Unlock()
 
+	// Start the connection accept routine.
 	t.wg.Add(1)
+	atomic.AddInt64(&t.goroutines, 1)
+	go t.accept(traceID, t.listener)
 
-	// We need to wait for the goroutine to initialize itself.
-	var waitStart sync.WaitGroup
-	waitStart.Add(1)
+	return nil
+}
 
-	// Start the connection accept routine.
-	go func() {
-		var listener *net.TCPListener
-
-		for {
-			t.listenerMu.Lock()
-			{
-				// Start a listener for the specified addr and port is one
-				// does not exist.
-				if t.listener == nil {
-					var err error
-					listener, err = net.ListenTCP(t.NetType, t.tcpAddr)
-					if err != nil {
-						panic(err)
-					}
-
-					t.listener = listener
-
-					waitStart.Done()
-
-					t.Event(traceID, "accept", "Waiting For Connections : IPAddress[ %s ]", join(t.ipAddress, t.port))
+// accept waits for new connections and adds them to the manager. If the
+// listener fails with a non temporary error, it is re-established on the
+// same address it was bound to.
+func (t *TCP) accept(traceID string, listener net.Listener) {
+	t.Event(traceID, "accept", "Waiting For Connections : IPAddress[ %s ]", listener.Addr())
+
+	// exitErr is the reason the routine stopped, nil on shutdown.
+	var exitErr error
+
+	// Every connection must pass the filters before it is added.
+	filters := t.acceptFilters(traceID)
+
+	// backoff is how long to wait before accepting again after the
+	// listener returned no connection.
+	var backoff time.Duration
+
+	// Handshakes still running when the routine stops are cancelled.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// TLS is applied to each connection once it has been probed, so
+	// the filters and ProbeFunc are given the connection before TLS.
+	var tlsCfg *tls.Config
+	if t.TLSConfig != nil {
+		tlsCfg = t.tlsConfig(traceID)
+	}
+
+	for {
+		// Leave new connections in the listen backlog while paused.
+		t.waitPaused()
+
+		// Listen for new connections.
+		conn, err := listener.Accept()
+		if err != nil {
+			if atomic.LoadInt32(&t.shuttingDown) == 1 {
+				t.listenerMu.Lock()
+				{
+					t.listener = nil
 				}
+				t.listenerMu.Unlock()
+				break
+			}
+
+			t.Event(traceID, "accept", "ERROR : %v", err)
+
+			// Never block accepting on a reader that isn't keeping up.
+			select {
+			case t.acceptErrs <- err:
+			default:
 			}
-			t.listenerMu.Unlock()
 
-			// Listen for new connections.
-			conn, err := listener.Accept()
-			if err != nil {
-				shutdown := atomic.LoadInt32(&t.shuttingDown)
-
-				if shutdown == 0 {
-					t.Event(traceID, "accept", "ERROR : %v", err)
-				} else {
-					t.listenerMu.Lock()
-					{
-						t.listener = nil
-					}
-					t.listenerMu.Unlock()
+			if e, ok := err.(temporary); ok && !e.Temporary() {
+				if listener, exitErr = t.relisten(traceID, listener); listener == nil {
 					break
 				}
+			}
 
-				// temporary is declared to test for the existence of
-				// the method coming from the net package.
-				type temporary interface {
-					Temporary() bool
-				}
+			continue
+		}
 
-				if e, ok := err.(temporary); ok && !e.Temporary() {
-					t.listenerMu.Lock()
-					{
-						t.listener.Close()
-						t.listener = nil
-					}
-					t.listenerMu.Unlock()
-
-					// Don't want to add a flag. So setting this back to
-					// 1 so when the listener is re-established, the call
-					// to Done does not fail.
-					waitStart.Add(1)
-				}
+		// A misbehaving listener can return no connection and no error.
+		// Backoff so it doesn't spin.
+		if conn == nil {
+			t.Event(traceID, "accept", "ERROR : Listener Returned No Connection")
 
-				continue
+			if backoff == 0 {
+				backoff = minAcceptBackoff
+			} else if backoff *= 2; backoff > maxAcceptBackoff {
+				backoff = maxAcceptBackoff
 			}
+			time.Sleep(backoff)
 
-			// Check if we are being asked to drop all new connections.
-			if drop := atomic.LoadInt32(&t.dropConns); drop == 1 {
-				t.Event(traceID, "accept", "*******> DROPPING CONNECTION")
-				conn.Close()
-				continue
+			continue
+		}
+
+		backoff = 0
+
+		if t.Linger != nil {
+			t.setLinger(traceID, conn)
+		}
+
+		// Hold a connection accepted as the pause began until resumed.
+		if !t.waitPaused() {
+			conn.Close()
+			continue
+		}
+
+		// Drop the connection if any filter doesn't allow it.
+		if allow, reason := filterConn(filters, conn); !allow {
+			t.drop(conn, reason)
+			continue
+		}
+
+		// Probes and TLS handshakes wait on the client, so they have
+		// their own routine and accepting isn't held up.
+		if t.ProbeFunc != nil || tlsCfg != nil {
+			t.wg.Add(1)
+			atomic.AddInt64(&t.goroutines, 1)
+			go t.handshake(ctx, traceID, conn, tlsCfg)
+			continue
+		}
+
+		// Add this new connection to the manager map.
+		t.admit(traceID, conn, false)
+	}
+
+	// Shutting down the routine.
+	atomic.AddInt64(&t.goroutines, -1)
+	t.wg.Done()
+	t.Event(traceID, "accept", "Shutdown : IPAddress[ %s ]", join(t.ipAddress, t.port))
+
+	t.AcceptExit(exitErr)
+}
+
+// listen creates a listener for the specified address and applies the
+// listen socket and TLS configuration.
+func (t *TCP) listen(traceID string, addr *net.TCPAddr) (net.Listener, error) {
+	var listener net.Listener
+
+	if t.listenFn != nil {
+		var err error
+		if listener, err = t.listenFn(addr); err != nil {
+			return nil, err
+		}
+	} else {
+		var lc net.ListenConfig
+		if t.FastOpen {
+			lc.Control = func(network string, address string, rc syscall.RawConn) error {
+				return setFastOpen(rc, t.fastOpenQueue())
 			}
+		}
 
-			// Check if rate limit is enabled.
-			if t.RateLimit != nil {
-				now := time.Now()
+		l, err := lc.Listen(context.Background(), t.NetType, addr.String())
+		if err != nil {
+			return nil, err
+		}
+		tl := l.(*net.TCPListener)
 
-				// We will only accept 1 connection per duration. Anything
-				// connection above that must be dropped.
-				if t.lastAcceptedConnection.Add(t.RateLimit()).After(now) {
-					t.Event(traceID, "accept", "*******> DROPPING CONNECTION Local[ %v ] Remote[ %v ] DUE TO RATE LIMIT %v", conn.LocalAddr(), conn.RemoteAddr(), t.RateLimit())
-					conn.Close()
-					continue
-				}
+		if t.ListenBacklog > 0 {
+			if err := setBacklog(tl, t.ListenBacklog); err != nil {
+				t.Event(traceID, "listen", "ERROR : Setting Listen Backlog : %v", err)
+			}
+		}
+
+		listener = tl
+	}
+
+	return listener, nil
+}
 
-				// Since we accepted connection, mark the time.
-				t.lastAcceptedConnection = now
+// fastOpenQueue returns the number of pending fast open requests allowed.
+func (t *TCP) fastOpenQueue() int {
+	if t.FastOpenQueue == 0 {
+		return defaultFastOpenQueue
+	}
+	return t.FastOpenQueue
+}
+
+// setLinger sets how long closing the accepted connection waits to send
+// unsent data. Connections that aren't a *net.TCPConn, like in-memory
+// ones, are left as is.
+func (t *TCP) setLinger(traceID string, conn net.Conn) {
+	// The socket is under the TLS connection.
+	if tc, ok := conn.(*tls.Conn); ok {
+		conn = tc.NetConn()
+	}
+
+	tc, ok := conn.(*net.TCPConn)
+	if !ok {
+		return
+	}
+
+	if err := tc.SetLinger(*t.Linger); err != nil {
+		t.Event(traceID, "accept", "ERROR : Setting Linger : %v", err)
+	}
+}
+
+// tlsConfig returns the TLS configuration to use for the listener. The
+// user's configuration is not flattened, so its hooks are called for
+// every handshake. When AllowSNI is set, handshakes for a server name
+// that isn't allowed are failed and the connection is reported as dropped.
+// When MinVersion is set, handshakes from clients that only support older
+// versions are failed the same way, instead of as a generic handshake error.
+func (t *TCP) tlsConfig(traceID string) *tls.Config {
+	if t.AllowSNI == nil && t.TLSConfig.MinVersion == 0 {
+		return t.TLSConfig
+	}
+
+	cfg := t.TLSConfig.Clone()
+	getConfig := t.TLSConfig.GetConfigForClient
+
+	cfg.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		if t.AllowSNI != nil && !t.AllowSNI(hello.ServerName) {
+			t.Event(traceID, "handshake", "*******> DROPPING CONNECTION Remote[ %v ] DUE TO SNI[ %s ]", hello.Conn.RemoteAddr(), hello.ServerName)
+			t.dropped(DropReasonSNI, hello.Conn.RemoteAddr().String())
+			return nil, ErrSNINotAllowed
+		}
+
+		var hcfg *tls.Config
+		if getConfig != nil {
+			var err error
+			if hcfg, err = getConfig(hello); err != nil {
+				return nil, err
 			}
+		}
+
+		// A configuration returned for this client has the final say
+		// on the versions allowed.
+		minVersion := t.TLSConfig.MinVersion
+		if hcfg != nil {
+			minVersion = hcfg.MinVersion
+		}
 
-			// Add this new connection to the manager map.
-			t.join(traceID, conn)
+		if !tlsVersionAllowed(hello.SupportedVersions, minVersion) {
+			t.Event(traceID, "handshake", "*******> DROPPING CONNECTION Remote[ %v ] DUE TO TLS VERSION REJECTED %x", hello.Conn.RemoteAddr(), hello.SupportedVersions)
+			t.dropped(DropReasonTLSVersion, hello.Conn.RemoteAddr().String())
+			return nil, ErrTLSVersionRejected
 		}
 
-		// Shutting down the routine.
+		return hcfg, nil
+	}
+
+	return cfg
+}
+
+// handshake gives an accepted connection to ProbeFunc and then, with a TLS
+// configuration, runs the TLS handshake. The connection is added once both
+// are done. A connection that fails the handshake, or doesn't finish it
+// within the HandshakeTimeout, is closed. The probe of a TLS connection
+// counts toward MaxConcurrentHandshakes.
+func (t *TCP) handshake(ctx context.Context, traceID string, conn net.Conn, cfg *tls.Config) {
+	defer func() {
+		atomic.AddInt64(&t.goroutines, -1)
 		t.wg.Done()
-		t.Event(traceID, "accept", "Shutdown : IPAddress[ %s ]", join(t.ipAddress, t.port))
 	}()
 
-	// Wait for the goroutine to initialize itself.
-	waitStart.Wait()
+	// Wait briefly for a running handshake to finish when too many
+	// are running, then drop the connection.
+	if cfg != nil && t.handshakes != nil {
+		timer := time.NewTimer(handshakeWait)
+		select {
+		case t.handshakes <- struct{}{}:
+			timer.Stop()
+			defer func() { <-t.handshakes }()
+
+		case <-timer.C:
+			t.Event(traceID, "handshake", "*******> DROPPING CONNECTION Remote[ %v ] DUE TO MAX CONCURRENT HANDSHAKES[ %d ]", conn.RemoteAddr(), t.MaxConcurrentHandshakes)
+			t.drop(conn, DropReasonHandshakes)
+			return
 
-	return nil
+		case <-ctx.Done():
+			timer.Stop()
+			conn.Close()
+			return
+		}
+	}
+
+	// Answer health probes without adding a client connection.
+	if t.ProbeFunc != nil {
+		var probed bool
+		if conn, probed = t.probe(traceID, conn); probed {
+			return
+		}
+	}
+
+	if cfg == nil {
+		t.admit(traceID, conn, false)
+		return
+	}
+
+	timeout := t.HandshakeTimeout
+	if timeout == 0 {
+		timeout = defaultHandshakeTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	tc := tls.Server(conn, cfg)
+	if err := tc.HandshakeContext(ctx); err != nil {
+		t.Event(traceID, "handshake", "ERROR : %v : Remote[ %v ]", err, conn.RemoteAddr())
+		tc.Close()
+		return
+	}
+
+	t.admit(traceID, tc, true)
+}
+
+// admit lets the user wrap the connection and adds it to the manager. With
+// TLS, the connection being wrapped is the *tls.Conn.
+func (t *TCP) admit(traceID string, conn net.Conn, isTLS bool) {
+	if t.WrapConn != nil {
+		conn = t.WrapConn(conn)
+	}
+
+	t.join(traceID, conn, isTLS)
+}
+
+// tlsVersionAllowed reports if the client supports a version at or above
+// the min version. A min version of 0 allows every version.
+func tlsVersionAllowed(supported []uint16, minVersion uint16) bool {
+	if minVersion == 0 {
+		return true
+	}
+
+	for _, v := range supported {
+		if v >= minVersion {
+			return true
+		}
+	}
+
+	return false
+}
+
+// relisten closes the failed listener and binds a new one on the same
+// address. It returns a nil listener if the manager is shutting down or
+// the new listener can't be established, which terminates the accept
+// routine. The error reports why the listener couldn't be established.
+func (t *TCP) relisten(traceID string, old net.Listener) (net.Listener, error) {
+	t.listenerMu.Lock()
+	defer t.listenerMu.Unlock()
+
+	old.Close()
+	t.listener = nil
+
+	// Stop may have started while the listener was failing.
+	if atomic.LoadInt32(&t.shuttingDown) == 1 {
+		return nil, nil
+	}
+
+	// Bind to the address the old listener had, which has the port
+	// the OS assigned when the configured port was 0.
+	addr := tcpAddr(old.Addr())
+	if addr == nil {
+		addr = t.tcpAddr
+	}
+
+	listener, err := t.listen(traceID, addr)
+	if err != nil {
+		t.Event(traceID, "accept", "ERROR : Re-establishing Listener : %v", err)
+		return nil, err
+	}
+
+	t.listener = listener
+	t.Event(traceID, "accept", "Waiting For Connections : IPAddress[ %s ]", listener.Addr())
+
+	return listener, nil
 }
 
 // Stop shuts down the manager and closes all connections.
 func (t *TCP) Stop(traceID string) error {
+	if err := t.closeListener(); err != nil {
+		return err
+	}
+
+	// Give clients a chance to notice and disconnect on their own.
+	// Responses continue to be written during this time.
+	if t.StopGrace > 0 {
+		t.Event(traceID, "Stop", "Grace Period : %v", t.StopGrace)
+		time.Sleep(t.StopGrace)
+	}
+
+	// Stop processing all the work.
+	t.shutdownPools(traceID)
+
+	// Drop all the existing connections.
+	for _, c := range t.copyClients() {
+		// This waits for each routine to terminate.
+		c.drop(CloseShutdown)
+	}
+
+	// Wait for the accept routine to terminate.
+	t.wg.Wait()
+
+	return nil
+}
+
+// StopGraceful shuts down the manager but gives each connection the chance
+// to finish processing its requests and writing its pending responses
+// before it is closed. If there is
+// a DrainMessage, it is sent to each connection first so clients know to
+// reconnect elsewhere. OnDrained is called for each connection once it has
+// drained. Connections that have not
+// drained after the specified timeout are dropped. If a progress
+// channel is provided, the number of connections remaining is sent on it
+// periodically and the channel is closed when shutdown completes. Sends on
+// the progress channel never block, so values are lost if it is not read.
+func (t *TCP) StopGraceful(traceID string, timeout time.Duration, progress chan<- int) error {
+	if progress != nil {
+		defer close(progress)
+	}
+
+	if err := t.closeListener(); err != nil {
+		return err
+	}
+
+	t.Event(traceID, "StopGraceful", "Draining Connections : Timeout[ %v ]", timeout)
+
+	t.drain(traceID, time.Now().Add(timeout), progress)
+
+	// Stop processing all the work.
+	t.shutdownPools(traceID)
+
+	// Wait for the accept routine to terminate.
+	t.wg.Wait()
+
+	if progress != nil {
+		select {
+		case progress <- 0:
+		default:
+		}
+	}
+
+	return nil
+}
+
+// StopAll shuts down a set of managers that may share user provided pools.
+// Every listener is closed before any connection is dropped, so clients
+// can't reconnect to one instance while another is shutting down. The
+// longest StopGrace is waited once. Connections are not drained and user
+// provided pools are left running for the caller to shutdown once StopAll
+// returns, use StopAllGraceful for both. All the instances are stopped
+// even if one fails and the first error is returned.
+func StopAll(traceID string, instances ...*TCP) error {
+	// Don't accept anymore client connections anywhere.
+	stopping, firstErr := closeListeners(instances)
+
+	var grace time.Duration
+	for _, t := range stopping {
+		if t.StopGrace > grace {
+			grace = t.StopGrace
+		}
+	}
+
+	if grace > 0 {
+		time.Sleep(grace)
+	}
+
+	// Stop the work and drop the connections for each instance.
+	for _, t := range stopping {
+		t.Event(traceID, "StopAll", "Stopping : Name[ %s ]", t.Name)
+
+		t.shutdownPools(traceID)
+
+		for _, c := range t.copyClients() {
+			c.drop(CloseShutdown)
+		}
+
+		t.wg.Wait()
+	}
+
+	return firstErr
+}
+
+// StopAllGraceful shuts down a set of managers like StopAll, but drains the
+// connections of every instance like StopGraceful, all within the one
+// timeout. Once every instance has stopped, the shared pools provided are
+// shutdown, skipping any that are already shutdown. All the
+// instances are stopped even if one fails and the first error is returned.
+func StopAllGraceful(traceID string, timeout time.Duration, instances []*TCP, pools ...*pool.Pool) error {
+	// Don't accept anymore client connections anywhere.
+	stopping, firstErr := closeListeners(instances)
+
+	// Drain the connections of every instance at the same time.
+	deadline := time.Now().Add(timeout)
+
+	var wg sync.WaitGroup
+	wg.Add(len(stopping))
+	for _, t := range stopping {
+		go func(t *TCP) {
+			defer wg.Done()
+
+			t.Event(traceID, "StopAllGraceful", "Draining Connections : Name[ %s ] Timeout[ %v ]", t.Name, timeout)
+			t.drain(traceID, deadline, nil)
+		}(t)
+	}
+	wg.Wait()
+
+	// Stop the work for each instance.
+	for _, t := range stopping {
+		t.shutdownPools(traceID)
+		t.wg.Wait()
+	}
+
+	// Shutdown the shared pools now nothing is using them.
+	for _, p := range pools {
+		if p != nil && p.IsRunning() {
+			p.Shutdown(traceID)
+		}
+	}
+
+	return firstErr
+}
+
+// closeListeners closes the listener of each manager and returns the ones
+// that were closed along with the first error.
+func closeListeners(instances []*TCP) ([]*TCP, error) {
+	var firstErr error
+
+	closed := make([]*TCP, 0, len(instances))
+	for _, t := range instances {
+		if err := t.closeListener(); err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+
+		closed = append(closed, t)
+	}
+
+	return closed, firstErr
+}
+
+// drain sends the DrainMessage and drops each connection once it has no
+// requests in flight and no pending responses. Connections that have not
+// drained by the deadline are dropped.
+func (t *TCP) drain(traceID string, deadline time.Time, progress chan<- int) {
+	// Let the clients know the connection is draining. The message is
+	// pending like any other response, so it is written before the
+	// connection is dropped.
+	if t.DrainMessage != nil {
+		for _, c := range t.copyClients() {
+			r := Response{
+				Data:   t.DrainMessage,
+				Length: len(t.DrainMessage),
+			}
+			if err := t.do(traceID, c.ipAddress, &r); err != nil {
+				t.Event(traceID, "drain", "ERROR : %v : IPAddress[ %s ]", err, c.ipAddress)
+			}
+		}
+	}
+
+	for {
+		// Drop every connection that has no requests in flight and no
+		// pending responses.
+		var remaining int
+		for _, c := range t.copyClients() {
+			if atomic.LoadInt64(&c.inFlight) > 0 || atomic.LoadInt64(&c.pending) > 0 {
+				remaining++
+				continue
+			}
+			t.Drained(c.ipAddress)
+			c.stopFlush(true)
+			c.drop(CloseShutdown)
+		}
+
+		if progress != nil {
+			select {
+			case progress <- remaining:
+			default:
+			}
+		}
+
+		if remaining == 0 || time.Now().After(deadline) {
+			break
+		}
+
+		time.Sleep(drainPoll)
+	}
+
+	// Drop any connection that did not drain in time.
+	for _, c := range t.copyClients() {
+		c.drop(CloseShutdown)
+	}
+}
+
+// closeListener marks the manager as shutting down and closes the listener
+// so no more client connections are accepted.
+func (t *TCP) closeListener() error {
 	t.listenerMu.Lock()
 	{
 		// If the listener has been stopped already, return an error.
@@ -262,70 +967,234 @@ func (t *TCP) Stop(traceID string) error {
 	// Mark that we are shutting down.
 	atomic.StoreInt32(&t.shuttingDown, 1)
 
-	// Don't accept anymore client connections.
+	// Don't accept anymore client connections. The listener can be nil
+	// if the accept routine failed to re-establish it.
 	t.listenerMu.Lock()
 	{
-		t.listener.Close()
+		if t.listener != nil {
+			t.listener.Close()
+		}
 	}
 	t.listenerMu.Unlock()
 
-	// Stop processing all the work.
-	if !t.userPools {
-		t.recv.Shutdown(traceID)
-		t.send.Shutdown(traceID)
+	return nil
+}
+
+// shutdownPools shuts down the work pools if they are owned by the manager.
+// No more responses are accepted by Do after this call.
+func (t *TCP) shutdownPools(traceID string) {
+	atomic.StoreInt32(&t.stopped, 1)
+
+	t.recvMu.RLock()
+	recv, userPools := t.recv, t.userPools
+	t.recvMu.RUnlock()
+
+	t.sendMu.RLock()
+	send := t.send
+	t.sendMu.RUnlock()
+
+	if !userPools {
+		recv.Shutdown(traceID)
+		send.Shutdown(traceID)
 	}
+}
 
-	// Make a copy of all the connections. We need to do this
-	// since we have to lock the map to read it. Dropping a
-	// connection requires locks as well.
-	var clients map[string]*client
+// copyClients makes a copy of all the connections. We need to do this
+// since we have to lock the map to read it. Dropping a connection
+// requires locks as well.
+func (t *TCP) copyClients() []*client {
 	t.clientsMu.Lock()
-	{
-		clients = make(map[string]*client)
-		for k, v := range t.clients {
-			clients[k] = v
+	defer t.clientsMu.Unlock()
+
+	clients := make([]*client, 0, len(t.clients))
+	for _, c := range t.clients {
+		clients = append(clients, c)
+	}
+
+	return clients
+}
+
+// Do will post the request to be sent by the client worker pool.
+func (t *TCP) Do(traceID string, r *Response) error {
+	return t.do(traceID, r.TCPAddr.String(), r)
+}
+
+// DoMulti will post a response with the specified data for each of the
+// specified addresses. An error is returned in the map for each address
+// the response could not be posted for.
+func (t *TCP) DoMulti(traceID string, addrs []string, data []byte) map[string]error {
+	errs := make(map[string]error)
+
+	for _, addr := range addrs {
+		r := Response{
+			Data:   data,
+			Length: len(data),
+		}
+
+		if err := t.do(traceID, addr, &r); err != nil {
+			errs[addr] = err
 		}
 	}
-	t.clientsMu.Unlock()
 
-	// Drop all the existing connections.
-	for _, c := range clients {
-		// This waits for each routine to terminate.
-		c.drop()
+	return errs
+}
+
+// BroadcastFunc will post a response with the specified data for each
+// client connection the match function returns true for and returns the
+// number posted. The connections are matched against a snapshot taken at
+// the time of the call. The first error posting a response is returned
+// after the remaining matches have been tried.
+func (t *TCP) BroadcastFunc(traceID string, match func(ConnInfo) bool, data []byte) (sent int, err error) {
+	for _, c := range t.copyClients() {
+		if !match(c.info()) {
+			continue
+		}
+
+		r := Response{
+			Data:   data,
+			Length: len(data),
+		}
+
+		if perr := t.do(traceID, c.ipAddress, &r); perr != nil {
+			// Nothing else can be posted once the TCP value is stopped
+			// or too many bytes or responses are buffered.
+			if perr == ErrStopped || perr == ErrBufferFull || perr == ErrTooManyPending {
+				return sent, perr
+			}
+
+			if err == nil {
+				err = perr
+			}
+			continue
+		}
+
+		sent++
 	}
 
-	// Wait for the accept routine to terminate.
-	t.wg.Wait()
+	return sent, err
+}
+
+// DoContext will post the request to be sent by the client worker pool. If
+// the pool is busy, it waits for the pool to take the work until the context
+// is done and then returns the context's error.
+func (t *TCP) DoContext(ctx context.Context, traceID string, r *Response) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if err := t.prepare(traceID, r.TCPAddr.String(), r); err != nil {
+		return err
+	}
+
+	// Send this to the client work pool for processing.
+	if err := t.doSendCancel(ctx, traceID, r); err != nil {
+		r.release()
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		return err
+	}
 
 	return nil
 }
 
-// Do will post the request to be sent by the client worker pool.
-func (t *TCP) Do(traceID string, r *Response) error {
+// do posts the response to be sent by the client worker pool.
+func (t *TCP) do(traceID string, addr string, r *Response) error {
+	if err := t.prepare(traceID, addr, r); err != nil {
+		return err
+	}
+
+	// Send this to the client work pool for processing.
+	t.doSend(traceID, r)
+
+	return nil
+}
+
+// prepare finds the client connection for the specified address and
+// readies the response to be posted to the client worker pool.
+func (t *TCP) prepare(traceID string, addr string, r *Response) error {
+	// User provided pools keep running after Stop, but the
+	// connections the work is for are gone.
+	if atomic.LoadInt32(&t.stopped) == 1 {
+		return ErrStopped
+	}
+
+	// The send pool never blocks on Sent, so without a buffer the
+	// error would be dropped.
+	if r.Sent != nil && cap(r.Sent) == 0 {
+		return ErrSentUnbuffered
+	}
+
+	// Push back on the caller while too many bytes are buffered.
+	if t.bufferFull() {
+		return ErrBufferFull
+	}
+
 	// Find the client connection for this IPAddress.
 	var c *client
 	t.clientsMu.Lock()
 	{
 		// If this ipaddress and socket does not exist, report an error.
 		var ok bool
-		if c, ok = t.clients[r.TCPAddr.String()]; !ok {
+		if c, ok = t.clients[addr]; !ok {
 			t.clientsMu.Unlock()
-			return fmt.Errorf("IP Address disconnected [ %s ]", r.TCPAddr.String())
+			return fmt.Errorf("IP Address disconnected [ %s ]", addr)
 		}
 	}
 	t.clientsMu.Unlock()
 
+	// Responses built from an address string need the TCPAddr.
+	if r.TCPAddr == nil {
+		r.TCPAddr = c.remoteAddr()
+	}
+
 	// Set the unexported fields.
 	r.tcp = t
 	r.client = c
 	r.traceID = traceID
 
-	// Send this to the client work pool for processing.
-	t.send.Do(traceID, r)
+	// Push back on the caller while too many responses are waiting
+	// to be written across all clients.
+	n := atomic.AddInt64(&t.pendingResps, 1)
+	if t.MaxPendingResponses > 0 && n > t.MaxPendingResponses {
+		atomic.AddInt64(&t.pendingResps, -1)
+		return ErrTooManyPending
+	}
+
+	// Push back on the caller while the client has too many bytes
+	// waiting to be written.
+	size := r.size()
+	if !c.reserve(int64(size)) {
+		atomic.AddInt64(&t.pendingResps, -1)
+		return ErrConnBufferFull
+	}
+
+	// Track the response until it has been written.
+	r.gen = c.track()
+
+	// The response data is buffered until it has been written.
+	r.buffered = size
+	atomic.AddInt64(&t.buffered, int64(r.buffered))
 
 	return nil
 }
 
+// CancelPending cancels the responses waiting to be written to the client
+// with the specified address and returns the number cancelled. Each one is
+// completed with ErrCancelled instead of being written. Responses already
+// being written are not cancelled.
+func (t *TCP) CancelPending(addr string) int {
+	t.clientsMu.Lock()
+	c, ok := t.clients[addr]
+	t.clientsMu.Unlock()
+
+	if !ok {
+		return 0
+	}
+
+	return c.cancelPending()
+}
+
 // DropConnections sets a flag to tell the accept routine to immediately
 // drop connections that come in.
 func (t *TCP) DropConnections(traceID string, drop bool) {
@@ -337,16 +1206,170 @@ func (t *TCP) DropConnections(traceID string, drop bool) {
 	atomic.StoreInt32(&t.dropConns, 0)
 }
 
+// DropConnection drops the client connection with the specified address.
+// Responses still waiting to be written to it are not written.
+func (t *TCP) DropConnection(traceID string, addr string) error {
+	t.clientsMu.Lock()
+	c, ok := t.clients[addr]
+	t.clientsMu.Unlock()
+
+	if !ok {
+		return fmt.Errorf("IP Address disconnected [ %s ]", addr)
+	}
+
+	c.drop(CloseDropped)
+
+	t.Event(traceID, "DropConnection", "IPAddress[ %s ]", addr)
+	return nil
+}
+
+// PauseAccept stops or resumes accepting new connections without closing
+// the listener or dropping existing connections. While paused, new
+// connections wait in the listen backlog, so keep pauses short.
+func (t *TCP) PauseAccept(pause bool) {
+	if pause {
+		atomic.StoreInt32(&t.acceptPaused, 1)
+		return
+	}
+
+	atomic.StoreInt32(&t.acceptPaused, 0)
+}
+
+// waitPaused blocks while accepting is paused. It reports false if the
+// manager started shutting down while paused.
+func (t *TCP) waitPaused() bool {
+	for atomic.LoadInt32(&t.acceptPaused) == 1 {
+		if atomic.LoadInt32(&t.shuttingDown) == 1 {
+			return false
+		}
+		time.Sleep(pausePoll)
+	}
+
+	return true
+}
+
+// ResetConnections drops every client connection without stopping the
+// listener, so clients can reconnect with fresh state.
+func (t *TCP) ResetConnections(traceID string) {
+	clients := t.copyClients()
+	for _, c := range clients {
+		c.drop(CloseDropped)
+	}
+
+	atomic.AddUint64(&t.resets, 1)
+
+	t.Event(traceID, "ResetConnections", "Reset %d Connections", len(clients))
+}
+
+// SetConnHandler replaces the connection handler. Only connections
+// accepted after the call are bound with the new handler.
+func (t *TCP) SetConnHandler(h ConnHandler) error {
+	if h == nil {
+		return ErrInvalidConnHandler
+	}
+
+	t.swapHandlers(func(hs *handlers) { hs.conn = h })
+	return nil
+}
+
+// SetReqHandler replaces the request handler. Requests already read or
+// being processed may still be handled by the old handler.
+func (t *TCP) SetReqHandler(h ReqHandler) error {
+	if h == nil {
+		return ErrInvalidReqHandler
+	}
+
+	t.swapHandlers(func(hs *handlers) { hs.req = h })
+	return nil
+}
+
+// SetRespHandler replaces the response handler. Responses already being
+// written may still be handled by the old handler.
+func (t *TCP) SetRespHandler(h RespHandler) error {
+	if h == nil {
+		return ErrInvalidRespHandler
+	}
+
+	t.swapHandlers(func(hs *handlers) { hs.resp = h })
+	return nil
+}
+
+// swapHandlers stores a modified copy of the current handlers.
+func (t *TCP) swapHandlers(modify func(hs *handlers)) {
+	t.handlersMu.Lock()
+	defer t.handlersMu.Unlock()
+
+	hs := *t.loadHandlers()
+	modify(&hs)
+	t.handlers.Store(&hs)
+}
+
+// bufferFull reports if the number of bytes buffered across all the
+// connections is at or above the configured limit.
+func (t *TCP) bufferFull() bool {
+	return t.MaxBufferedBytes > 0 && atomic.LoadInt64(&t.buffered) >= t.MaxBufferedBytes
+}
+
+// newReader returns the buffered reader a connection owns for the reader
+// bound by the ConnHandler. Bytes buffered past the end of one request are
+// there for the next Read. If the bound reader is already a large enough
+// bufio.Reader, it is used.
+func (t *TCP) newReader(r io.Reader) *bufio.Reader {
+	size := t.ReadBufferSize
+	if size == 0 {
+		size = defaultReadBufferSize
+	}
+
+	return bufio.NewReaderSize(r, size)
+}
+
+// newWriter returns the buffered writer a connection owns for the writer
+// bound by the ConnHandler, and the bound writer when the buffer writes to
+// it so it can be flushed as well. Without a WriteBufferSize, the bound
+// writer is used as is. If it is already a large enough bufio.Writer, it
+// is used.
+func (t *TCP) newWriter(w io.Writer) (writer io.Writer, next io.Writer) {
+	if t.WriteBufferSize == 0 {
+		return w, nil
+	}
+
+	bw := bufio.NewWriterSize(w, t.WriteBufferSize)
+	if bw == w {
+		return bw, nil
+	}
+
+	return bw, w
+}
+
+// loadHandlers returns the handlers currently in use.
+func (t *TCP) loadHandlers() *handlers {
+	return t.handlers.Load().(*handlers)
+}
+
 // StatsRecv returns the current snapshot of the recv pool stats.
 func (t *TCP) StatsRecv() pool.Stat {
+	t.recvMu.RLock()
+	defer t.recvMu.RUnlock()
+
 	return t.recv.Stats()
 }
 
 // StatsSend returns the current snapshot of the send pool stats.
 func (t *TCP) StatsSend() pool.Stat {
+	t.sendMu.RLock()
+	defer t.sendMu.RUnlock()
+
 	return t.send.Stats()
 }
 
+// AcceptErrors returns a channel that receives the errors returned by
+// accepting connections, except for the errors caused by shutting down.
+// The channel has a small buffer and errors are dropped when it is full,
+// so it is lossy under a burst of errors. The channel is never closed.
+func (t *TCP) AcceptErrors() <-chan error {
+	return t.acceptErrs
+}
+
 // Addr returns the listener's network address. This may be different than the values
 // provided in the configuration, for example if configuration port value is 0.
 func (t *TCP) Addr() net.Addr {
@@ -358,39 +1381,173 @@ func (t *TCP) Addr() net.Addr {
 	return t.listener.Addr()
 }
 
-// join takes a new connection and adds it to the manager.
-func (t *TCP) join(traceID string, conn net.Conn) {
+// Running returns true while the listener is accepting connections. This
+// is between a successful Start and Stop, except when a failed listener
+// could not be re-established.
+func (t *TCP) Running() bool {
+	t.listenerMu.Lock()
+	defer t.listenerMu.Unlock()
+
+	return t.listener != nil && atomic.LoadInt32(&t.shuttingDown) == 0
+}
+
+// Port returns the port the listener is bound to. This is the port assigned
+// by the OS when the configuration port value is 0. Before Start, the
+// configured port is returned.
+func (t *TCP) Port() int {
+	t.listenerMu.Lock()
+	defer t.listenerMu.Unlock()
+
+	if t.listener == nil {
+		return t.port
+	}
+
+	// Listeners that don't have a host and port only have the
+	// configured port.
+	addr := tcpAddr(t.listener.Addr())
+	if addr == nil {
+		return t.port
+	}
+
+	return addr.Port
+}
+
+// join takes a new connection and adds it to the manager, counting it as
+// a TLS or plaintext connection.
+func (t *TCP) join(traceID string, conn net.Conn, isTLS bool) {
 	ipAddress := conn.RemoteAddr().String()
 	cntx := fmt.Sprintf("%s-%s", traceID, ipAddress)
 	t.Event(cntx, "join", "Remote IPAddress[ %s ], Local IPAddress[ %v ]", ipAddress, conn.LocalAddr())
 
+	// A client that reconnects from the same address can beat the
+	// removal of its old connection.
+	if t.DuplicateWait > 0 {
+		t.waitDuplicate(ipAddress)
+	}
+
+	var c *client
 	t.clientsMu.Lock()
 	{
+		// A connection that finished its handshake after the manager
+		// started shutting down would never be dropped.
+		if atomic.LoadInt32(&t.shuttingDown) == 1 {
+			conn.Close()
+
+			t.clientsMu.Unlock()
+			return
+		}
+
 		// If this ipaddress and socket alread exist, we have a problet.
 		if _, ok := t.clients[ipAddress]; ok {
 			err := fmt.Errorf("IP Address already connected [ %s ]", ipAddress)
 			t.Event(traceID, "join", "ERROR : %v", err)
-			conn.Close()
+			t.drop(conn, DropReasonDuplicate)
 
 			t.clientsMu.Unlock()
 			return
 		}
 
 		// Add the new client connection.
-		t.clients[ipAddress] = newClient(cntx, t, conn)
+		c = newClient(cntx, t, conn)
+		c.id = atomic.AddUint64(&t.connIDs, 1)
+		t.clients[ipAddress] = c
+		atomic.AddUint64(&t.acceptedTotal, 1)
+		if isTLS {
+			atomic.AddUint64(&t.acceptedTLS, 1)
+		} else {
+			atomic.AddUint64(&t.acceptedPlain, 1)
+		}
 	}
+	count := len(t.clients)
 	t.clientsMu.Unlock()
+
+	t.event(ConnEvent{Type: Connected, Addr: ipAddress, ID: c.id, Time: c.joined})
+
+	t.checkLeak(traceID, count)
+	t.connCountChanged()
+}
+
+// waitDuplicate waits up to DuplicateWait for a connection with the same
+// address to be removed. Accepting connections waits with it, so the wait
+// should be short.
+func (t *TCP) waitDuplicate(ipAddress string) {
+	deadline := time.Now().Add(t.DuplicateWait)
+
+	for {
+		t.clientsMu.Lock()
+		_, ok := t.clients[ipAddress]
+		t.clientsMu.Unlock()
+
+		if !ok || !time.Now().Before(deadline) || atomic.LoadInt32(&t.shuttingDown) == 1 {
+			return
+		}
+
+		time.Sleep(duplicatePoll)
+	}
+}
+
+// checkLeak reports when the number of connections goes above LeakThreshold,
+// which points to connections that are not being removed. It is reported
+// once each time the number goes above.
+func (t *TCP) checkLeak(traceID string, count int) {
+	if t.LeakThreshold <= 0 || count <= t.LeakThreshold {
+		return
+	}
+
+	if !atomic.CompareAndSwapInt32(&t.leaking, 0, 1) {
+		return
+	}
+
+	t.Event(traceID, "join", "ERROR : Connections Above Leak Threshold : Count[ %d ] Threshold[ %d ]", count, t.LeakThreshold)
+
+	if t.PanicOnLeak {
+		panic(fmt.Sprintf("tcp: %d connections is above LeakThreshold %d", count, t.LeakThreshold))
+	}
+
+	t.Leak(count)
+}
+
+// drop closes a connection that is not going to be added to the manager
+// and reports the reason back to the user.
+func (t *TCP) drop(conn net.Conn, reason string) {
+	conn.Close()
+	t.dropped(reason, conn.RemoteAddr().String())
+}
+
+// dropped counts a dropped connection and reports it to OnDrop.
+func (t *TCP) dropped(reason string, remoteAddr string) {
+	t.dropsMu.Lock()
+	{
+		if t.drops == nil {
+			t.drops = make(map[string]uint64)
+			t.dropRates = make(map[string]*rollingCount)
+		}
+		t.drops[reason]++
+
+		r, ok := t.dropRates[reason]
+		if !ok {
+			r = new(rollingCount)
+			t.dropRates[reason] = r
+		}
+		r.add(time.Now())
+	}
+	t.dropsMu.Unlock()
+
+	t.Drop(reason, remoteAddr)
 }
 
 // remove deletes a connection from the manager.
-func (t *TCP) remove(traceID string, conn net.Conn) {
+func (t *TCP) remove(traceID string, conn net.Conn, reason CloseReason) {
 	ipAddress := conn.RemoteAddr().String()
-	t.Event(traceID, "remove", "IPAddress[ %s ]", ipAddress)
+	t.Event(traceID, "remove", "IPAddress[ %s ] Reason[ %v ]", ipAddress, reason)
 
+	var c *client
 	t.clientsMu.Lock()
 	{
 		// If this ipaddress and socket does not exist, we have a probler.
-		if _, ok := t.clients[ipAddress]; !ok {
+		var ok bool
+		c, ok = t.clients[ipAddress]
+		if !ok {
 			err := fmt.Errorf("IP Address already removed [ %s ]", ipAddress)
 			t.Event(traceID, "remove", "ERROR : %v", err)
 
@@ -398,11 +1555,72 @@ func (t *TCP) remove(traceID string, conn net.Conn) {
 			return
 		}
 
+		// Cancel any processing for this client that is in flight.
+		c.cancel()
+
 		// Remove the client connection from the map.
 		delete(t.clients, ipAddress)
+
+		// A leak is reported again if the number goes back above.
+		if len(t.clients) <= t.LeakThreshold {
+			atomic.StoreInt32(&t.leaking, 0)
+		}
 	}
 	t.clientsMu.Unlock()
 
-	// Close the connection for safe keeping.
-	conn.Close()
+	// Nothing is flushed once the connection is gone, except for what
+	// is owed to the new owner of a hijacked connection.
+	c.stopFlush(reason == CloseHijacked)
+
+	// Close the connection for safe keeping. A hijacked connection
+	// belongs to the caller of Hijack.
+	if reason != CloseHijacked {
+		conn.Close()
+	}
+
+	t.Disconnect(ipAddress, reason)
+	t.event(ConnEvent{Type: Disconnected, Addr: ipAddress, ID: c.id, Time: time.Now(), Reason: reason})
+	t.connCountChanged()
+}
+
+// connCountChanged schedules a report of the connection count, unless one
+// is already scheduled. Changes made until the report runs are collected
+// into it.
+func (t *TCP) connCountChanged() {
+	if t.OnConnCountChange == nil {
+		return
+	}
+
+	t.countMu.Lock()
+	defer t.countMu.Unlock()
+
+	if t.countPending {
+		return
+	}
+	t.countPending = true
+
+	debounce := t.ConnCountDebounce
+	if debounce == 0 {
+		debounce = defaultConnCountDebounce
+	}
+
+	time.AfterFunc(debounce, t.reportConnCount)
+}
+
+// reportConnCount calls OnConnCountChange with the connection count if it
+// is different from the count last reported.
+func (t *TCP) reportConnCount() {
+	t.clientsMu.Lock()
+	count := len(t.clients)
+	t.clientsMu.Unlock()
+
+	t.countMu.Lock()
+	t.countPending = false
+	changed := count != t.countLast
+	t.countLast = count
+	t.countMu.Unlock()
+
+	if changed {
+		t.OnConnCountChange(count)
+	}
 }
@@ -1,6 +1,9 @@
 package tcp
 
 import (
+	"context"
+	"crypto/tls"
+	"net"
 	"time"
 
 	"github.com/ardanlabs/kit/pool"
@@ -22,10 +25,174 @@ type OptIntPool struct {
 	SendMaxPoolSize func() int // Max number of routines the send pool can have.
 }
 
+// OptPoolBudget declares fields for the user to provide a total number of
+// routines that is split between internally configured pools.
+type OptPoolBudget struct {
+	PoolBudgetMin int     // Min number of routines across both pools, each pool has at least one.
+	PoolBudgetMax int     // Max number of routines across both pools.
+	PoolRecvShare float64 // Share of the budget given to the recv pool, 0 uses 0.5.
+}
+
 // OptRateLimit declares fields for the user to provide configuration
 // for connection rate limit.
 type OptRateLimit struct {
-	RateLimit func() time.Duration // Connection rate limit per single connection.
+	RateLimit      func() time.Duration // Connection rate limit per single connection.
+	RateLimitBurst int                  // Connections accepted at once before the rate limit applies, 0 is 1.
+	WriteRateLimit int                  // Bytes per second written to each connection, 0 is unlimited.
+}
+
+// OptListen declares fields for the user to provide configuration
+// for the listen socket.
+type OptListen struct {
+	ListenBacklog int  // Size of the listen backlog, 0 uses the OS default.
+	FastOpen      bool // Enable TCP Fast Open, only supported on linux.
+	FastOpenQueue int  // Number of pending fast open requests, 0 uses 256.
+	Linger        *int // Seconds a close waits to send unsent data, 0 resets the connection, nil uses the OS default.
+}
+
+// OptTLS declares fields for the user to provide configuration
+// for accepting TLS connections.
+type OptTLS struct {
+	TLSConfig               *tls.Config       // Accept TLS connections with this configuration.
+	AllowSNI                func(string) bool // Reports if the server name sent by the client is allowed.
+	HandshakeTimeout        time.Duration     // Time allowed for the TLS handshake before the connection is added, 0 uses the default.
+	MaxConcurrentHandshakes int               // Most TLS handshakes run at once, 0 is no limit.
+}
+
+// OptBuffer declares fields for the user to provide configuration
+// for the buffers owned by each connection.
+type OptBuffer struct {
+	ReadBufferSize  int           // Size of the read buffer for each connection, 0 uses 4096.
+	WriteBufferSize int           // Size of the write buffer for each connection, 0 uses the writer from Bind as is.
+	FlushInterval   time.Duration // Longest a written response waits to be flushed, 0 flushes after each response.
+}
+
+// OptTimeout declares fields for the user to provide configuration
+// for write timeouts.
+type OptTimeout struct {
+	WriteTimeout         time.Duration // Time allowed to write a whole response, 0 is no limit.
+	WriteProgressTimeout time.Duration // Time allowed without progress while writing a response, 0 is no limit.
+	FirstByteTimeout     time.Duration // Time allowed from the connection being added until the first request is read, 0 is no limit.
+	MaxReadDuration      time.Duration // Time allowed to read a request once its first byte arrives, 0 is no limit.
+	DuplicateWait        time.Duration // Time allowed for a connection with the same remote address to be removed before a new one is dropped, 0 is no wait.
+}
+
+// OptAdmit declares fields for the user to decide if each connection
+// is accepted.
+type OptAdmit struct {
+	AdmitFunc    func(remoteAddr string) bool // Reports if the connection is accepted, nil accepts all.
+	LoadShedFunc func() bool                  // Reports if the host is overloaded, connections are dropped while it is.
+
+	AcceptFilters []AcceptFilter // Checked in order after the built in checks, the first to deny drops the connection.
+}
+
+// OptProbe declares fields for the user to answer health probes, like
+// the ones sent by a load balancer, without adding a client connection.
+type OptProbe struct {
+	ProbeFunc    func(conn net.Conn) bool // Reports if the connection was a probe it handled, the connection is then closed.
+	ProbeTimeout time.Duration            // Time ProbeFunc has to read from the connection, 0 uses the default.
+}
+
+// OptCIDR declares fields for the user to accept or drop connections
+// by the address range they come from. The lists can be replaced while
+// running with SetAllowCIDRs and SetDenyCIDRs.
+type OptCIDR struct {
+	AllowCIDRs      []string // Accept connections only from these ranges, empty accepts all.
+	DenyCIDRs       []string // Drop connections from these ranges, checked before AllowCIDRs.
+	DropDeniedConns bool     // Drop connections already accepted that a new list no longer allows.
+}
+
+// OptMiddleware declares fields for the user to apply shared behavior
+// to every request and response.
+type OptMiddleware struct {
+	RequestMiddleware  func(r *Request) error // Called before each request is processed, an error stops it.
+	ResponseMiddleware func(r *Response)      // Called before each response is written, can change it in place.
+}
+
+// OptWrap declares fields for the user to wrap each accepted connection.
+type OptWrap struct {
+	WrapConn func(conn net.Conn) net.Conn // Returns the connection used from then on, nil leaves it unchanged.
+}
+
+// OptBackpressure declares fields for the user to provide configuration
+// for pausing reads when the recv pool is saturated.
+type OptBackpressure struct {
+	MaxRecvPending     int   // Pause reading while this many requests are waiting on the recv pool.
+	MaxBufferedBytes   int64 // Pause reading and reject responses while this many bytes are buffered.
+	MaxInFlightPerConn int   // Pause reading a connection while this many of its requests are unprocessed.
+
+	MaxPendingBytesPerConn int64 // Reject responses that would leave more than this many bytes waiting to be written to a connection.
+	MaxPendingResponses    int64 // Reject responses while this many are waiting to be written across all connections.
+}
+
+// OptWriteErrors declares fields for the user to drop connections that
+// keep failing to be written to.
+type OptWriteErrors struct {
+	MaxWriteErrors int // Drop a connection after this many failed writes in a row, 0 never drops.
+}
+
+// OptDrop declares fields for the user to provide a handler that is
+// called every time an accepted connection is dropped.
+type OptDrop struct {
+	OnDrop func(reason string, remoteAddr string) // Called with the reason the connection was dropped.
+}
+
+// OptDisconnect declares fields for the user to provide a handler that
+// is called every time a client connection is removed.
+type OptDisconnect struct {
+	OnDisconnect func(remoteAddr string, reason CloseReason) // Called with the reason the connection was removed.
+}
+
+// OptStop declares fields for the user to provide configuration
+// for shutting down.
+type OptStop struct {
+	StopGrace time.Duration // Time Stop waits between closing the listener and dropping connections.
+}
+
+// OptAcceptExit declares fields for the user to provide a handler that
+// is called when the accept routine terminates.
+type OptAcceptExit struct {
+	OnAcceptExit func(err error) // Called once with nil on shutdown or the error that stopped the routine.
+}
+
+// OptDrain declares fields for the user to provide a handler that is
+// called during StopGraceful as each connection finishes draining.
+type OptDrain struct {
+	OnDrained    func(remoteAddr string) // Called when all pending responses for the connection are written.
+	DrainMessage []byte                  // Sent to each connection when draining starts, nil sends nothing.
+}
+
+// OptSpan declares fields for the user to provide a hook that starts
+// tracing spans.
+type OptSpan struct {
+	StartSpan func(ctx context.Context, name string) (context.Context, func()) // Starts a span, the returned function ends it.
+}
+
+// OptLabels declares fields for the user to provide labels that identify
+// this TCP value in the stats.
+type OptLabels struct {
+	Labels map[string]string // Labels carried into each stats snapshot, "name" defaults to the name.
+}
+
+// OptConnCount declares fields for the user to provide a handler that is
+// called when the number of connections changes.
+type OptConnCount struct {
+	OnConnCountChange func(count int) // Called with the number of connections once it settles.
+	ConnCountDebounce time.Duration   // Time changes are collected for before the handler is called, 0 uses 100ms.
+}
+
+// OptLeak declares fields for the user to detect connections that are
+// not being removed.
+type OptLeak struct {
+	LeakThreshold int             // Number of connections that indicates a leak, 0 disables the check. Connections are never refused.
+	OnLeak        func(count int) // Called each time the number of connections goes above LeakThreshold.
+	PanicOnLeak   bool            // Panic instead of calling OnLeak, for finding leaks while debugging.
+}
+
+// OptValidate declares fields for the user to provide validation of
+// their own configuration.
+type OptValidate struct {
+	ValidateFunc func() error // Called at the end of Validate, its error is returned as is.
 }
 
 // OptEvent defines an handler used to provide events.
@@ -48,16 +215,40 @@ type Config struct {
 
 	// Decide if you want to pass in your own work pool for configuration options
 	// for the tcp value to create its own. Pass in your own pool if you want to
-	// share a single pool across multiple tcp values.
+	// share a single pool across multiple tcp values. A pool budget is an
+	// alternative to the four pool sizes, for when only the total number of
+	// routines and how it is split between the pools matter.
 
 	OptUserPool
 	OptIntPool
+	OptPoolBudget
 
 	// *************************************************************************
 	// ** Not Required, optional                                              **
 	// *************************************************************************
 
+	OptListen
+	OptTLS
+	OptBuffer
 	OptRateLimit
+	OptTimeout
+	OptAdmit
+	OptProbe
+	OptCIDR
+	OptWrap
+	OptMiddleware
+	OptBackpressure
+	OptWriteErrors
+	OptDrop
+	OptDisconnect
+	OptStop
+	OptDrain
+	OptAcceptExit
+	OptLabels
+	OptSpan
+	OptConnCount
+	OptLeak
+	OptValidate
 	OptEvent
 }
 
@@ -87,6 +278,114 @@ func (cfg *Config) Validate() error {
 		return ErrInvalidPoolConfiguration
 	}
 
+	if cfg.PoolBudgetMax != 0 || cfg.PoolBudgetMin != 0 || cfg.PoolRecvShare != 0 {
+		if cfg.RecvPool != nil || cfg.RecvMinPoolSize != nil || cfg.RecvMaxPoolSize != nil || cfg.SendMinPoolSize != nil || cfg.SendMaxPoolSize != nil {
+			return ErrInvalidPoolConfiguration
+		}
+
+		if cfg.PoolBudgetMax < 2 || cfg.PoolBudgetMin < 0 || cfg.PoolBudgetMin > cfg.PoolBudgetMax {
+			return ErrInvalidPoolBudget
+		}
+
+		if cfg.PoolRecvShare < 0 || cfg.PoolRecvShare >= 1 {
+			return ErrInvalidPoolBudget
+		}
+	}
+
+	if cfg.ListenBacklog < 0 {
+		return ErrInvalidListenBacklog
+	}
+
+	if cfg.Linger != nil && *cfg.Linger < 0 {
+		return ErrInvalidLinger
+	}
+
+	if cfg.FastOpenQueue < 0 {
+		return ErrInvalidFastOpenQueue
+	}
+
+	if cfg.FastOpen && !fastOpenSupported {
+		return ErrFastOpenNotSupported
+	}
+
+	if cfg.ReadBufferSize < 0 {
+		return ErrInvalidReadBufferSize
+	}
+
+	if cfg.WriteBufferSize < 0 {
+		return ErrInvalidWriteBufferSize
+	}
+
+	if cfg.FlushInterval < 0 {
+		return ErrInvalidFlushInterval
+	}
+
+	if cfg.RateLimitBurst < 0 {
+		return ErrInvalidRateLimitBurst
+	}
+
+	if cfg.WriteRateLimit < 0 {
+		return ErrInvalidWriteRateLimit
+	}
+
+	if cfg.WriteTimeout < 0 || cfg.WriteProgressTimeout < 0 {
+		return ErrInvalidWriteTimeout
+	}
+
+	if cfg.FirstByteTimeout < 0 {
+		return ErrInvalidFirstByteTimeout
+	}
+
+	if cfg.MaxReadDuration < 0 {
+		return ErrInvalidMaxReadDuration
+	}
+
+	if cfg.DuplicateWait < 0 {
+		return ErrInvalidDuplicateWait
+	}
+
+	if cfg.MaxWriteErrors < 0 {
+		return ErrInvalidMaxWriteErrors
+	}
+
+	if cfg.ConnCountDebounce < 0 {
+		return ErrInvalidConnCountDebounce
+	}
+
+	if cfg.LeakThreshold < 0 {
+		return ErrInvalidLeakThreshold
+	}
+
+	if _, err := parseCIDRs(cfg.AllowCIDRs); err != nil {
+		return err
+	}
+
+	if _, err := parseCIDRs(cfg.DenyCIDRs); err != nil {
+		return err
+	}
+
+	if cfg.AllowSNI != nil && cfg.TLSConfig == nil {
+		return ErrInvalidTLSConfiguration
+	}
+
+	if cfg.ProbeTimeout < 0 {
+		return ErrInvalidProbeTimeout
+	}
+
+	if cfg.HandshakeTimeout < 0 {
+		return ErrInvalidHandshakeTimeout
+	}
+
+	if cfg.MaxConcurrentHandshakes < 0 {
+		return ErrInvalidMaxHandshakes
+	}
+
+	// Let the user validate anything else once the built in
+	// checks have passed.
+	if cfg.ValidateFunc != nil {
+		return cfg.ValidateFunc()
+	}
+
 	return nil
 }
 
@@ -96,3 +395,38 @@ func (cfg *Config) Event(traceID string, event string, format string, a ...inter
 		cfg.OptEvent.Event(traceID, event, format, a...)
 	}
 }
+
+// Drained reports a connection that has no pending responses left.
+func (cfg *Config) Drained(remoteAddr string) {
+	if cfg.OptDrain.OnDrained != nil {
+		cfg.OptDrain.OnDrained(remoteAddr)
+	}
+}
+
+// AcceptExit reports the accept routine has terminated.
+func (cfg *Config) AcceptExit(err error) {
+	if cfg.OptAcceptExit.OnAcceptExit != nil {
+		cfg.OptAcceptExit.OnAcceptExit(err)
+	}
+}
+
+// Disconnect reports a client connection that has been removed.
+func (cfg *Config) Disconnect(remoteAddr string, reason CloseReason) {
+	if cfg.OptDisconnect.OnDisconnect != nil {
+		cfg.OptDisconnect.OnDisconnect(remoteAddr, reason)
+	}
+}
+
+// Leak reports the number of connections has gone above LeakThreshold.
+func (cfg *Config) Leak(count int) {
+	if cfg.OptLeak.OnLeak != nil {
+		cfg.OptLeak.OnLeak(count)
+	}
+}
+
+// Drop reports a dropped connection back to the user.
+func (cfg *Config) Drop(reason string, remoteAddr string) {
+	if cfg.OptDrop.OnDrop != nil {
+		cfg.OptDrop.OnDrop(reason, remoteAddr)
+	}
+}
@@ -2,7 +2,17 @@ package tcp_test
 
 import (
 	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
 	"io"
+	"math/big"
 	"net"
 	"sync/atomic"
 	"time"
@@ -65,3 +75,417 @@ func (tcpRespHandler) Write(traceID string, r *tcp.Response, writer io.Writer) {
 	bufWriter.WriteString(string(r.Data))
 	bufWriter.Flush()
 }
+
+//==============================================================================
+
+type tcpSwapRespHandler struct{}
+
+// Write ignores the response data and writes a fixed string.
+func (tcpSwapRespHandler) Write(traceID string, r *tcp.Response, writer io.Writer) {
+	bufWriter := writer.(*bufio.Writer)
+	bufWriter.WriteString("SWAPPED\n")
+	bufWriter.Flush()
+}
+
+//==============================================================================
+
+// tcpCtxReqHandler processes client messages with a context.
+type tcpCtxReqHandler struct {
+	tcpReqHandler
+
+	started   chan struct{}
+	cancelled chan struct{}
+}
+
+// ProcessContext blocks until the context for the connection is cancelled.
+func (h tcpCtxReqHandler) ProcessContext(ctx context.Context, traceID string, r *tcp.Request) {
+	close(h.started)
+	<-ctx.Done()
+	close(h.cancelled)
+}
+
+//==============================================================================
+
+// tempError is a temporary error like the ones from the net package.
+type tempError struct{}
+
+func (tempError) Error() string   { return "temporary error" }
+func (tempError) Temporary() bool { return true }
+
+// tcpTempReqHandler returns a temporary error from the first read.
+type tcpTempReqHandler struct {
+	tcpReqHandler
+
+	reads *int32
+}
+
+// Read returns a temporary error the first time it is called.
+func (h tcpTempReqHandler) Read(traceID string, ipAddress string, reader io.Reader) ([]byte, int, error) {
+	if atomic.AddInt32(h.reads, 1) == 1 {
+		return nil, 0, tempError{}
+	}
+
+	return h.tcpReqHandler.Read(traceID, ipAddress, reader)
+}
+
+//==============================================================================
+
+// tcpRawConnHandler binds the connection without a buffered reader.
+type tcpRawConnHandler struct{}
+
+// Bind is called to init to reader and writer.
+func (tcpRawConnHandler) Bind(traceID string, conn net.Conn) (io.Reader, io.Writer) {
+	return conn, bufio.NewWriter(conn)
+}
+
+//==============================================================================
+
+// tcpEchoReqHandler echoes each request back to the client.
+type tcpEchoReqHandler struct {
+	tcpReqHandler
+}
+
+// Process writes the request data back to the client.
+func (tcpEchoReqHandler) Process(traceID string, r *tcp.Request) {
+	r.TCP.Do(traceID, r.NewResponse(r.Data))
+}
+
+//==============================================================================
+
+// tcpSizeReqHandler responds to each request with the configured number
+// of bytes and reports the write error.
+type tcpSizeReqHandler struct {
+	tcpReqHandler
+
+	size int
+	errs chan error
+}
+
+// Process sends the response and reports the error once it is written.
+func (h tcpSizeReqHandler) Process(traceID string, r *tcp.Request) {
+	resp := r.NewResponse(make([]byte, h.size))
+	resp.Complete = func(rsp *tcp.Response) {
+		h.errs <- rsp.Err
+	}
+
+	r.TCP.Do(traceID, resp)
+}
+
+//==============================================================================
+
+// tcpStartTLSReqHandler upgrades the connection to TLS when asked.
+type tcpStartTLSReqHandler struct {
+	tcpReqHandler
+
+	cfg *tls.Config
+}
+
+// Process upgrades the connection for a STARTTLS request and handles
+// everything else like tcpReqHandler.
+func (h tcpStartTLSReqHandler) Process(traceID string, r *tcp.Request) {
+	if string(r.Data) != "STARTTLS\n" {
+		h.tcpReqHandler.Process(traceID, r)
+		return
+	}
+
+	r.UpgradeHandlers(tcpTLSConnHandler{cfg: h.cfg}, nil, nil)
+	r.TCP.Do(traceID, r.NewResponse([]byte("OK\n")))
+}
+
+// tcpTLSConnHandler binds the server side of a TLS connection.
+type tcpTLSConnHandler struct {
+	cfg *tls.Config
+}
+
+// Bind is called to init to reader and writer.
+func (h tcpTLSConnHandler) Bind(traceID string, conn net.Conn) (io.Reader, io.Writer) {
+	tc := tls.Server(conn, h.cfg)
+	return bufio.NewReader(tc), bufio.NewWriter(tc)
+}
+
+//==============================================================================
+
+// newCertificate creates a self-signed certificate for the specified host.
+func newCertificate(host string) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	tmpl := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: host},
+		DNSNames:     []string{host},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &tmpl, &tmpl, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
+
+//==============================================================================
+
+// tcpCountConn counts the bytes read and written on a connection.
+type tcpCountConn struct {
+	net.Conn
+	read    int64
+	written int64
+	writes  int64
+}
+
+// Read implements the net.Conn interface.
+func (c *tcpCountConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	atomic.AddInt64(&c.read, int64(n))
+	return n, err
+}
+
+// Write implements the net.Conn interface.
+func (c *tcpCountConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	atomic.AddInt64(&c.written, int64(n))
+	atomic.AddInt64(&c.writes, 1)
+	return n, err
+}
+
+//==============================================================================
+
+// tcpNamedAddr is an address that isn't a host and port.
+type tcpNamedAddr string
+
+// Network implements the net.Addr interface.
+func (a tcpNamedAddr) Network() string { return "named" }
+
+// String implements the net.Addr interface.
+func (a tcpNamedAddr) String() string { return string(a) }
+
+// tcpNamedConn reports a named remote address for a connection.
+type tcpNamedConn struct {
+	net.Conn
+	name tcpNamedAddr
+}
+
+// RemoteAddr implements the net.Conn interface.
+func (c tcpNamedConn) RemoteAddr() net.Addr {
+	return c.name
+}
+
+//==============================================================================
+
+// tcpHoldReqHandler holds each request until it is released.
+type tcpHoldReqHandler struct {
+	tcpReqHandler
+
+	started chan uint64
+	release chan struct{}
+}
+
+// Process reports the request has started and waits to be released.
+func (h tcpHoldReqHandler) Process(traceID string, r *tcp.Request) {
+	h.started <- r.Seq
+	<-h.release
+	h.tcpReqHandler.Process(traceID, r)
+}
+
+//==============================================================================
+
+// tcpConnWriterHandler binds the connection itself as the writer.
+type tcpConnWriterHandler struct{}
+
+// Bind is called to init to reader and writer.
+func (tcpConnWriterHandler) Bind(traceID string, conn net.Conn) (io.Reader, io.Writer) {
+	return conn, conn
+}
+
+// tcpBuffersRespHandler writes the data and buffers of the response.
+type tcpBuffersRespHandler struct{}
+
+// Write is provided the user-defined writer and the data to write.
+func (tcpBuffersRespHandler) Write(traceID string, r *tcp.Response, writer io.Writer) {
+	if _, err := r.WriteTo(writer); err != nil {
+		r.Err = err
+	}
+}
+
+//==============================================================================
+
+// tcpSeqReqHandler answers each request with its sequence number.
+type tcpSeqReqHandler struct {
+	tcpReqHandler
+}
+
+// Process is used to handle the processing of the message.
+func (tcpSeqReqHandler) Process(traceID string, r *tcp.Request) {
+	r.TCP.Do(traceID, r.NewResponse([]byte(fmt.Sprintf("SEQ %d\n", r.Seq))))
+}
+
+// tcpIdle reports if the connection has no responses waiting to be written.
+func tcpIdle(u *tcp.TCP, conn net.Conn) bool {
+	for _, info := range u.Connections() {
+		if info.Addr == conn.LocalAddr().String() {
+			return info.Pending == 0
+		}
+	}
+	return false
+}
+
+// tcpChattyRespHandler writes the response a byte at a time.
+type tcpChattyRespHandler struct{}
+
+// Write is provided the user-defined writer and the data to write.
+func (tcpChattyRespHandler) Write(traceID string, r *tcp.Response, writer io.Writer) {
+	for i := range r.Data {
+		if _, err := writer.Write(r.Data[i : i+1]); err != nil {
+			r.Err = err
+			return
+		}
+	}
+}
+
+// tcpFailRespHandler fails to write responses with the data "FAIL\n".
+type tcpFailRespHandler struct {
+	tcpRespHandler
+}
+
+// Write is provided the user-defined writer and the data to write.
+func (h tcpFailRespHandler) Write(traceID string, r *tcp.Response, writer io.Writer) {
+	if string(r.Data) == "FAIL\n" {
+		r.Err = errors.New("write failed")
+		return
+	}
+	h.tcpRespHandler.Write(traceID, r, writer)
+}
+
+// tcpShortRespHandler fails every write with io.ErrShortWrite.
+type tcpShortRespHandler struct{}
+
+// Write is provided the user-defined writer and the data to write.
+func (tcpShortRespHandler) Write(traceID string, r *tcp.Response, writer io.Writer) {
+	r.Err = io.ErrShortWrite
+}
+
+//==============================================================================
+
+// tcpConnReqHandler reports the connection each request was read from.
+type tcpConnReqHandler struct {
+	tcpReqHandler
+	conns chan net.Conn
+}
+
+// Process is used to handle the processing of the message.
+func (h tcpConnReqHandler) Process(traceID string, r *tcp.Request) {
+	h.conns <- r.Conn
+	h.tcpReqHandler.Process(traceID, r)
+}
+
+//==============================================================================
+
+// tcpUnwrapConnHandler reports the connection Unwrap returns for the
+// connection given to Bind.
+type tcpUnwrapConnHandler struct {
+	tcpConnHandler
+	conns chan net.Conn
+}
+
+// Bind is called to init to reader and writer.
+func (h tcpUnwrapConnHandler) Bind(traceID string, conn net.Conn) (io.Reader, io.Writer) {
+	var unwrapped net.Conn
+	if u, ok := conn.(interface{ Unwrap() net.Conn }); ok {
+		unwrapped = u.Unwrap()
+	}
+	h.conns <- unwrapped
+
+	return h.tcpConnHandler.Bind(traceID, conn)
+}
+
+//==============================================================================
+
+// tcpCountBindHandler counts the connections bound.
+type tcpCountBindHandler struct {
+	tcpConnHandler
+	binds *int64
+}
+
+// Bind is called to init to reader and writer.
+func (h tcpCountBindHandler) Bind(traceID string, conn net.Conn) (io.Reader, io.Writer) {
+	atomic.AddInt64(h.binds, 1)
+	return h.tcpConnHandler.Bind(traceID, conn)
+}
+
+//==============================================================================
+
+// errWriteRefused is returned by every write to a tcpFailWriteConn.
+var errWriteRefused = errors.New("write refused")
+
+// tcpFailWriteConn fails every write to the connection.
+type tcpFailWriteConn struct {
+	net.Conn
+}
+
+// Write implements the net.Conn interface.
+func (tcpFailWriteConn) Write(b []byte) (int, error) {
+	return 0, errWriteRefused
+}
+
+// tcpUncheckedRespHandler writes the data without checking the error.
+type tcpUncheckedRespHandler struct{}
+
+// Write is provided the user-defined writer and the data to write.
+func (tcpUncheckedRespHandler) Write(traceID string, r *tcp.Response, writer io.Writer) {
+	writer.Write(r.Data)
+}
+
+//==============================================================================
+
+// tcpNilListener returns no connection and no error from Accept a number
+// of times before accepting connections from the listener it wraps.
+type tcpNilListener struct {
+	net.Listener
+	nils  int32
+	calls int32
+}
+
+// Accept implements the net.Listener interface.
+func (l *tcpNilListener) Accept() (net.Conn, error) {
+	if atomic.AddInt32(&l.calls, 1) <= l.nils {
+		return nil, nil
+	}
+	return l.Listener.Accept()
+}
+
+//==============================================================================
+
+// tcpHijackReqHandler hijacks the connection when it reads "HIJACK" and
+// then echoes everything sent on the raw connection.
+type tcpHijackReqHandler struct {
+	tcpReqHandler
+	errs chan error
+}
+
+// Process implements the tcp.ReqHandler interface.
+func (h tcpHijackReqHandler) Process(traceID string, r *tcp.Request) {
+	if string(r.Data) != "HIJACK\n" {
+		h.tcpReqHandler.Process(traceID, r)
+		return
+	}
+
+	conn, err := r.Hijack()
+	h.errs <- err
+	if err != nil {
+		return
+	}
+
+	go func() {
+		defer conn.Close()
+
+		conn.Write([]byte("HIJACKED\n"))
+		io.Copy(conn, conn)
+	}()
+}
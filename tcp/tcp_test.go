@@ -2,7 +2,18 @@ package tcp_test
 
 import (
 	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"net"
+	"runtime"
+	"strconv"
+	"strings"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -126,6 +137,11 @@ func TestTCPAddr(t *testing.T) {
 		}
 		t.Log("\tShould be able to create a new TCP listener.", tests.Success)
 
+		if u.Running() {
+			t.Fatal("\tShould not be running before Start.", tests.Failed)
+		}
+		t.Log("\tShould not be running before Start.", tests.Success)
+
 		// Addr should be nil before Start.
 		if addr := u.Addr(); addr != nil {
 			t.Fatalf("\tAddr() should be nil before Start; Addr() = %q. %s", addr, tests.Failed)
@@ -138,6 +154,11 @@ func TestTCPAddr(t *testing.T) {
 		}
 		defer u.Stop("traceID")
 
+		if !u.Running() {
+			t.Fatal("\tShould be running after Start.", tests.Failed)
+		}
+		t.Log("\tShould be running after Start.", tests.Success)
+
 		// Addr should be non-nil after Start.
 		addr := u.Addr()
 		if addr == nil {
@@ -154,6 +175,11 @@ func TestTCPAddr(t *testing.T) {
 			t.Fatalf("\tAddr port should not be %q. %s", port, tests.Failed)
 		}
 		t.Logf("\tAddr() should be not be 0 after Start (port = %q). %s", port, tests.Success)
+
+		if strconv.Itoa(u.Port()) != port {
+			t.Fatalf("\tPort() should match the Addr port; Port() = %d. %s", u.Port(), tests.Failed)
+		}
+		t.Log("\tPort() should match the Addr port.", tests.Success)
 	}
 }
 
@@ -184,6 +210,9 @@ func TestDropConnections(t *testing.T) {
 			t.Fatal("\tShould be able to create a work pool for the send.", tests.Failed, err)
 		}
 
+		// Capture the reason for each dropped connection.
+		reasons := make(chan string, 1)
+
 		// Create a configuration.
 		cfg := tcp.Config{
 			NetType:     "tcp4",
@@ -196,6 +225,12 @@ func TestDropConnections(t *testing.T) {
 				RecvPool: recv,
 				SendPool: send,
 			},
+
+			OptDrop: tcp.OptDrop{
+				OnDrop: func(reason string, remoteAddr string) {
+					reasons <- reason
+				},
+			},
 		}
 
 		// Create a new TCP value.
@@ -230,6 +265,17 @@ func TestDropConnections(t *testing.T) {
 			t.Fatal("\tShould not be able to read the response from the connection.", tests.Failed, err)
 		}
 		t.Log("\tShould not be able to read the response from the connection.", tests.Success)
+
+		select {
+		case reason := <-reasons:
+			if reason != tcp.DropReasonDropConns {
+				t.Fatal("\tShould report the drop connections reason.", tests.Failed, reason)
+			}
+			t.Log("\tShould report the drop connections reason.", tests.Success)
+
+		case <-time.After(time.Second):
+			t.Fatal("\tShould report the drop connections reason.", tests.Failed)
+		}
 	}
 }
 
@@ -375,3 +421,7980 @@ func TestRateLimit(t *testing.T) {
 		// the test to fail due to the limit.
 	}
 }
+
+// TestStopGraceful tests we can observe the progress of a graceful shutdown.
+func TestStopGraceful(t *testing.T) {
+	tests.ResetLog()
+	defer tests.DisplayLog()
+
+	t.Log("Given the need to gracefully shutdown and observe the progress.")
+	{
+		// Create a configuration.
+		cfg := tcp.Config{
+			NetType: "tcp4",
+			Addr:    ":0",
+
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  tcpReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptIntPool: tcp.OptIntPool{
+				RecvMinPoolSize: func() int { return 2 },
+				RecvMaxPoolSize: func() int { return 1000 },
+				SendMinPoolSize: func() int { return 2 },
+				SendMaxPoolSize: func() int { return 1000 },
+			},
+		}
+
+		// Create a new TCP value.
+		u, err := tcp.New("traceID", "TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to create a new TCP listener.", tests.Success)
+
+		// Start accepting client data.
+		if err := u.Start("traceID"); err != nil {
+			t.Fatal("\tShould be able to start the TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to start the TCP listener.", tests.Success)
+
+		// Let's connect back and send a TCP package
+		conn, err := net.Dial("tcp4", u.Addr().String())
+		if err != nil {
+			t.Fatal("\tShould be able to dial a new TCP connection.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to dial a new TCP connection.", tests.Success)
+
+		defer conn.Close()
+
+		bufReader := bufio.NewReader(conn)
+		if _, err := conn.Write([]byte("Hello\n")); err != nil {
+			t.Fatal("\tShould be able to send data to the connection.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to send data to the connection.", tests.Success)
+
+		if _, err := bufReader.ReadString('\n'); err != nil {
+			t.Fatal("\tShould be able to read the response from the connection.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to read the response from the connection.", tests.Success)
+
+		drained := make(chan string, 1)
+		u.OnDrained = func(remoteAddr string) {
+			drained <- remoteAddr
+		}
+
+		u.DrainMessage = []byte("DRAINING\n")
+
+		progress := make(chan int, 100)
+		if err := u.StopGraceful("traceID", time.Second, progress); err != nil {
+			t.Fatal("\tShould be able to gracefully stop the TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to gracefully stop the TCP listener.", tests.Success)
+
+		if response, err := bufReader.ReadString('\n'); err != nil || response != "DRAINING\n" {
+			t.Fatal("\tShould receive the drain message.", tests.Failed, response, err)
+		}
+		t.Log("\tShould receive the drain message.", tests.Success)
+
+		last := -1
+		for remaining := range progress {
+			last = remaining
+		}
+
+		if last != 0 {
+			t.Fatal("\tShould report no connections remaining.", tests.Failed, last)
+		}
+		t.Log("\tShould report no connections remaining.", tests.Success)
+
+		select {
+		case addr := <-drained:
+			if addr != conn.LocalAddr().String() {
+				t.Fatal("\tShould report the connection as drained.", tests.Failed, addr)
+			}
+			t.Log("\tShould report the connection as drained.", tests.Success)
+		default:
+			t.Fatal("\tShould report the connection as drained.", tests.Failed)
+		}
+	}
+}
+
+// TestRelisten tests the listener is re-established after a non temporary
+// accept error.
+func TestRelisten(t *testing.T) {
+	tests.ResetLog()
+	defer tests.DisplayLog()
+
+	t.Log("Given the need to survive a failed listener.")
+	{
+		// Create a configuration.
+		cfg := tcp.Config{
+			NetType: "tcp4",
+			Addr:    ":0",
+
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  tcpReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptIntPool: tcp.OptIntPool{
+				RecvMinPoolSize: func() int { return 2 },
+				RecvMaxPoolSize: func() int { return 1000 },
+				SendMinPoolSize: func() int { return 2 },
+				SendMaxPoolSize: func() int { return 1000 },
+			},
+		}
+
+		// Create a new TCP value.
+		u, err := tcp.New("traceID", "TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to create a new TCP listener.", tests.Success)
+
+		// Start accepting client data.
+		if err := u.Start("traceID"); err != nil {
+			t.Fatal("\tShould be able to start the TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to start the TCP listener.", tests.Success)
+
+		addr := u.Addr().String()
+
+		// Force a non temporary accept error.
+		u.CloseListener()
+		t.Log("\tShould be able to close the listener from under the accept routine.", tests.Success)
+
+		// Keep trying until the listener is bound again.
+		var conn net.Conn
+		for i := 0; i < 20; i++ {
+			if conn, err = net.Dial("tcp4", addr); err == nil {
+				break
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		if err != nil {
+			t.Fatal("\tShould be able to dial the re-established listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to dial the re-established listener.", tests.Success)
+
+		defer conn.Close()
+
+		bufReader := bufio.NewReader(conn)
+		if _, err := conn.Write([]byte("Hello\n")); err != nil {
+			t.Fatal("\tShould be able to send data to the connection.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to send data to the connection.", tests.Success)
+
+		if _, err := bufReader.ReadString('\n'); err != nil {
+			t.Fatal("\tShould be able to read the response from the connection.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to read the response from the connection.", tests.Success)
+
+		if err := u.Stop("traceID"); err != nil {
+			t.Fatal("\tShould be able to stop the TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to stop the TCP listener.", tests.Success)
+	}
+}
+
+// TestDoAfterStop tests work can't be submitted after Stop.
+func TestDoAfterStop(t *testing.T) {
+	tests.ResetLog()
+	defer tests.DisplayLog()
+
+	t.Log("Given the need to reject work after the listener is stopped.")
+	{
+		recvCfg := pool.Config{
+			MinRoutines: func() int { return 2 },
+			MaxRoutines: func() int { return 1000 },
+		}
+
+		recv, err := pool.New("traceID", "Test-Recv", recvCfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a work pool for the recv.", tests.Failed, err)
+		}
+		defer recv.Shutdown("traceID")
+
+		sendCfg := pool.Config{
+			MinRoutines: func() int { return 2 },
+			MaxRoutines: func() int { return 1000 },
+		}
+
+		send, err := pool.New("traceID", "Test-Send", sendCfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a work pool for the send.", tests.Failed, err)
+		}
+		defer send.Shutdown("traceID")
+
+		// Create a configuration.
+		cfg := tcp.Config{
+			NetType:     "tcp4",
+			Addr:        ":0",
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  tcpReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptUserPool: tcp.OptUserPool{
+				RecvPool: recv,
+				SendPool: send,
+			},
+		}
+
+		// Create a new TCP value.
+		u, err := tcp.New("traceID", "TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to create a new TCP listener.", tests.Success)
+
+		// Start accepting client data.
+		if err := u.Start("traceID"); err != nil {
+			t.Fatal("\tShould be able to start the TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to start the TCP listener.", tests.Success)
+
+		if err := u.Stop("traceID"); err != nil {
+			t.Fatal("\tShould be able to stop the TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to stop the TCP listener.", tests.Success)
+
+		if u.Running() {
+			t.Fatal("\tShould not be running after Stop.", tests.Failed)
+		}
+		t.Log("\tShould not be running after Stop.", tests.Success)
+
+		resp := tcp.Response{
+			TCPAddr: &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1},
+			Data:    []byte("GOT IT\n"),
+			Length:  7,
+		}
+
+		if err := u.Do("traceID", &resp); err != tcp.ErrStopped {
+			t.Fatal("\tShould not be able to submit work after Stop.", tests.Failed, err)
+		}
+		t.Log("\tShould not be able to submit work after Stop.", tests.Success)
+
+		dead, err := pool.New("traceID", "Test-Dead", recvCfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a work pool.", tests.Failed, err)
+		}
+		dead.Shutdown("traceID")
+
+		cfg.RecvPool = dead
+		if _, err := tcp.New("traceID", "TEST", cfg); err != tcp.ErrPoolNotRunning {
+			t.Fatal("\tShould not be able to create a TCP value with a pool that is shutdown.", tests.Failed, err)
+		}
+		t.Log("\tShould not be able to create a TCP value with a pool that is shutdown.", tests.Success)
+	}
+}
+
+// TestDoMulti tests we can send a response to a list of clients.
+func TestDoMulti(t *testing.T) {
+	tests.ResetLog()
+	defer tests.DisplayLog()
+
+	t.Log("Given the need to send data to a list of clients.")
+	{
+		// Create a configuration.
+		cfg := tcp.Config{
+			NetType: "tcp4",
+			Addr:    ":0",
+
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  tcpReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptIntPool: tcp.OptIntPool{
+				RecvMinPoolSize: func() int { return 2 },
+				RecvMaxPoolSize: func() int { return 1000 },
+				SendMinPoolSize: func() int { return 2 },
+				SendMaxPoolSize: func() int { return 1000 },
+			},
+
+			OptLabels: tcp.OptLabels{
+				Labels: map[string]string{"role": "multi"},
+			},
+		}
+
+		// Create a new TCP value.
+		u, err := tcp.New("traceID", "TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to create a new TCP listener.", tests.Success)
+
+		// Start accepting client data.
+		if err := u.Start("traceID"); err != nil {
+			t.Fatal("\tShould be able to start the TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to start the TCP listener.", tests.Success)
+
+		defer u.Stop("traceID")
+
+		var addrs []string
+		var readers []*bufio.Reader
+
+		for i := 0; i < 2; i++ {
+			conn, err := net.Dial("tcp4", u.Addr().String())
+			if err != nil {
+				t.Fatal("\tShould be able to dial a new TCP connection.", tests.Failed, err)
+			}
+			t.Log("\tShould be able to dial a new TCP connection.", tests.Success)
+
+			defer conn.Close()
+
+			// A round trip guarantees the connection has joined.
+			bufReader := bufio.NewReader(conn)
+			conn.Write([]byte("Hello\n"))
+			if _, err := bufReader.ReadString('\n'); err != nil {
+				t.Fatal("\tShould be able to read the response from the connection.", tests.Failed, err)
+			}
+			t.Log("\tShould be able to read the response from the connection.", tests.Success)
+
+			addrs = append(addrs, conn.LocalAddr().String())
+			readers = append(readers, bufReader)
+		}
+
+		errs := u.DoMulti("traceID", append(addrs, "127.0.0.1:1"), []byte("MULTI\n"))
+		if len(errs) != 1 || errs["127.0.0.1:1"] == nil {
+			t.Fatal("\tShould only report an error for the unknown address.", tests.Failed, errs)
+		}
+		t.Log("\tShould only report an error for the unknown address.", tests.Success)
+
+		for _, bufReader := range readers {
+			response, err := bufReader.ReadString('\n')
+			if err != nil || response != "MULTI\n" {
+				t.Fatal("\tShould receive the string \"MULTI\".", tests.Failed, response, err)
+			}
+			t.Log("\tShould receive the string \"MULTI\".", tests.Success)
+		}
+
+		stats := u.Stats()
+		if stats.AcceptedTotal != 2 || stats.Connections != 2 || stats.Name != "TEST" {
+			t.Fatal("\tShould report two connections accepted.", tests.Failed, stats)
+		}
+		t.Log("\tShould report two connections accepted.", tests.Success)
+
+		if stats.Labels["name"] != "TEST" || stats.Labels["role"] != "multi" {
+			t.Fatal("\tShould report the labels with the name.", tests.Failed, stats.Labels)
+		}
+		t.Log("\tShould report the labels with the name.", tests.Success)
+
+		// The processing time is recorded after the response is sent.
+		for i := 0; stats.ProcessMax == 0 && i < 100; i++ {
+			time.Sleep(10 * time.Millisecond)
+			stats = u.Stats()
+		}
+
+		if stats.ProcessAvg <= 0 || stats.ProcessMin <= 0 || stats.ProcessMax < stats.ProcessMin {
+			t.Fatal("\tShould report the time taken to process requests.", tests.Failed, stats.ProcessAvg, stats.ProcessMin, stats.ProcessMax)
+		}
+		t.Log("\tShould report the time taken to process requests.", tests.Success)
+
+		infos := u.Connections()
+		if len(infos) != 2 {
+			t.Fatal("\tShould report information for both connections.", tests.Failed, len(infos))
+		}
+		t.Log("\tShould report information for both connections.", tests.Success)
+
+		for _, info := range infos {
+			if info.PendingMax < 1 || info.ConnectedAt.IsZero() {
+				t.Fatal("\tShould report the pending high water mark.", tests.Failed, info)
+			}
+			t.Log("\tShould report the pending high water mark.", tests.Success)
+
+			if !info.LastActivity.After(info.ConnectedAt) || time.Since(info.LastActivity) > time.Minute {
+				t.Fatal("\tShould report the last activity on the connection.", tests.Failed, info)
+			}
+			t.Log("\tShould report the last activity on the connection.", tests.Success)
+		}
+	}
+}
+
+// TestSetRespHandler tests we can replace the response handler at runtime.
+func TestSetRespHandler(t *testing.T) {
+	tests.ResetLog()
+	defer tests.DisplayLog()
+
+	t.Log("Given the need to replace a handler at runtime.")
+	{
+		// Create a configuration.
+		cfg := tcp.Config{
+			NetType: "tcp4",
+			Addr:    ":0",
+
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  tcpReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptIntPool: tcp.OptIntPool{
+				RecvMinPoolSize: func() int { return 2 },
+				RecvMaxPoolSize: func() int { return 1000 },
+				SendMinPoolSize: func() int { return 2 },
+				SendMaxPoolSize: func() int { return 1000 },
+			},
+		}
+
+		// Create a new TCP value.
+		u, err := tcp.New("traceID", "TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to create a new TCP listener.", tests.Success)
+
+		// Start accepting client data.
+		if err := u.Start("traceID"); err != nil {
+			t.Fatal("\tShould be able to start the TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to start the TCP listener.", tests.Success)
+
+		defer u.Stop("traceID")
+
+		if err := u.SetRespHandler(nil); err != tcp.ErrInvalidRespHandler {
+			t.Fatal("\tShould not be able to set a nil response handler.", tests.Failed, err)
+		}
+		t.Log("\tShould not be able to set a nil response handler.", tests.Success)
+
+		if err := u.SetRespHandler(tcpSwapRespHandler{}); err != nil {
+			t.Fatal("\tShould be able to replace the response handler.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to replace the response handler.", tests.Success)
+
+		conn, err := net.Dial("tcp4", u.Addr().String())
+		if err != nil {
+			t.Fatal("\tShould be able to dial a new TCP connection.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to dial a new TCP connection.", tests.Success)
+
+		defer conn.Close()
+
+		bufReader := bufio.NewReader(conn)
+		conn.Write([]byte("Hello\n"))
+
+		response, err := bufReader.ReadString('\n')
+		if err != nil || response != "SWAPPED\n" {
+			t.Fatal("\tShould receive the string \"SWAPPED\".", tests.Failed, response, err)
+		}
+		t.Log("\tShould receive the string \"SWAPPED\".", tests.Success)
+	}
+}
+
+// TestProcessContext tests the context for a connection is cancelled when
+// the client disconnects.
+func TestProcessContext(t *testing.T) {
+	tests.ResetLog()
+	defer tests.DisplayLog()
+
+	t.Log("Given the need to abandon processing for a client that is gone.")
+	{
+		h := tcpCtxReqHandler{
+			started:   make(chan struct{}),
+			cancelled: make(chan struct{}),
+		}
+
+		// Create a configuration.
+		cfg := tcp.Config{
+			NetType: "tcp4",
+			Addr:    ":0",
+
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  h,
+			RespHandler: tcpRespHandler{},
+
+			OptIntPool: tcp.OptIntPool{
+				RecvMinPoolSize: func() int { return 2 },
+				RecvMaxPoolSize: func() int { return 1000 },
+				SendMinPoolSize: func() int { return 2 },
+				SendMaxPoolSize: func() int { return 1000 },
+			},
+		}
+
+		// Create a new TCP value.
+		u, err := tcp.New("traceID", "TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to create a new TCP listener.", tests.Success)
+
+		// Start accepting client data.
+		if err := u.Start("traceID"); err != nil {
+			t.Fatal("\tShould be able to start the TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to start the TCP listener.", tests.Success)
+
+		defer u.Stop("traceID")
+
+		conn, err := net.Dial("tcp4", u.Addr().String())
+		if err != nil {
+			t.Fatal("\tShould be able to dial a new TCP connection.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to dial a new TCP connection.", tests.Success)
+
+		conn.Write([]byte("Hello\n"))
+
+		select {
+		case <-h.started:
+			t.Log("\tShould start processing the request.", tests.Success)
+		case <-time.After(time.Second):
+			t.Fatal("\tShould start processing the request.", tests.Failed)
+		}
+
+		// Disconnect while the request is being processed.
+		conn.Close()
+
+		select {
+		case <-h.cancelled:
+			t.Log("\tShould cancel the context when the client disconnects.", tests.Success)
+		case <-time.After(time.Second):
+			t.Fatal("\tShould cancel the context when the client disconnects.", tests.Failed)
+		}
+	}
+}
+
+// TestCompressingRespHandler tests responses are compressed before being written.
+func TestCompressingRespHandler(t *testing.T) {
+	tests.ResetLog()
+	defer tests.DisplayLog()
+
+	t.Log("Given the need to compress responses.")
+	{
+		h := tcp.CompressingRespHandler{
+			RespHandler: tcpRespHandler{},
+			MinSize:     16,
+		}
+
+		data := strings.Repeat("GOT IT ", 100) + "\n"
+
+		var buf bytes.Buffer
+		h.Write("traceID", &tcp.Response{Data: []byte(data), Length: len(data)}, bufio.NewWriter(&buf))
+
+		gz, err := gzip.NewReader(&buf)
+		if err != nil {
+			t.Fatal("\tShould write gzip data for a large response.", tests.Failed, err)
+		}
+		t.Log("\tShould write gzip data for a large response.", tests.Success)
+
+		// tcpRespHandler converts the data to a string, so it is
+		// read back through the decompressor untouched.
+		b, err := ioutil.ReadAll(gz)
+		if err != nil || string(b) != data {
+			t.Fatal("\tShould decompress to the original data.", tests.Failed, err)
+		}
+		t.Log("\tShould decompress to the original data.", tests.Success)
+
+		buf.Reset()
+		h.Write("traceID", &tcp.Response{Data: []byte("GOT IT\n"), Length: 7}, bufio.NewWriter(&buf))
+
+		if buf.String() != "GOT IT\n" {
+			t.Fatal("\tShould write a small response uncompressed.", tests.Failed, buf.String())
+		}
+		t.Log("\tShould write a small response uncompressed.", tests.Success)
+
+		h.RespHandler = tcpShortRespHandler{}
+
+		r := tcp.Response{Data: []byte(data), Length: len(data)}
+		h.Write("traceID", &r, bufio.NewWriter(&buf))
+
+		if r.Err != io.ErrShortWrite {
+			t.Fatal("\tShould keep the error set by the wrapped handler.", tests.Failed, r.Err)
+		}
+		t.Log("\tShould keep the error set by the wrapped handler.", tests.Success)
+
+		if string(r.Data) != data || r.Length != len(data) {
+			t.Fatal("\tShould restore the original data.", tests.Failed, r.Length)
+		}
+		t.Log("\tShould restore the original data.", tests.Success)
+	}
+}
+
+// TestListenBacklog tests the listen backlog configuration.
+func TestListenBacklog(t *testing.T) {
+	tests.ResetLog()
+	defer tests.DisplayLog()
+
+	t.Log("Given the need to configure the listen backlog.")
+	{
+		// Create a configuration.
+		cfg := tcp.Config{
+			NetType: "tcp4",
+			Addr:    ":0",
+
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  tcpReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptIntPool: tcp.OptIntPool{
+				RecvMinPoolSize: func() int { return 2 },
+				RecvMaxPoolSize: func() int { return 1000 },
+				SendMinPoolSize: func() int { return 2 },
+				SendMaxPoolSize: func() int { return 1000 },
+			},
+
+			OptListen: tcp.OptListen{
+				ListenBacklog: -1,
+			},
+		}
+
+		if _, err := tcp.New("traceID", "TEST", cfg); err != tcp.ErrInvalidListenBacklog {
+			t.Fatal("\tShould not be able to use a negative backlog.", tests.Failed, err)
+		}
+		t.Log("\tShould not be able to use a negative backlog.", tests.Success)
+
+		cfg.ListenBacklog = 1024
+
+		errTLS := errors.New("TLS required")
+		cfg.ValidateFunc = func() error { return errTLS }
+
+		if _, err := tcp.New("traceID", "TEST", cfg); err != errTLS {
+			t.Fatal("\tShould return the error from ValidateFunc.", tests.Failed, err)
+		}
+		t.Log("\tShould return the error from ValidateFunc.", tests.Success)
+
+		cfg.ValidateFunc = nil
+
+		// Fast open is only supported on linux.
+		cfg.FastOpen = true
+		if runtime.GOOS != "linux" {
+			if _, err := tcp.New("traceID", "TEST", cfg); err != tcp.ErrFastOpenNotSupported {
+				t.Fatal("\tShould not be able to use fast open.", tests.Failed, err)
+			}
+			t.Log("\tShould not be able to use fast open.", tests.Success)
+
+			cfg.FastOpen = false
+		}
+
+		// Create a new TCP value.
+		u, err := tcp.New("traceID", "TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to create a new TCP listener.", tests.Success)
+
+		// Start accepting client data.
+		if err := u.Start("traceID"); err != nil {
+			t.Fatal("\tShould be able to start the TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to start the TCP listener.", tests.Success)
+
+		defer u.Stop("traceID")
+
+		conn, err := net.Dial("tcp4", u.Addr().String())
+		if err != nil {
+			t.Fatal("\tShould be able to dial a new TCP connection.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to dial a new TCP connection.", tests.Success)
+
+		conn.Close()
+	}
+}
+
+// TestInMemory tests handlers can be exercised without binding a port.
+func TestInMemory(t *testing.T) {
+	tests.ResetLog()
+	defer tests.DisplayLog()
+
+	t.Log("Given the need to process TCP data without the network.")
+	{
+		reasons := make(chan tcp.CloseReason, 1)
+
+		// Create a configuration.
+		cfg := tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  tcpReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptIntPool: tcp.OptIntPool{
+				RecvMinPoolSize: func() int { return 2 },
+				RecvMaxPoolSize: func() int { return 1000 },
+				SendMinPoolSize: func() int { return 2 },
+				SendMaxPoolSize: func() int { return 1000 },
+			},
+
+			OptDisconnect: tcp.OptDisconnect{
+				OnDisconnect: func(remoteAddr string, reason tcp.CloseReason) {
+					reasons <- reason
+				},
+			},
+		}
+
+		// Create a new in-memory TCP value.
+		u, connector, err := tcp.NewInMemory("traceID", "TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new in-memory TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to create a new in-memory TCP listener.", tests.Success)
+
+		if _, err := connector.Connect(); err != tcp.ErrNotListening {
+			t.Fatal("\tShould not be able to connect before Start.", tests.Failed, err)
+		}
+		t.Log("\tShould not be able to connect before Start.", tests.Success)
+
+		// Start accepting client data.
+		if err := u.Start("traceID"); err != nil {
+			t.Fatal("\tShould be able to start the TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to start the TCP listener.", tests.Success)
+
+		defer u.Stop("traceID")
+
+		conn, err := connector.Connect()
+		if err != nil {
+			t.Fatal("\tShould be able to connect in memory.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to connect in memory.", tests.Success)
+
+		bufReader := bufio.NewReader(conn)
+		if _, err := conn.Write([]byte("Hello\n")); err != nil {
+			t.Fatal("\tShould be able to send data to the connection.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to send data to the connection.", tests.Success)
+
+		response, err := bufReader.ReadString('\n')
+		if err != nil || response != "GOT IT\n" {
+			t.Fatal("\tShould receive the string \"GOT IT\".", tests.Failed, response, err)
+		}
+		t.Log("\tShould receive the string \"GOT IT\".", tests.Success)
+
+		conn.Close()
+
+		select {
+		case reason := <-reasons:
+			if reason != tcp.CloseClientEOF || !reason.Clean() {
+				t.Fatal("\tShould report the client closed the connection.", tests.Failed, reason)
+			}
+			t.Log("\tShould report the client closed the connection.", tests.Success)
+
+		case <-time.After(time.Second):
+			t.Fatal("\tShould report the client closed the connection.", tests.Failed)
+		}
+	}
+}
+
+// TestMultiplexReqHandler tests requests are dispatched by message type.
+func TestMultiplexReqHandler(t *testing.T) {
+	tests.ResetLog()
+	defer tests.DisplayLog()
+
+	t.Log("Given the need to dispatch requests by message type.")
+	{
+		var got string
+		record := func(name string) tcp.ProcessFunc {
+			return func(traceID string, r *tcp.Request) {
+				got = name
+			}
+		}
+
+		m := tcp.NewMultiplexReqHandler(tcpReqHandler{}.Read, record("default"))
+		m.Handle('A', record("A"))
+		m.Handle('B', record("B"))
+
+		table := []struct {
+			data string
+			want string
+		}{
+			{"Apple", "A"},
+			{"Banana", "B"},
+			{"Cherry", "default"},
+			{"", "default"},
+		}
+
+		for _, tt := range table {
+			got = ""
+			m.Process("traceID", &tcp.Request{Data: []byte(tt.data), Length: len(tt.data)})
+
+			if got != tt.want {
+				t.Fatalf("\tShould dispatch %q to %q : got %q. %s", tt.data, tt.want, got, tests.Failed)
+			}
+			t.Logf("\tShould dispatch %q to %q. %s", tt.data, tt.want, tests.Success)
+		}
+	}
+}
+
+// TestTemporaryReadError tests a connection survives a temporary read error.
+func TestTemporaryReadError(t *testing.T) {
+	tests.ResetLog()
+	defer tests.DisplayLog()
+
+	t.Log("Given the need to retry reads after a temporary error.")
+	{
+		// Create a configuration.
+		cfg := tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  tcpTempReqHandler{reads: new(int32)},
+			RespHandler: tcpRespHandler{},
+
+			OptIntPool: tcp.OptIntPool{
+				RecvMinPoolSize: func() int { return 2 },
+				RecvMaxPoolSize: func() int { return 1000 },
+				SendMinPoolSize: func() int { return 2 },
+				SendMaxPoolSize: func() int { return 1000 },
+			},
+		}
+
+		// Create a new in-memory TCP value.
+		u, connector, err := tcp.NewInMemory("traceID", "TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new in-memory TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to create a new in-memory TCP listener.", tests.Success)
+
+		// Start accepting client data.
+		if err := u.Start("traceID"); err != nil {
+			t.Fatal("\tShould be able to start the TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to start the TCP listener.", tests.Success)
+
+		defer u.Stop("traceID")
+
+		conn, err := connector.Connect()
+		if err != nil {
+			t.Fatal("\tShould be able to connect in memory.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to connect in memory.", tests.Success)
+
+		defer conn.Close()
+
+		bufReader := bufio.NewReader(conn)
+		if _, err := conn.Write([]byte("Hello\n")); err != nil {
+			t.Fatal("\tShould be able to send data after a temporary error.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to send data after a temporary error.", tests.Success)
+
+		response, err := bufReader.ReadString('\n')
+		if err != nil || response != "GOT IT\n" {
+			t.Fatal("\tShould receive the string \"GOT IT\".", tests.Failed, response, err)
+		}
+		t.Log("\tShould receive the string \"GOT IT\".", tests.Success)
+	}
+}
+
+// TestReadBuffer tests data buffered past the end of a request is kept for
+// the next read.
+func TestReadBuffer(t *testing.T) {
+	tests.ResetLog()
+	defer tests.DisplayLog()
+
+	t.Log("Given the need to read several requests from a single write.")
+	{
+		// Create a configuration.
+		cfg := tcp.Config{
+			ConnHandler: tcpRawConnHandler{},
+			ReqHandler:  tcpReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptIntPool: tcp.OptIntPool{
+				RecvMinPoolSize: func() int { return 2 },
+				RecvMaxPoolSize: func() int { return 1000 },
+				SendMinPoolSize: func() int { return 2 },
+				SendMaxPoolSize: func() int { return 1000 },
+			},
+		}
+
+		// Create a new in-memory TCP value.
+		u, connector, err := tcp.NewInMemory("traceID", "TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new in-memory TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to create a new in-memory TCP listener.", tests.Success)
+
+		// Start accepting client data.
+		if err := u.Start("traceID"); err != nil {
+			t.Fatal("\tShould be able to start the TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to start the TCP listener.", tests.Success)
+
+		defer u.Stop("traceID")
+
+		conn, err := connector.Connect()
+		if err != nil {
+			t.Fatal("\tShould be able to connect in memory.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to connect in memory.", tests.Success)
+
+		defer conn.Close()
+
+		// The pipe is synchronous so the responses are read
+		// while the requests are being written.
+		go conn.Write([]byte("Hello\nHello\n"))
+
+		bufReader := bufio.NewReader(conn)
+		for i := 0; i < 2; i++ {
+			response, err := bufReader.ReadString('\n')
+			if err != nil || response != "GOT IT\n" {
+				t.Fatal("\tShould receive the string \"GOT IT\" for each request.", tests.Failed, response, err)
+			}
+			t.Log("\tShould receive the string \"GOT IT\" for each request.", tests.Success)
+		}
+	}
+}
+
+// TestWriteRateLimit tests writes to a connection are paced.
+func TestWriteRateLimit(t *testing.T) {
+	tests.ResetLog()
+	defer tests.DisplayLog()
+
+	t.Log("Given the need to limit the rate data is written to a client.")
+	{
+		// Create a configuration.
+		cfg := tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  tcpReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptIntPool: tcp.OptIntPool{
+				RecvMinPoolSize: func() int { return 2 },
+				RecvMaxPoolSize: func() int { return 1000 },
+				SendMinPoolSize: func() int { return 2 },
+				SendMaxPoolSize: func() int { return 1000 },
+			},
+
+			// Each response is 7 bytes, so two of them take
+			// at least 500ms.
+			OptRateLimit: tcp.OptRateLimit{
+				WriteRateLimit: 14,
+			},
+		}
+
+		// Create a new in-memory TCP value.
+		u, connector, err := tcp.NewInMemory("traceID", "TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new in-memory TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to create a new in-memory TCP listener.", tests.Success)
+
+		// Start accepting client data.
+		if err := u.Start("traceID"); err != nil {
+			t.Fatal("\tShould be able to start the TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to start the TCP listener.", tests.Success)
+
+		defer u.Stop("traceID")
+
+		conn, err := connector.Connect()
+		if err != nil {
+			t.Fatal("\tShould be able to connect in memory.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to connect in memory.", tests.Success)
+
+		defer conn.Close()
+
+		start := time.Now()
+		go conn.Write([]byte("Hello\nHello\n"))
+
+		bufReader := bufio.NewReader(conn)
+		for i := 0; i < 2; i++ {
+			if _, err := bufReader.ReadString('\n'); err != nil {
+				t.Fatal("\tShould be able to read the response from the connection.", tests.Failed, err)
+			}
+			t.Log("\tShould be able to read the response from the connection.", tests.Success)
+		}
+
+		if d := time.Since(start); d < 400*time.Millisecond {
+			t.Fatal("\tShould pace the writes to the connection.", tests.Failed, d)
+		}
+		t.Log("\tShould pace the writes to the connection.", tests.Success)
+	}
+}
+
+// TestAllowSNI tests TLS connections are dropped for server names that
+// aren't allowed.
+func TestAllowSNI(t *testing.T) {
+	tests.ResetLog()
+	defer tests.DisplayLog()
+
+	t.Log("Given the need to only accept TLS connections for known server names.")
+	{
+		cert, err := newCertificate("good.example")
+		if err != nil {
+			t.Fatal("\tShould be able to create a certificate.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to create a certificate.", tests.Success)
+
+		reasons := make(chan string, 1)
+
+		// Create a configuration.
+		cfg := tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  tcpReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptIntPool: tcp.OptIntPool{
+				RecvMinPoolSize: func() int { return 2 },
+				RecvMaxPoolSize: func() int { return 1000 },
+				SendMinPoolSize: func() int { return 2 },
+				SendMaxPoolSize: func() int { return 1000 },
+			},
+
+			OptTLS: tcp.OptTLS{
+				TLSConfig: &tls.Config{Certificates: []tls.Certificate{cert}},
+				AllowSNI:  func(name string) bool { return name == "good.example" },
+			},
+
+			OptDrop: tcp.OptDrop{
+				OnDrop: func(reason string, remoteAddr string) {
+					reasons <- reason
+				},
+			},
+		}
+
+		// Create a new in-memory TCP value.
+		u, connector, err := tcp.NewInMemory("traceID", "TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new in-memory TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to create a new in-memory TCP listener.", tests.Success)
+
+		// Start accepting client data.
+		if err := u.Start("traceID"); err != nil {
+			t.Fatal("\tShould be able to start the TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to start the TCP listener.", tests.Success)
+
+		defer u.Stop("traceID")
+
+		connect := func(name string) (*tls.Conn, error) {
+			conn, err := connector.Connect()
+			if err != nil {
+				return nil, err
+			}
+
+			tc := tls.Client(conn, &tls.Config{ServerName: name, InsecureSkipVerify: true})
+			if err := tc.Handshake(); err != nil {
+				conn.Close()
+				return nil, err
+			}
+
+			return tc, nil
+		}
+
+		tc, err := connect("good.example")
+		if err != nil {
+			t.Fatal("\tShould be able to connect with an allowed server name.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to connect with an allowed server name.", tests.Success)
+
+		defer tc.Close()
+
+		bufReader := bufio.NewReader(tc)
+		tc.Write([]byte("Hello\n"))
+
+		response, err := bufReader.ReadString('\n')
+		if err != nil || response != "GOT IT\n" {
+			t.Fatal("\tShould receive the string \"GOT IT\".", tests.Failed, response, err)
+		}
+		t.Log("\tShould receive the string \"GOT IT\".", tests.Success)
+
+		if _, err := connect("bad.example"); err == nil {
+			t.Fatal("\tShould not be able to connect with a server name that isn't allowed.", tests.Failed)
+		}
+		t.Log("\tShould not be able to connect with a server name that isn't allowed.", tests.Success)
+
+		select {
+		case reason := <-reasons:
+			if reason != tcp.DropReasonSNI {
+				t.Fatal("\tShould report the SNI drop reason.", tests.Failed, reason)
+			}
+			t.Log("\tShould report the SNI drop reason.", tests.Success)
+
+		case <-time.After(time.Second):
+			t.Fatal("\tShould report the SNI drop reason.", tests.Failed)
+		}
+
+		if stat := u.Stats(); stat.AcceptedTotal != 1 || stat.AcceptedTLS != 1 {
+			t.Fatal("\tShould not count the connection rejected for its server name.", tests.Failed, stat.AcceptedTotal, stat.AcceptedTLS)
+		}
+		t.Log("\tShould not count the connection rejected for its server name.", tests.Success)
+
+		if n := len(u.Connections()); n != 1 {
+			t.Fatal("\tShould not add the connection rejected for its server name.", tests.Failed, n)
+		}
+		t.Log("\tShould not add the connection rejected for its server name.", tests.Success)
+	}
+}
+
+// TestGetCertificate tests the certificate is selected by the TLS
+// configuration on every handshake.
+func TestGetCertificate(t *testing.T) {
+	tests.ResetLog()
+	defer tests.DisplayLog()
+
+	t.Log("Given the need to select the certificate for each TLS connection.")
+	{
+		certs := make(map[string]*tls.Certificate)
+		for _, host := range []string{"a.example", "b.example"} {
+			cert, err := newCertificate(host)
+			if err != nil {
+				t.Fatal("\tShould be able to create a certificate.", tests.Failed, err)
+			}
+			certs[host] = &cert
+		}
+		t.Log("\tShould be able to create a certificate.", tests.Success)
+
+		var calls int32
+		getCertificate := func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			atomic.AddInt32(&calls, 1)
+			if cert, ok := certs[hello.ServerName]; ok {
+				return cert, nil
+			}
+			return nil, errors.New("unknown server name")
+		}
+
+		// Run with and without AllowSNI, since the configuration is
+		// cloned to check the server name.
+		for _, allow := range []func(string) bool{nil, func(string) bool { return true }} {
+			atomic.StoreInt32(&calls, 0)
+
+			// Create a configuration.
+			cfg := tcp.Config{
+				ConnHandler: tcpConnHandler{},
+				ReqHandler:  tcpReqHandler{},
+				RespHandler: tcpRespHandler{},
+
+				OptIntPool: tcp.OptIntPool{
+					RecvMinPoolSize: func() int { return 2 },
+					RecvMaxPoolSize: func() int { return 1000 },
+					SendMinPoolSize: func() int { return 2 },
+					SendMaxPoolSize: func() int { return 1000 },
+				},
+
+				OptTLS: tcp.OptTLS{
+					TLSConfig: &tls.Config{GetCertificate: getCertificate},
+					AllowSNI:  allow,
+				},
+			}
+
+			// Create a new in-memory TCP value.
+			u, connector, err := tcp.NewInMemory("traceID", "TEST", cfg)
+			if err != nil {
+				t.Fatal("\tShould be able to create a new in-memory TCP listener.", tests.Failed, err)
+			}
+			t.Log("\tShould be able to create a new in-memory TCP listener.", tests.Success)
+
+			// Start accepting client data.
+			if err := u.Start("traceID"); err != nil {
+				t.Fatal("\tShould be able to start the TCP listener.", tests.Failed, err)
+			}
+			t.Log("\tShould be able to start the TCP listener.", tests.Success)
+
+			for _, host := range []string{"a.example", "b.example", "a.example"} {
+				conn, err := connector.Connect()
+				if err != nil {
+					t.Fatal("\tShould be able to connect.", tests.Failed, err)
+				}
+
+				tc := tls.Client(conn, &tls.Config{ServerName: host, InsecureSkipVerify: true})
+				if err := tc.Handshake(); err != nil {
+					t.Fatal("\tShould be able to complete the handshake.", tests.Failed, host, err)
+				}
+
+				peer := tc.ConnectionState().PeerCertificates[0]
+				if peer.Subject.CommonName != host {
+					t.Fatalf("\tShould be served the certificate for %s. %s Got %s", host, tests.Failed, peer.Subject.CommonName)
+				}
+				t.Logf("\tShould be served the certificate for %s. %s", host, tests.Success)
+
+				tc.Close()
+			}
+
+			if n := atomic.LoadInt32(&calls); n != 3 {
+				t.Fatal("\tShould call GetCertificate for every handshake.", tests.Failed, n)
+			}
+			t.Log("\tShould call GetCertificate for every handshake.", tests.Success)
+
+			for i := 0; u.Stats().AcceptedTLS != 3; i++ {
+				if i == 100 {
+					t.Fatal("\tShould count the connections as TLS.", tests.Failed, u.Stats().AcceptedTLS)
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			if n := u.Stats().AcceptedPlain; n != 0 {
+				t.Fatal("\tShould count the connections as TLS.", tests.Failed, n)
+			}
+			t.Log("\tShould count the connections as TLS.", tests.Success)
+
+			u.Stop("traceID")
+		}
+	}
+}
+
+// TestCoalescedFrames tests no bytes are lost when many requests arrive
+// in a single segment or a request is split across segments.
+func TestCoalescedFrames(t *testing.T) {
+	tests.ResetLog()
+	defer tests.DisplayLog()
+
+	t.Log("Given the need to read requests coalesced into and split across segments.")
+	{
+		const frames = 5000
+
+		// Create a configuration.
+		cfg := tcp.Config{
+			NetType: "tcp4",
+			Addr:    ":0",
+
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  tcpEchoReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptIntPool: tcp.OptIntPool{
+				RecvMinPoolSize: func() int { return 2 },
+				RecvMaxPoolSize: func() int { return 1000 },
+				SendMinPoolSize: func() int { return 2 },
+				SendMaxPoolSize: func() int { return 1000 },
+			},
+		}
+
+		// Create a new TCP value.
+		u, err := tcp.New("traceID", "TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to create a new TCP listener.", tests.Success)
+
+		// Start accepting client data.
+		if err := u.Start("traceID"); err != nil {
+			t.Fatal("\tShould be able to start the TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to start the TCP listener.", tests.Success)
+
+		defer u.Stop("traceID")
+
+		conn, err := net.Dial("tcp4", u.Addr().String())
+		if err != nil {
+			t.Fatal("\tShould be able to dial a new TCP connection.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to dial a new TCP connection.", tests.Success)
+
+		defer conn.Close()
+
+		var payload bytes.Buffer
+		for i := 0; i < frames; i++ {
+			payload.WriteString("frame-" + strconv.Itoa(i) + "\n")
+		}
+
+		// Write in chunks that don't line up with the frames so most
+		// writes carry several frames and end part way through one.
+		go func() {
+			data := payload.Bytes()
+			for len(data) > 0 {
+				n := 1021
+				if n > len(data) {
+					n = len(data)
+				}
+				if _, err := conn.Write(data[:n]); err != nil {
+					return
+				}
+				data = data[n:]
+			}
+		}()
+
+		conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+
+		seen := make(map[string]bool)
+		bufReader := bufio.NewReader(conn)
+		for i := 0; i < frames; i++ {
+			response, err := bufReader.ReadString('\n')
+			if err != nil {
+				t.Fatal("\tShould be able to read every response.", tests.Failed, len(seen), err)
+			}
+			seen[response] = true
+		}
+		t.Log("\tShould be able to read every response.", tests.Success)
+
+		for i := 0; i < frames; i++ {
+			if frame := "frame-" + strconv.Itoa(i) + "\n"; !seen[frame] {
+				t.Fatal("\tShould receive every frame intact.", tests.Failed, frame)
+			}
+		}
+		t.Log("\tShould receive every frame intact.", tests.Success)
+	}
+}
+
+// TestJSONLine tests the newline delimited JSON handlers.
+func TestJSONLine(t *testing.T) {
+	tests.ResetLog()
+	defer tests.DisplayLog()
+
+	t.Log("Given the need to process newline delimited JSON.")
+	{
+		type greeting struct {
+			Name string `json:"name"`
+		}
+
+		process := func(traceID string, r *tcp.Request) {
+			var g greeting
+			if err := r.DecodeJSON(&g); err != nil {
+				return
+			}
+
+			resp, err := r.NewJSONResponse(greeting{Name: "hello " + g.Name})
+			if err != nil {
+				return
+			}
+
+			r.TCP.Do(traceID, resp)
+		}
+
+		// Create a configuration.
+		cfg := tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  tcp.JSONLineReqHandler{Processor: tcp.ProcessFunc(process), MaxLineSize: 64},
+			RespHandler: tcp.JSONLineRespHandler{},
+
+			OptIntPool: tcp.OptIntPool{
+				RecvMinPoolSize: func() int { return 2 },
+				RecvMaxPoolSize: func() int { return 1000 },
+				SendMinPoolSize: func() int { return 2 },
+				SendMaxPoolSize: func() int { return 1000 },
+			},
+		}
+
+		// Create a new in-memory TCP value.
+		u, connector, err := tcp.NewInMemory("traceID", "TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new in-memory TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to create a new in-memory TCP listener.", tests.Success)
+
+		// Start accepting client data.
+		if err := u.Start("traceID"); err != nil {
+			t.Fatal("\tShould be able to start the TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to start the TCP listener.", tests.Success)
+
+		defer u.Stop("traceID")
+
+		conn, err := connector.Connect()
+		if err != nil {
+			t.Fatal("\tShould be able to connect in memory.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to connect in memory.", tests.Success)
+
+		defer conn.Close()
+
+		// The blank line is skipped.
+		go conn.Write([]byte("\r\n{\"name\":\"bill\"}\r\n"))
+
+		bufReader := bufio.NewReader(conn)
+		response, err := bufReader.ReadString('\n')
+		if err != nil || response != "{\"name\":\"hello bill\"}\n" {
+			t.Fatal("\tShould receive a JSON line response.", tests.Failed, response, err)
+		}
+		t.Log("\tShould receive a JSON line response.", tests.Success)
+
+		if _, _, err := tcp.ReadLine(0)("traceID", "", strings.NewReader("{}\n")); err != tcp.ErrNotBufioReader {
+			t.Fatal("\tShould refuse a reader that isn't a *bufio.Reader.", tests.Failed, err)
+		}
+		t.Log("\tShould refuse a reader that isn't a *bufio.Reader.", tests.Success)
+
+		// A line that is too long closes the connection.
+		go conn.Write([]byte("{\"name\":\"" + strings.Repeat("x", 64) + "\"}\n"))
+
+		if _, err := bufReader.ReadString('\n'); err == nil {
+			t.Fatal("\tShould close the connection for a line that is too long.", tests.Failed)
+		}
+		t.Log("\tShould close the connection for a line that is too long.", tests.Success)
+	}
+}
+
+// TestResetConnections tests all connections are dropped while the
+// listener keeps accepting.
+func TestResetConnections(t *testing.T) {
+	tests.ResetLog()
+	defer tests.DisplayLog()
+
+	t.Log("Given the need to reset every connection without stopping.")
+	{
+		reasons := make(chan tcp.CloseReason, 1)
+
+		// Create a configuration.
+		cfg := tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  tcpReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptIntPool: tcp.OptIntPool{
+				RecvMinPoolSize: func() int { return 2 },
+				RecvMaxPoolSize: func() int { return 1000 },
+				SendMinPoolSize: func() int { return 2 },
+				SendMaxPoolSize: func() int { return 1000 },
+			},
+
+			OptDisconnect: tcp.OptDisconnect{
+				OnDisconnect: func(remoteAddr string, reason tcp.CloseReason) {
+					reasons <- reason
+				},
+			},
+		}
+
+		// Create a new in-memory TCP value.
+		u, connector, err := tcp.NewInMemory("traceID", "TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new in-memory TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to create a new in-memory TCP listener.", tests.Success)
+
+		// Start accepting client data.
+		if err := u.Start("traceID"); err != nil {
+			t.Fatal("\tShould be able to start the TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to start the TCP listener.", tests.Success)
+
+		defer u.Stop("traceID")
+
+		roundTrip := func() *bufio.Reader {
+			conn, err := connector.Connect()
+			if err != nil {
+				t.Fatal("\tShould be able to connect in memory.", tests.Failed, err)
+			}
+			t.Log("\tShould be able to connect in memory.", tests.Success)
+
+			go conn.Write([]byte("Hello\n"))
+
+			bufReader := bufio.NewReader(conn)
+			if response, err := bufReader.ReadString('\n'); err != nil || response != "GOT IT\n" {
+				t.Fatal("\tShould receive the string \"GOT IT\".", tests.Failed, response, err)
+			}
+			t.Log("\tShould receive the string \"GOT IT\".", tests.Success)
+
+			return bufReader
+		}
+
+		bufReader := roundTrip()
+
+		u.ResetConnections("traceID")
+
+		if _, err := bufReader.ReadString('\n'); err == nil {
+			t.Fatal("\tShould close the connection.", tests.Failed)
+		}
+		t.Log("\tShould close the connection.", tests.Success)
+
+		if reason := <-reasons; reason != tcp.CloseDropped {
+			t.Fatal("\tShould report the connection was dropped.", tests.Failed, reason)
+		}
+		t.Log("\tShould report the connection was dropped.", tests.Success)
+
+		if stats := u.Stats(); stats.Resets != 1 || stats.Connections != 0 {
+			t.Fatal("\tShould count the reset.", tests.Failed, stats)
+		}
+		t.Log("\tShould count the reset.", tests.Success)
+
+		// The listener is still accepting connections.
+		roundTrip()
+	}
+}
+
+// TestWriteProgressTimeout tests the write deadline moves forward while
+// a response is making progress.
+func TestWriteProgressTimeout(t *testing.T) {
+	tests.ResetLog()
+	defer tests.DisplayLog()
+
+	t.Log("Given the need to tell a slow client from a stalled one.")
+	{
+		const size = 64 * 1024
+
+		errs := make(chan error, 2)
+
+		// Create a configuration.
+		cfg := tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  tcpSizeReqHandler{size: size, errs: errs},
+			RespHandler: tcpRespHandler{},
+
+			OptIntPool: tcp.OptIntPool{
+				RecvMinPoolSize: func() int { return 2 },
+				RecvMaxPoolSize: func() int { return 1000 },
+				SendMinPoolSize: func() int { return 2 },
+				SendMaxPoolSize: func() int { return 1000 },
+			},
+
+			OptTimeout: tcp.OptTimeout{
+				WriteProgressTimeout: 200 * time.Millisecond,
+			},
+		}
+
+		// Create a new in-memory TCP value.
+		u, connector, err := tcp.NewInMemory("traceID", "TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new in-memory TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to create a new in-memory TCP listener.", tests.Success)
+
+		// Start accepting client data.
+		if err := u.Start("traceID"); err != nil {
+			t.Fatal("\tShould be able to start the TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to start the TCP listener.", tests.Success)
+
+		defer u.Stop("traceID")
+
+		conn, err := connector.Connect()
+		if err != nil {
+			t.Fatal("\tShould be able to connect in memory.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to connect in memory.", tests.Success)
+
+		defer conn.Close()
+
+		go conn.Write([]byte("Hello\n"))
+
+		// Read slowly, the whole response takes longer than the
+		// progress timeout.
+		start := time.Now()
+		buf := make([]byte, 4096)
+		for read := 0; read < size; {
+			time.Sleep(20 * time.Millisecond)
+
+			n, err := conn.Read(buf)
+			if err != nil {
+				t.Fatal("\tShould be able to read the response slowly.", tests.Failed, err)
+			}
+			read += n
+		}
+		t.Log("\tShould be able to read the response slowly.", tests.Success)
+
+		if err := <-errs; err != nil || time.Since(start) < 200*time.Millisecond {
+			t.Fatal("\tShould write a response that is making progress.", tests.Failed, err, time.Since(start))
+		}
+		t.Log("\tShould write a response that is making progress.", tests.Success)
+
+		// Stop reading, the response stalls.
+		go conn.Write([]byte("Hello\n"))
+
+		select {
+		case err := <-errs:
+			if err == nil {
+				t.Fatal("\tShould fail to write a response that has stalled.", tests.Failed)
+			}
+			t.Log("\tShould fail to write a response that has stalled.", tests.Success)
+
+		case <-time.After(2 * time.Second):
+			t.Fatal("\tShould fail to write a response that has stalled.", tests.Failed)
+		}
+	}
+}
+
+// TestResponseDeadline tests stale responses are not written.
+func TestResponseDeadline(t *testing.T) {
+	tests.ResetLog()
+	defer tests.DisplayLog()
+
+	t.Log("Given the need to discard responses that are stale.")
+	{
+		// Create a configuration.
+		cfg := tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  tcpReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptIntPool: tcp.OptIntPool{
+				RecvMinPoolSize: func() int { return 2 },
+				RecvMaxPoolSize: func() int { return 1000 },
+				SendMinPoolSize: func() int { return 2 },
+				SendMaxPoolSize: func() int { return 1000 },
+			},
+		}
+
+		// Create a new in-memory TCP value.
+		u, connector, err := tcp.NewInMemory("traceID", "TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new in-memory TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to create a new in-memory TCP listener.", tests.Success)
+
+		// Start accepting client data.
+		if err := u.Start("traceID"); err != nil {
+			t.Fatal("\tShould be able to start the TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to start the TCP listener.", tests.Success)
+
+		defer u.Stop("traceID")
+
+		conn, err := connector.Connect()
+		if err != nil {
+			t.Fatal("\tShould be able to connect in memory.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to connect in memory.", tests.Success)
+
+		defer conn.Close()
+
+		// Wait for the connection to join.
+		for i := 0; len(u.Connections()) == 0; i++ {
+			if i == 100 {
+				t.Fatal("\tShould see the connection join.", tests.Failed)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Log("\tShould see the connection join.", tests.Success)
+
+		addr, err := net.ResolveTCPAddr("tcp", u.Connections()[0].Addr)
+		if err != nil {
+			t.Fatal("\tShould be able to resolve the client address.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to resolve the client address.", tests.Success)
+
+		errs := make(chan error, 1)
+		send := func(data string, deadline time.Time) {
+			resp := tcp.Response{
+				TCPAddr:  addr,
+				Data:     []byte(data),
+				Length:   len(data),
+				Deadline: deadline,
+				Complete: func(rsp *tcp.Response) {
+					errs <- rsp.Err
+				},
+			}
+
+			if err := u.Do("traceID", &resp); err != nil {
+				t.Fatal("\tShould be able to send the response.", tests.Failed, err)
+			}
+		}
+
+		send("STALE\n", time.Now().Add(-time.Second))
+
+		if err := <-errs; err != tcp.ErrStale {
+			t.Fatal("\tShould report the stale response.", tests.Failed, err)
+		}
+		t.Log("\tShould report the stale response.", tests.Success)
+
+		send("FRESH\n", time.Now().Add(time.Minute))
+
+		bufReader := bufio.NewReader(conn)
+		if response, err := bufReader.ReadString('\n'); err != nil || response != "FRESH\n" {
+			t.Fatal("\tShould only receive the fresh response.", tests.Failed, response, err)
+		}
+		t.Log("\tShould only receive the fresh response.", tests.Success)
+
+		if err := <-errs; err != nil {
+			t.Fatal("\tShould write the fresh response.", tests.Failed, err)
+		}
+		t.Log("\tShould write the fresh response.", tests.Success)
+	}
+}
+
+// TestCancelPending tests responses waiting to be written can be cancelled.
+func TestCancelPending(t *testing.T) {
+	tests.ResetLog()
+	defer tests.DisplayLog()
+
+	t.Log("Given the need to cancel the responses waiting for a client.")
+	{
+		// Create a configuration with a single send routine so
+		// responses wait behind the one being written.
+		cfg := tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  tcpReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptIntPool: tcp.OptIntPool{
+				RecvMinPoolSize: func() int { return 2 },
+				RecvMaxPoolSize: func() int { return 1000 },
+				SendMinPoolSize: func() int { return 1 },
+				SendMaxPoolSize: func() int { return 1 },
+			},
+		}
+
+		// Create a new in-memory TCP value.
+		u, connector, err := tcp.NewInMemory("traceID", "TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new in-memory TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to create a new in-memory TCP listener.", tests.Success)
+
+		// Start accepting client data.
+		if err := u.Start("traceID"); err != nil {
+			t.Fatal("\tShould be able to start the TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to start the TCP listener.", tests.Success)
+
+		defer u.Stop("traceID")
+
+		conn, err := connector.Connect()
+		if err != nil {
+			t.Fatal("\tShould be able to connect in memory.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to connect in memory.", tests.Success)
+
+		defer conn.Close()
+
+		// Wait for the connection to join.
+		for i := 0; len(u.Connections()) == 0; i++ {
+			if i == 100 {
+				t.Fatal("\tShould see the connection join.", tests.Failed)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Log("\tShould see the connection join.", tests.Success)
+
+		addr := u.Connections()[0].Addr
+		tcpAddr, err := net.ResolveTCPAddr("tcp", addr)
+		if err != nil {
+			t.Fatal("\tShould be able to resolve the client address.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to resolve the client address.", tests.Success)
+
+		// The client isn't reading, so the first response blocks the
+		// only send routine and the rest wait.
+		errs := make(chan error, 3)
+		for i := 0; i < 3; i++ {
+			resp := tcp.Response{
+				TCPAddr: tcpAddr,
+				Data:    []byte("GOT IT\n"),
+				Length:  7,
+				Complete: func(rsp *tcp.Response) {
+					errs <- rsp.Err
+				},
+			}
+			go u.Do("traceID", &resp)
+		}
+
+		for i := 0; u.Connections()[0].Pending != 3; i++ {
+			if i == 100 {
+				t.Fatal("\tShould have three pending responses.", tests.Failed)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Log("\tShould have three pending responses.", tests.Success)
+
+		if n := u.CancelPending(addr); n != 2 {
+			t.Fatal("\tShould cancel the responses that are waiting.", tests.Failed, n)
+		}
+		t.Log("\tShould cancel the responses that are waiting.", tests.Success)
+
+		go io.Copy(ioutil.Discard, conn)
+
+		var written, cancelled int
+		for i := 0; i < 3; i++ {
+			switch <-errs {
+			case nil:
+				written++
+			case tcp.ErrCancelled:
+				cancelled++
+			}
+		}
+
+		if written != 1 || cancelled != 2 {
+			t.Fatal("\tShould only write the response that wasn't cancelled.", tests.Failed, written, cancelled)
+		}
+		t.Log("\tShould only write the response that wasn't cancelled.", tests.Success)
+	}
+}
+
+// TestStopAll tests stopping several managers that share user pools.
+func TestStopAll(t *testing.T) {
+	tests.ResetLog()
+	defer tests.DisplayLog()
+
+	t.Log("Given the need to stop several TCP values that share pools.")
+	{
+		poolCfg := pool.Config{
+			MinRoutines: func() int { return 2 },
+			MaxRoutines: func() int { return 1000 },
+		}
+
+		recv, err := pool.New("traceID", "Test-Recv", poolCfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a work pool for the recv.", tests.Failed, err)
+		}
+
+		send, err := pool.New("traceID", "Test-Send", poolCfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a work pool for the send.", tests.Failed, err)
+		}
+
+		// Create a configuration.
+		cfg := tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  tcpReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptUserPool: tcp.OptUserPool{
+				RecvPool: recv,
+				SendPool: send,
+			},
+		}
+
+		var instances []*tcp.TCP
+		var conns []net.Conn
+
+		for i := 0; i < 2; i++ {
+			u, connector, err := tcp.NewInMemory("traceID", "TEST"+strconv.Itoa(i), cfg)
+			if err != nil {
+				t.Fatal("\tShould be able to create a new in-memory TCP listener.", tests.Failed, err)
+			}
+			t.Log("\tShould be able to create a new in-memory TCP listener.", tests.Success)
+
+			if err := u.Start("traceID"); err != nil {
+				t.Fatal("\tShould be able to start the TCP listener.", tests.Failed, err)
+			}
+			t.Log("\tShould be able to start the TCP listener.", tests.Success)
+
+			conn, err := connector.Connect()
+			if err != nil {
+				t.Fatal("\tShould be able to connect in memory.", tests.Failed, err)
+			}
+			t.Log("\tShould be able to connect in memory.", tests.Success)
+
+			defer conn.Close()
+
+			// Wait for the connection to join.
+			for i := 0; len(u.Connections()) == 0; i++ {
+				if i == 100 {
+					t.Fatal("\tShould see the connection join.", tests.Failed)
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			t.Log("\tShould see the connection join.", tests.Success)
+
+			instances = append(instances, u)
+			conns = append(conns, conn)
+		}
+
+		if err := tcp.StopAll("traceID", instances...); err != nil {
+			t.Fatal("\tShould be able to stop all the TCP values.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to stop all the TCP values.", tests.Success)
+
+		for i, u := range instances {
+			if u.Running() {
+				t.Fatal("\tShould not be running after StopAll.", tests.Failed, i)
+			}
+
+			if _, err := conns[i].Read(make([]byte, 1)); err == nil {
+				t.Fatal("\tShould close the client connections.", tests.Failed, i)
+			}
+		}
+		t.Log("\tShould not be running after StopAll.", tests.Success)
+		t.Log("\tShould close the client connections.", tests.Success)
+
+		if !recv.IsRunning() || !send.IsRunning() {
+			t.Fatal("\tShould leave the shared pools running.", tests.Failed)
+		}
+		t.Log("\tShould leave the shared pools running.", tests.Success)
+
+		recv.Shutdown("traceID")
+		send.Shutdown("traceID")
+
+		if err := tcp.StopAll("traceID", instances...); err == nil {
+			t.Fatal("\tShould report the TCP values were already stopped.", tests.Failed)
+		}
+		t.Log("\tShould report the TCP values were already stopped.", tests.Success)
+	}
+}
+
+// TestAdmitFunc tests connections can be turned away by the user.
+func TestAdmitFunc(t *testing.T) {
+	tests.ResetLog()
+	defer tests.DisplayLog()
+
+	t.Log("Given the need to decide if there is capacity for each connection.")
+	{
+		var admit int32
+		reasons := make(chan string, 1)
+
+		// Create a configuration.
+		cfg := tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  tcpReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptIntPool: tcp.OptIntPool{
+				RecvMinPoolSize: func() int { return 2 },
+				RecvMaxPoolSize: func() int { return 1000 },
+				SendMinPoolSize: func() int { return 2 },
+				SendMaxPoolSize: func() int { return 1000 },
+			},
+
+			OptAdmit: tcp.OptAdmit{
+				AdmitFunc: func(remoteAddr string) bool {
+					return atomic.LoadInt32(&admit) == 1
+				},
+			},
+
+			OptDrop: tcp.OptDrop{
+				OnDrop: func(reason string, remoteAddr string) {
+					reasons <- reason
+				},
+			},
+		}
+
+		// Create a new in-memory TCP value.
+		u, connector, err := tcp.NewInMemory("traceID", "TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new in-memory TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to create a new in-memory TCP listener.", tests.Success)
+
+		// Start accepting client data.
+		if err := u.Start("traceID"); err != nil {
+			t.Fatal("\tShould be able to start the TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to start the TCP listener.", tests.Success)
+
+		defer u.Stop("traceID")
+
+		conn, err := connector.Connect()
+		if err != nil {
+			t.Fatal("\tShould be able to connect in memory.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to connect in memory.", tests.Success)
+
+		if _, err := conn.Read(make([]byte, 1)); err == nil {
+			t.Fatal("\tShould drop the connection that isn't admitted.", tests.Failed)
+		}
+		t.Log("\tShould drop the connection that isn't admitted.", tests.Success)
+
+		if reason := <-reasons; reason != tcp.DropReasonAdmit {
+			t.Fatal("\tShould report the admit reason.", tests.Failed, reason)
+		}
+		t.Log("\tShould report the admit reason.", tests.Success)
+
+		if n := u.Stats().NotAdmitted; n != 1 {
+			t.Fatal("\tShould count the connection that wasn't admitted.", tests.Failed, n)
+		}
+		t.Log("\tShould count the connection that wasn't admitted.", tests.Success)
+
+		if n := u.Stats().Drops[tcp.DropReasonAdmit]; n != 1 {
+			t.Fatal("\tShould count the drop by its reason.", tests.Failed, n)
+		}
+		t.Log("\tShould count the drop by its reason.", tests.Success)
+
+		atomic.StoreInt32(&admit, 1)
+
+		if conn, err = connector.Connect(); err != nil {
+			t.Fatal("\tShould be able to connect in memory.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to connect in memory.", tests.Success)
+
+		defer conn.Close()
+
+		go conn.Write([]byte("Hello\n"))
+
+		bufReader := bufio.NewReader(conn)
+		if response, err := bufReader.ReadString('\n'); err != nil || response != "GOT IT\n" {
+			t.Fatal("\tShould receive the string \"GOT IT\" once admitted.", tests.Failed, response, err)
+		}
+		t.Log("\tShould receive the string \"GOT IT\" once admitted.", tests.Success)
+	}
+}
+
+// TestUpgradeHandlers tests a connection can switch handlers mid stream.
+func TestUpgradeHandlers(t *testing.T) {
+	tests.ResetLog()
+	defer tests.DisplayLog()
+
+	t.Log("Given the need to upgrade a connection to TLS with STARTTLS.")
+	{
+		cert, err := newCertificate("good.example")
+		if err != nil {
+			t.Fatal("\tShould be able to create a certificate.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to create a certificate.", tests.Success)
+
+		// Create a configuration.
+		cfg := tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  tcpStartTLSReqHandler{cfg: &tls.Config{Certificates: []tls.Certificate{cert}}},
+			RespHandler: tcpRespHandler{},
+
+			OptIntPool: tcp.OptIntPool{
+				RecvMinPoolSize: func() int { return 2 },
+				RecvMaxPoolSize: func() int { return 1000 },
+				SendMinPoolSize: func() int { return 2 },
+				SendMaxPoolSize: func() int { return 1000 },
+			},
+		}
+
+		// Create a new in-memory TCP value.
+		u, connector, err := tcp.NewInMemory("traceID", "TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new in-memory TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to create a new in-memory TCP listener.", tests.Success)
+
+		// Start accepting client data.
+		if err := u.Start("traceID"); err != nil {
+			t.Fatal("\tShould be able to start the TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to start the TCP listener.", tests.Success)
+
+		defer u.Stop("traceID")
+
+		conn, err := connector.Connect()
+		if err != nil {
+			t.Fatal("\tShould be able to connect in memory.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to connect in memory.", tests.Success)
+
+		defer conn.Close()
+
+		go conn.Write([]byte("STARTTLS\n"))
+
+		bufReader := bufio.NewReader(conn)
+		if response, err := bufReader.ReadString('\n'); err != nil || response != "OK\n" {
+			t.Fatal("\tShould receive the string \"OK\" in plain text.", tests.Failed, response, err)
+		}
+		t.Log("\tShould receive the string \"OK\" in plain text.", tests.Success)
+
+		tc := tls.Client(conn, &tls.Config{ServerName: "good.example", InsecureSkipVerify: true})
+		if err := tc.Handshake(); err != nil {
+			t.Fatal("\tShould be able to complete the TLS handshake.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to complete the TLS handshake.", tests.Success)
+
+		go tc.Write([]byte("Hello\n"))
+
+		bufReader = bufio.NewReader(tc)
+		if response, err := bufReader.ReadString('\n'); err != nil || response != "GOT IT\n" {
+			t.Fatal("\tShould receive the string \"GOT IT\" over TLS.", tests.Failed, response, err)
+		}
+		t.Log("\tShould receive the string \"GOT IT\" over TLS.", tests.Success)
+	}
+}
+
+// TestOnAcceptExit tests the user is told when the accept routine stops.
+func TestOnAcceptExit(t *testing.T) {
+	tests.ResetLog()
+	defer tests.DisplayLog()
+
+	t.Log("Given the need to know when the accept routine has stopped.")
+	{
+		exits := make(chan error, 2)
+
+		// Create a configuration.
+		cfg := tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  tcpReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptIntPool: tcp.OptIntPool{
+				RecvMinPoolSize: func() int { return 2 },
+				RecvMaxPoolSize: func() int { return 1000 },
+				SendMinPoolSize: func() int { return 2 },
+				SendMaxPoolSize: func() int { return 1000 },
+			},
+
+			OptAcceptExit: tcp.OptAcceptExit{
+				OnAcceptExit: func(err error) {
+					exits <- err
+				},
+			},
+		}
+
+		start := func() *tcp.TCP {
+			u, _, err := tcp.NewInMemory("traceID", "TEST", cfg)
+			if err != nil {
+				t.Fatal("\tShould be able to create a new in-memory TCP listener.", tests.Failed, err)
+			}
+			t.Log("\tShould be able to create a new in-memory TCP listener.", tests.Success)
+
+			if err := u.Start("traceID"); err != nil {
+				t.Fatal("\tShould be able to start the TCP listener.", tests.Failed, err)
+			}
+			t.Log("\tShould be able to start the TCP listener.", tests.Success)
+
+			return u
+		}
+
+		u := start()
+		if err := u.Stop("traceID"); err != nil {
+			t.Fatal("\tShould be able to stop the TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to stop the TCP listener.", tests.Success)
+
+		if err := <-exits; err != nil {
+			t.Fatal("\tShould report a nil error on shutdown.", tests.Failed, err)
+		}
+		t.Log("\tShould report a nil error on shutdown.", tests.Success)
+
+		// Fail the listener and every attempt to re-establish it.
+		listenErr := errors.New("listen failed")
+
+		u = start()
+		u.FailListen(listenErr)
+		u.CloseListener()
+
+		select {
+		case err := <-u.AcceptErrors():
+			if err == nil {
+				t.Fatal("\tShould receive the accept error.", tests.Failed)
+			}
+			t.Log("\tShould receive the accept error.", tests.Success)
+
+		case <-time.After(time.Second):
+			t.Fatal("\tShould receive the accept error.", tests.Failed)
+		}
+
+		select {
+		case err := <-exits:
+			if err != listenErr {
+				t.Fatal("\tShould report the error that stopped the routine.", tests.Failed, err)
+			}
+			t.Log("\tShould report the error that stopped the routine.", tests.Success)
+
+		case <-time.After(time.Second):
+			t.Fatal("\tShould report the error that stopped the routine.", tests.Failed)
+		}
+
+		if u.Running() {
+			t.Fatal("\tShould not be running once the routine has stopped.", tests.Failed)
+		}
+		t.Log("\tShould not be running once the routine has stopped.", tests.Success)
+
+		select {
+		case err := <-exits:
+			t.Fatal("\tShould only report once.", tests.Failed, err)
+		default:
+			t.Log("\tShould only report once.", tests.Success)
+		}
+	}
+}
+
+// TestStartSpan tests spans are started around processing and writing.
+func TestStartSpan(t *testing.T) {
+	tests.ResetLog()
+	defer tests.DisplayLog()
+
+	t.Log("Given the need to trace requests and responses.")
+	{
+		type parentKey struct{}
+
+		type span struct {
+			name   string
+			parent interface{}
+			info   tcp.SpanInfo
+		}
+
+		spans := make(chan span, 2)
+
+		// Create a configuration.
+		cfg := tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  tcpEchoReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptIntPool: tcp.OptIntPool{
+				RecvMinPoolSize: func() int { return 2 },
+				RecvMaxPoolSize: func() int { return 1000 },
+				SendMinPoolSize: func() int { return 2 },
+				SendMaxPoolSize: func() int { return 1000 },
+			},
+
+			OptSpan: tcp.OptSpan{
+				StartSpan: func(ctx context.Context, name string) (context.Context, func()) {
+					info, _ := tcp.SpanInfoFromContext(ctx)
+					parent := ctx.Value(parentKey{})
+
+					return context.WithValue(ctx, parentKey{}, name), func() {
+						spans <- span{name: name, parent: parent, info: info}
+					}
+				},
+			},
+		}
+
+		// Create a new in-memory TCP value.
+		u, connector, err := tcp.NewInMemory("traceID", "TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new in-memory TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to create a new in-memory TCP listener.", tests.Success)
+
+		// Start accepting client data.
+		if err := u.Start("traceID"); err != nil {
+			t.Fatal("\tShould be able to start the TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to start the TCP listener.", tests.Success)
+
+		defer u.Stop("traceID")
+
+		conn, err := connector.Connect()
+		if err != nil {
+			t.Fatal("\tShould be able to connect in memory.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to connect in memory.", tests.Success)
+
+		defer conn.Close()
+
+		go conn.Write([]byte("Hello\n"))
+
+		bufReader := bufio.NewReader(conn)
+		if _, err := bufReader.ReadString('\n'); err != nil {
+			t.Fatal("\tShould be able to read the response from the connection.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to read the response from the connection.", tests.Success)
+
+		// The spans can end in either order.
+		got := make(map[string]span)
+		for i := 0; i < 2; i++ {
+			select {
+			case s := <-spans:
+				got[s.name] = s
+			case <-time.After(time.Second):
+				t.Fatal("\tShould end both spans.", tests.Failed, got)
+			}
+		}
+		t.Log("\tShould end both spans.", tests.Success)
+
+		addr := conn.LocalAddr().String()
+
+		if s := got[tcp.SpanProcess]; s.info.RemoteAddr != addr || s.info.Seq != 1 || s.parent != nil {
+			t.Fatal("\tShould start a span for processing the request.", tests.Failed, s)
+		}
+		t.Log("\tShould start a span for processing the request.", tests.Success)
+
+		if s := got[tcp.SpanWrite]; s.info.RemoteAddr != addr || s.info.Seq != 1 || s.parent != tcp.SpanProcess {
+			t.Fatal("\tShould start a child span for writing the response.", tests.Failed, s)
+		}
+		t.Log("\tShould start a child span for writing the response.", tests.Success)
+	}
+}
+
+// TestMaxBufferedBytes tests responses are rejected while too many bytes
+// are buffered.
+func TestMaxBufferedBytes(t *testing.T) {
+	tests.ResetLog()
+	defer tests.DisplayLog()
+
+	t.Log("Given the need to bound the bytes buffered across connections.")
+	{
+		h := tcpCtxReqHandler{
+			started:   make(chan struct{}),
+			cancelled: make(chan struct{}),
+		}
+
+		// Create a configuration.
+		cfg := tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  h,
+			RespHandler: tcpRespHandler{},
+
+			OptIntPool: tcp.OptIntPool{
+				RecvMinPoolSize: func() int { return 2 },
+				RecvMaxPoolSize: func() int { return 1000 },
+				SendMinPoolSize: func() int { return 2 },
+				SendMaxPoolSize: func() int { return 1000 },
+			},
+
+			OptBackpressure: tcp.OptBackpressure{
+				MaxBufferedBytes: 6,
+			},
+		}
+
+		// Create a new in-memory TCP value.
+		u, connector, err := tcp.NewInMemory("traceID", "TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new in-memory TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to create a new in-memory TCP listener.", tests.Success)
+
+		// Start accepting client data.
+		if err := u.Start("traceID"); err != nil {
+			t.Fatal("\tShould be able to start the TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to start the TCP listener.", tests.Success)
+
+		defer u.Stop("traceID")
+
+		conn, err := connector.Connect()
+		if err != nil {
+			t.Fatal("\tShould be able to connect in memory.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to connect in memory.", tests.Success)
+
+		// The request is buffered until processing finishes, which is
+		// when the connection is dropped.
+		conn.Write([]byte("Hello\n"))
+		<-h.started
+
+		if n := u.Stats().BufferedBytes; n != 6 {
+			t.Fatal("\tShould report the request as buffered.", tests.Failed, n)
+		}
+		t.Log("\tShould report the request as buffered.", tests.Success)
+
+		resp := tcp.Response{
+			TCPAddr: conn.LocalAddr().(*net.TCPAddr),
+			Data:    []byte("GOT IT\n"),
+			Length:  7,
+		}
+
+		if err := u.Do("traceID", &resp); err != tcp.ErrBufferFull {
+			t.Fatal("\tShould reject a response while the buffer is full.", tests.Failed, err)
+		}
+		t.Log("\tShould reject a response while the buffer is full.", tests.Success)
+
+		defer conn.Close()
+
+		u.ResetConnections("traceID")
+		<-h.cancelled
+
+		for i := 0; u.Stats().BufferedBytes != 0; i++ {
+			if i == 100 {
+				t.Fatal("\tShould release the buffered bytes once processed.", tests.Failed, u.Stats().BufferedBytes)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Log("\tShould release the buffered bytes once processed.", tests.Success)
+	}
+}
+
+// TestMaxPendingBytesPerConn tests responses are rejected while a client
+// has too many bytes waiting to be written.
+func TestMaxPendingBytesPerConn(t *testing.T) {
+	tests.ResetLog()
+	defer tests.DisplayLog()
+
+	t.Log("Given the need to bound the bytes waiting to be written to a connection.")
+	{
+		// Create a configuration.
+		cfg := tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  tcpReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptIntPool: tcp.OptIntPool{
+				RecvMinPoolSize: func() int { return 2 },
+				RecvMaxPoolSize: func() int { return 1000 },
+				SendMinPoolSize: func() int { return 2 },
+				SendMaxPoolSize: func() int { return 1000 },
+			},
+
+			OptBackpressure: tcp.OptBackpressure{
+				MaxPendingBytesPerConn: 10,
+			},
+		}
+
+		// Create a new in-memory TCP value.
+		u, connector, err := tcp.NewInMemory("traceID", "TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new in-memory TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to create a new in-memory TCP listener.", tests.Success)
+
+		// Start accepting client data.
+		if err := u.Start("traceID"); err != nil {
+			t.Fatal("\tShould be able to start the TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to start the TCP listener.", tests.Success)
+
+		defer u.Stop("traceID")
+
+		conn, err := connector.Connect()
+		if err != nil {
+			t.Fatal("\tShould be able to connect in memory.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to connect in memory.", tests.Success)
+
+		defer conn.Close()
+
+		for i := 0; len(u.Connections()) != 1; i++ {
+			if i == 100 {
+				t.Fatal("\tShould have the connection joined.", tests.Failed)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Log("\tShould have the connection joined.", tests.Success)
+
+		addr := conn.LocalAddr().(*net.TCPAddr)
+		newResp := func() *tcp.Response {
+			return &tcp.Response{
+				TCPAddr: addr,
+				Data:    []byte("GOT IT\n"),
+				Length:  7,
+			}
+		}
+
+		// Nothing reads the in-memory connection yet, so the first
+		// response is stuck being written.
+		if err := u.Do("traceID", newResp()); err != nil {
+			t.Fatal("\tShould be able to send a response under the limit.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to send a response under the limit.", tests.Success)
+
+		if n := u.Connections()[0].PendingBytes; n != 7 {
+			t.Fatal("\tShould report the bytes waiting to be written.", tests.Failed, n)
+		}
+		t.Log("\tShould report the bytes waiting to be written.", tests.Success)
+
+		if err := u.Do("traceID", newResp()); err != tcp.ErrConnBufferFull {
+			t.Fatal("\tShould reject a response that would exceed the limit.", tests.Failed, err)
+		}
+		t.Log("\tShould reject a response that would exceed the limit.", tests.Success)
+
+		bufReader := bufio.NewReader(conn)
+		if response, err := bufReader.ReadString('\n'); err != nil || response != "GOT IT\n" {
+			t.Fatal("\tShould receive the string \"GOT IT\".", tests.Failed, response, err)
+		}
+		t.Log("\tShould receive the string \"GOT IT\".", tests.Success)
+
+		for i := 0; u.Connections()[0].PendingBytes != 0; i++ {
+			if i == 100 {
+				t.Fatal("\tShould release the bytes once written.", tests.Failed, u.Connections()[0].PendingBytes)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Log("\tShould release the bytes once written.", tests.Success)
+
+		if err := u.Do("traceID", newResp()); err != nil {
+			t.Fatal("\tShould be able to send a response once the bytes are written.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to send a response once the bytes are written.", tests.Success)
+
+		if response, err := bufReader.ReadString('\n'); err != nil || response != "GOT IT\n" {
+			t.Fatal("\tShould receive the string \"GOT IT\".", tests.Failed, response, err)
+		}
+		t.Log("\tShould receive the string \"GOT IT\".", tests.Success)
+	}
+}
+
+// TestWrapConn tests accepted connections can be wrapped.
+func TestWrapConn(t *testing.T) {
+	tests.ResetLog()
+	defer tests.DisplayLog()
+
+	t.Log("Given the need to wrap accepted connections.")
+	{
+		wrapped := make(chan *tcpCountConn, 1)
+
+		// Create a configuration.
+		cfg := tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  tcpReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptIntPool: tcp.OptIntPool{
+				RecvMinPoolSize: func() int { return 2 },
+				RecvMaxPoolSize: func() int { return 1000 },
+				SendMinPoolSize: func() int { return 2 },
+				SendMaxPoolSize: func() int { return 1000 },
+			},
+
+			OptWrap: tcp.OptWrap{
+				WrapConn: func(conn net.Conn) net.Conn {
+					c := tcpCountConn{Conn: conn}
+					wrapped <- &c
+					return &c
+				},
+			},
+		}
+
+		// Create a new in-memory TCP value.
+		u, connector, err := tcp.NewInMemory("traceID", "TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new in-memory TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to create a new in-memory TCP listener.", tests.Success)
+
+		// Start accepting client data.
+		if err := u.Start("traceID"); err != nil {
+			t.Fatal("\tShould be able to start the TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to start the TCP listener.", tests.Success)
+
+		defer u.Stop("traceID")
+
+		conn, err := connector.Connect()
+		if err != nil {
+			t.Fatal("\tShould be able to connect in memory.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to connect in memory.", tests.Success)
+
+		defer conn.Close()
+
+		go conn.Write([]byte("Hello\n"))
+
+		bufReader := bufio.NewReader(conn)
+		if response, err := bufReader.ReadString('\n'); err != nil || response != "GOT IT\n" {
+			t.Fatal("\tShould receive the string \"GOT IT\".", tests.Failed, response, err)
+		}
+		t.Log("\tShould receive the string \"GOT IT\".", tests.Success)
+
+		c := <-wrapped
+		if n := atomic.LoadInt64(&c.read); n != 6 {
+			t.Fatal("\tShould read the request through the wrapped connection.", tests.Failed, n)
+		}
+		t.Log("\tShould read the request through the wrapped connection.", tests.Success)
+
+		// The count is added once the write returns, which can be after
+		// the response has been read.
+		for i := 0; atomic.LoadInt64(&c.written) != 7; i++ {
+			if i == 100 {
+				t.Fatal("\tShould write the response through the wrapped connection.", tests.Failed, atomic.LoadInt64(&c.written))
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Log("\tShould write the response through the wrapped connection.", tests.Success)
+	}
+}
+
+// TestRateLimitBurst tests a burst of connections is accepted before the
+// rate limit applies.
+func TestRateLimitBurst(t *testing.T) {
+	tests.ResetLog()
+	defer tests.DisplayLog()
+
+	t.Log("Given the need to accept a burst of reconnecting clients.")
+	{
+		reasons := make(chan string, 1)
+
+		// Create a configuration.
+		cfg := tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  tcpReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptIntPool: tcp.OptIntPool{
+				RecvMinPoolSize: func() int { return 2 },
+				RecvMaxPoolSize: func() int { return 1000 },
+				SendMinPoolSize: func() int { return 2 },
+				SendMaxPoolSize: func() int { return 1000 },
+			},
+
+			OptRateLimit: tcp.OptRateLimit{
+				RateLimit:      func() time.Duration { return time.Minute },
+				RateLimitBurst: 3,
+			},
+
+			OptDrop: tcp.OptDrop{
+				OnDrop: func(reason string, remoteAddr string) {
+					reasons <- reason
+				},
+			},
+		}
+
+		// Create a new in-memory TCP value.
+		u, connector, err := tcp.NewInMemory("traceID", "TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new in-memory TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to create a new in-memory TCP listener.", tests.Success)
+
+		// Start accepting client data.
+		if err := u.Start("traceID"); err != nil {
+			t.Fatal("\tShould be able to start the TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to start the TCP listener.", tests.Success)
+
+		defer u.Stop("traceID")
+
+		if n := u.Stats().RateTokens; n != 3 {
+			t.Fatal("\tShould start with the whole burst available.", tests.Failed, n)
+		}
+		t.Log("\tShould start with the whole burst available.", tests.Success)
+
+		for i := 0; i < 3; i++ {
+			conn, err := connector.Connect()
+			if err != nil {
+				t.Fatal("\tShould be able to connect in memory.", tests.Failed, err)
+			}
+			t.Log("\tShould be able to connect in memory.", tests.Success)
+
+			defer conn.Close()
+
+			go conn.Write([]byte("Hello\n"))
+
+			bufReader := bufio.NewReader(conn)
+			if response, err := bufReader.ReadString('\n'); err != nil || response != "GOT IT\n" {
+				t.Fatal("\tShould accept the connections in the burst.", tests.Failed, i, response, err)
+			}
+			t.Log("\tShould accept the connections in the burst.", tests.Success)
+		}
+
+		conn, err := connector.Connect()
+		if err != nil {
+			t.Fatal("\tShould be able to connect in memory.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to connect in memory.", tests.Success)
+
+		if _, err := conn.Read(make([]byte, 1)); err == nil {
+			t.Fatal("\tShould drop the connection after the burst.", tests.Failed)
+		}
+		t.Log("\tShould drop the connection after the burst.", tests.Success)
+
+		if reason := <-reasons; reason != tcp.DropReasonRateLimit {
+			t.Fatal("\tShould report the rate limit reason.", tests.Failed, reason)
+		}
+		t.Log("\tShould report the rate limit reason.", tests.Success)
+
+		stats := u.Stats()
+		if stats.RateAllowed != 3 || stats.RateThrottled != 1 || stats.RateTokens != 0 {
+			t.Fatal("\tShould count the connections allowed and throttled.", tests.Failed, stats.RateAllowed, stats.RateThrottled, stats.RateTokens)
+		}
+		t.Log("\tShould count the connections allowed and throttled.", tests.Success)
+	}
+}
+
+// TestResponseSent tests the write of a response can be waited on with a
+// channel.
+func TestResponseSent(t *testing.T) {
+	tests.ResetLog()
+	defer tests.DisplayLog()
+
+	t.Log("Given the need to wait for a response to be written.")
+	{
+		// Create a configuration.
+		cfg := tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  tcpReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptIntPool: tcp.OptIntPool{
+				RecvMinPoolSize: func() int { return 2 },
+				RecvMaxPoolSize: func() int { return 1000 },
+				SendMinPoolSize: func() int { return 2 },
+				SendMaxPoolSize: func() int { return 1000 },
+			},
+		}
+
+		// Create a new in-memory TCP value.
+		u, connector, err := tcp.NewInMemory("traceID", "TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new in-memory TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to create a new in-memory TCP listener.", tests.Success)
+
+		// Start accepting client data.
+		if err := u.Start("traceID"); err != nil {
+			t.Fatal("\tShould be able to start the TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to start the TCP listener.", tests.Success)
+
+		defer u.Stop("traceID")
+
+		conn, err := connector.Connect()
+		if err != nil {
+			t.Fatal("\tShould be able to connect in memory.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to connect in memory.", tests.Success)
+
+		defer conn.Close()
+
+		// Wait for the connection to join.
+		for i := 0; len(u.Connections()) == 0; i++ {
+			if i == 100 {
+				t.Fatal("\tShould see the connection join.", tests.Failed)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Log("\tShould see the connection join.", tests.Success)
+
+		go bufio.NewReader(conn).ReadString('\n')
+
+		resp := tcp.Response{
+			TCPAddr: conn.LocalAddr().(*net.TCPAddr),
+			Data:    []byte("PUSH\n"),
+			Length:  5,
+			Sent:    make(chan error, 1),
+		}
+
+		if err := u.Do("traceID", &resp); err != nil {
+			t.Fatal("\tShould be able to send the response.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to send the response.", tests.Success)
+
+		select {
+		case err := <-resp.Sent:
+			if err != nil {
+				t.Fatal("\tShould report the response was written.", tests.Failed, err)
+			}
+			t.Log("\tShould report the response was written.", tests.Success)
+
+		case <-time.After(time.Second):
+			t.Fatal("\tShould report the response was written.", tests.Failed, "timeout")
+		}
+	}
+}
+
+// TestIPv6 tests requests from ipv6 clients are answered.
+func TestIPv6(t *testing.T) {
+	tests.ResetLog()
+	defer tests.DisplayLog()
+
+	t.Log("Given the need to accept ipv6 connections.")
+	{
+		// Create a configuration.
+		cfg := tcp.Config{
+			NetType: "tcp6",
+			Addr:    "[::1]:0",
+
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  tcpReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptIntPool: tcp.OptIntPool{
+				RecvMinPoolSize: func() int { return 2 },
+				RecvMaxPoolSize: func() int { return 1000 },
+				SendMinPoolSize: func() int { return 2 },
+				SendMaxPoolSize: func() int { return 1000 },
+			},
+		}
+
+		// Create a new TCP value.
+		u, err := tcp.New("traceID", "TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to create a new TCP listener.", tests.Success)
+
+		// Start accepting client data.
+		if err := u.Start("traceID"); err != nil {
+			t.Skip("\tShould be able to listen on the ipv6 loopback.", err)
+		}
+		t.Log("\tShould be able to start the TCP listener.", tests.Success)
+
+		defer u.Stop("traceID")
+
+		conn, err := net.Dial("tcp6", u.Addr().String())
+		if err != nil {
+			t.Fatal("\tShould be able to dial a new TCP connection.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to dial a new TCP connection.", tests.Success)
+
+		defer conn.Close()
+
+		conn.Write([]byte("Hello\n"))
+
+		bufReader := bufio.NewReader(conn)
+		if response, err := bufReader.ReadString('\n'); err != nil || response != "GOT IT\n" {
+			t.Fatal("\tShould receive the string \"GOT IT\".", tests.Failed, response, err)
+		}
+		t.Log("\tShould receive the string \"GOT IT\".", tests.Success)
+	}
+}
+
+// TestNamedAddr tests connections with an address that isn't a host and
+// port are served.
+func TestNamedAddr(t *testing.T) {
+	tests.ResetLog()
+	defer tests.DisplayLog()
+
+	t.Log("Given the need to serve connections without a host and port.")
+	{
+		// Create a configuration.
+		cfg := tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  tcpReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptIntPool: tcp.OptIntPool{
+				RecvMinPoolSize: func() int { return 2 },
+				RecvMaxPoolSize: func() int { return 1000 },
+				SendMinPoolSize: func() int { return 2 },
+				SendMaxPoolSize: func() int { return 1000 },
+			},
+
+			OptWrap: tcp.OptWrap{
+				WrapConn: func(conn net.Conn) net.Conn {
+					return tcpNamedConn{Conn: conn, name: "client-1"}
+				},
+			},
+		}
+
+		// Create a new in-memory TCP value.
+		u, connector, err := tcp.NewInMemory("traceID", "TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new in-memory TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to create a new in-memory TCP listener.", tests.Success)
+
+		// Start accepting client data.
+		if err := u.Start("traceID"); err != nil {
+			t.Fatal("\tShould be able to start the TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to start the TCP listener.", tests.Success)
+
+		defer u.Stop("traceID")
+
+		conn, err := connector.Connect()
+		if err != nil {
+			t.Fatal("\tShould be able to connect in memory.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to connect in memory.", tests.Success)
+
+		defer conn.Close()
+
+		// Wait for the connection to join.
+		for i := 0; len(u.Connections()) == 0; i++ {
+			if i == 100 {
+				t.Fatal("\tShould see the connection join.", tests.Failed)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Log("\tShould see the connection join.", tests.Success)
+
+		if addr := u.Connections()[0].Addr; addr != "client-1" {
+			t.Fatal("\tShould report the named address.", tests.Failed, addr)
+		}
+		t.Log("\tShould report the named address.", tests.Success)
+
+		go u.DoMulti("traceID", []string{"client-1"}, []byte("MULTI\n"))
+
+		bufReader := bufio.NewReader(conn)
+		if response, err := bufReader.ReadString('\n'); err != nil || response != "MULTI\n" {
+			t.Fatal("\tShould receive the string \"MULTI\".", tests.Failed, response, err)
+		}
+		t.Log("\tShould receive the string \"MULTI\".", tests.Success)
+	}
+}
+
+// TestMaxInFlightPerConn tests reading from a connection pauses while too
+// many of its requests are unprocessed.
+func TestMaxInFlightPerConn(t *testing.T) {
+	tests.ResetLog()
+	defer tests.DisplayLog()
+
+	t.Log("Given the need to stop one connection from monopolizing the recv pool.")
+	{
+		h := tcpHoldReqHandler{
+			started: make(chan uint64, 2),
+			release: make(chan struct{}),
+		}
+
+		// Create a configuration.
+		cfg := tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  h,
+			RespHandler: tcpRespHandler{},
+
+			OptIntPool: tcp.OptIntPool{
+				RecvMinPoolSize: func() int { return 2 },
+				RecvMaxPoolSize: func() int { return 1000 },
+				SendMinPoolSize: func() int { return 2 },
+				SendMaxPoolSize: func() int { return 1000 },
+			},
+
+			OptBackpressure: tcp.OptBackpressure{
+				MaxInFlightPerConn: 1,
+			},
+		}
+
+		// Create a new in-memory TCP value.
+		u, connector, err := tcp.NewInMemory("traceID", "TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new in-memory TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to create a new in-memory TCP listener.", tests.Success)
+
+		// Start accepting client data.
+		if err := u.Start("traceID"); err != nil {
+			t.Fatal("\tShould be able to start the TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to start the TCP listener.", tests.Success)
+
+		defer u.Stop("traceID")
+
+		conn, err := connector.Connect()
+		if err != nil {
+			t.Fatal("\tShould be able to connect in memory.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to connect in memory.", tests.Success)
+
+		defer conn.Close()
+
+		go conn.Write([]byte("Hello\nHello\n"))
+
+		if seq := <-h.started; seq != 1 {
+			t.Fatal("\tShould process the first request.", tests.Failed, seq)
+		}
+		t.Log("\tShould process the first request.", tests.Success)
+
+		select {
+		case seq := <-h.started:
+			t.Fatal("\tShould not read the second request while the first is in flight.", tests.Failed, seq)
+		case <-time.After(100 * time.Millisecond):
+			t.Log("\tShould not read the second request while the first is in flight.", tests.Success)
+		}
+
+		if n := u.Connections()[0].InFlight; n != 1 {
+			t.Fatal("\tShould report one request in flight.", tests.Failed, n)
+		}
+		t.Log("\tShould report one request in flight.", tests.Success)
+
+		bufReader := bufio.NewReader(conn)
+
+		h.release <- struct{}{}
+		if response, err := bufReader.ReadString('\n'); err != nil || response != "GOT IT\n" {
+			t.Fatal("\tShould receive the string \"GOT IT\".", tests.Failed, response, err)
+		}
+		t.Log("\tShould receive the string \"GOT IT\".", tests.Success)
+
+		if seq := <-h.started; seq != 2 {
+			t.Fatal("\tShould process the second request once the first is done.", tests.Failed, seq)
+		}
+		t.Log("\tShould process the second request once the first is done.", tests.Success)
+
+		h.release <- struct{}{}
+		if response, err := bufReader.ReadString('\n'); err != nil || response != "GOT IT\n" {
+			t.Fatal("\tShould receive the string \"GOT IT\".", tests.Failed, response, err)
+		}
+		t.Log("\tShould receive the string \"GOT IT\".", tests.Success)
+	}
+}
+
+// TestSwapPools tests the pools can be replaced without dropping the
+// connections.
+func TestSwapPools(t *testing.T) {
+	tests.ResetLog()
+	defer tests.DisplayLog()
+
+	t.Log("Given the need to replace the pools at runtime.")
+	{
+		// Create a configuration.
+		cfg := tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  tcpReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptIntPool: tcp.OptIntPool{
+				RecvMinPoolSize: func() int { return 2 },
+				RecvMaxPoolSize: func() int { return 1000 },
+				SendMinPoolSize: func() int { return 2 },
+				SendMaxPoolSize: func() int { return 1000 },
+			},
+		}
+
+		// Create a new in-memory TCP value.
+		u, connector, err := tcp.NewInMemory("traceID", "TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new in-memory TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to create a new in-memory TCP listener.", tests.Success)
+
+		// Start accepting client data.
+		if err := u.Start("traceID"); err != nil {
+			t.Fatal("\tShould be able to start the TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to start the TCP listener.", tests.Success)
+
+		defer u.Stop("traceID")
+
+		conn, err := connector.Connect()
+		if err != nil {
+			t.Fatal("\tShould be able to connect in memory.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to connect in memory.", tests.Success)
+
+		defer conn.Close()
+
+		bufReader := bufio.NewReader(conn)
+		roundTrip := func() {
+			go conn.Write([]byte("Hello\n"))
+
+			if response, err := bufReader.ReadString('\n'); err != nil || response != "GOT IT\n" {
+				t.Fatal("\tShould receive the string \"GOT IT\".", tests.Failed, response, err)
+			}
+			t.Log("\tShould receive the string \"GOT IT\".", tests.Success)
+		}
+
+		roundTrip()
+
+		poolCfg := pool.Config{
+			MinRoutines: func() int { return 4 },
+			MaxRoutines: func() int { return 1000 },
+		}
+
+		recv, err := pool.New("traceID", "Test-Recv", poolCfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a work pool for the recv.", tests.Failed, err)
+		}
+		defer recv.Shutdown("traceID")
+
+		send, err := pool.New("traceID", "Test-Send", poolCfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a work pool for the send.", tests.Failed, err)
+		}
+		defer send.Shutdown("traceID")
+
+		if err := u.SwapPools("traceID", nil, send); err != tcp.ErrInvalidPoolConfiguration {
+			t.Fatal("\tShould not be able to swap in a nil pool.", tests.Failed, err)
+		}
+		t.Log("\tShould not be able to swap in a nil pool.", tests.Success)
+
+		if err := u.SwapPools("traceID", recv, send); err != nil {
+			t.Fatal("\tShould be able to swap the pools.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to swap the pools.", tests.Success)
+
+		roundTrip()
+
+		// The work is counted once Process returns, which can be after
+		// the response has been read.
+		for i := 0; recv.Stats().Executed != 1; i++ {
+			if i == 100 {
+				t.Fatal("\tShould process the request on the new recv pool.", tests.Failed, recv.Stats().Executed)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Log("\tShould process the request on the new recv pool.", tests.Success)
+
+		if n := len(u.Connections()); n != 1 {
+			t.Fatal("\tShould keep the connection.", tests.Failed, n)
+		}
+		t.Log("\tShould keep the connection.", tests.Success)
+	}
+}
+
+// TestConnTCPInfo tests the kernel TCP information for a connection can be
+// retrieved.
+func TestConnTCPInfo(t *testing.T) {
+	tests.ResetLog()
+	defer tests.DisplayLog()
+
+	t.Log("Given the need to diagnose the network health of a connection.")
+	{
+		// Create a configuration.
+		cfg := tcp.Config{
+			NetType: "tcp4",
+			Addr:    ":0",
+
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  tcpReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptIntPool: tcp.OptIntPool{
+				RecvMinPoolSize: func() int { return 2 },
+				RecvMaxPoolSize: func() int { return 1000 },
+				SendMinPoolSize: func() int { return 2 },
+				SendMaxPoolSize: func() int { return 1000 },
+			},
+		}
+
+		// Create a new TCP value.
+		u, err := tcp.New("traceID", "TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to create a new TCP listener.", tests.Success)
+
+		// Start accepting client data.
+		if err := u.Start("traceID"); err != nil {
+			t.Fatal("\tShould be able to start the TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to start the TCP listener.", tests.Success)
+
+		defer u.Stop("traceID")
+
+		conn, err := net.Dial("tcp4", u.Addr().String())
+		if err != nil {
+			t.Fatal("\tShould be able to dial a new TCP connection.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to dial a new TCP connection.", tests.Success)
+
+		defer conn.Close()
+
+		// A round trip guarantees the connection has joined.
+		conn.Write([]byte("Hello\n"))
+		if _, err := bufio.NewReader(conn).ReadString('\n'); err != nil {
+			t.Fatal("\tShould be able to read the response from the connection.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to read the response from the connection.", tests.Success)
+
+		if _, err := u.ConnTCPInfo("127.0.0.1:1"); err == nil {
+			t.Fatal("\tShould get an error for an unknown address.", tests.Failed)
+		}
+		t.Log("\tShould get an error for an unknown address.", tests.Success)
+
+		info, err := u.ConnTCPInfo(conn.LocalAddr().String())
+		if runtime.GOOS != "linux" || runtime.GOARCH == "386" {
+			if err != tcp.ErrTCPInfoNotSupported {
+				t.Fatal("\tShould report TCP info is not supported.", tests.Failed, err)
+			}
+			t.Log("\tShould report TCP info is not supported.", tests.Success)
+			return
+		}
+
+		if err != nil || info.RTT <= 0 || info.SendCwnd == 0 {
+			t.Fatal("\tShould get the TCP info for the connection.", tests.Failed, info, err)
+		}
+		t.Log("\tShould get the TCP info for the connection.", tests.Success)
+	}
+}
+
+// TestCloseReset tests a connection reset by the client is not reported
+// as a clean close.
+func TestCloseReset(t *testing.T) {
+	tests.ResetLog()
+	defer tests.DisplayLog()
+
+	t.Log("Given the need to tell a reset from a clean close.")
+	{
+		reasons := make(chan tcp.CloseReason, 1)
+
+		// Create a configuration.
+		cfg := tcp.Config{
+			NetType: "tcp4",
+			Addr:    ":0",
+
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  tcpReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptIntPool: tcp.OptIntPool{
+				RecvMinPoolSize: func() int { return 2 },
+				RecvMaxPoolSize: func() int { return 1000 },
+				SendMinPoolSize: func() int { return 2 },
+				SendMaxPoolSize: func() int { return 1000 },
+			},
+
+			OptDisconnect: tcp.OptDisconnect{
+				OnDisconnect: func(remoteAddr string, reason tcp.CloseReason) {
+					reasons <- reason
+				},
+			},
+		}
+
+		// Create a new TCP value.
+		u, err := tcp.New("traceID", "TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to create a new TCP listener.", tests.Success)
+
+		// Start accepting client data.
+		if err := u.Start("traceID"); err != nil {
+			t.Fatal("\tShould be able to start the TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to start the TCP listener.", tests.Success)
+
+		defer u.Stop("traceID")
+
+		conn, err := net.Dial("tcp4", u.Addr().String())
+		if err != nil {
+			t.Fatal("\tShould be able to dial a new TCP connection.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to dial a new TCP connection.", tests.Success)
+
+		// A round trip guarantees the connection has joined.
+		conn.Write([]byte("Hello\n"))
+		if _, err := bufio.NewReader(conn).ReadString('\n'); err != nil {
+			t.Fatal("\tShould be able to read the response from the connection.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to read the response from the connection.", tests.Success)
+
+		// Closing with no linger sends a reset instead of a fin.
+		conn.(*net.TCPConn).SetLinger(0)
+		conn.Close()
+
+		select {
+		case reason := <-reasons:
+			if reason != tcp.CloseReset || reason.Clean() {
+				t.Fatal("\tShould report the client reset the connection.", tests.Failed, reason)
+			}
+			t.Log("\tShould report the client reset the connection.", tests.Success)
+
+		case <-time.After(time.Second):
+			t.Fatal("\tShould report the client reset the connection.", tests.Failed)
+		}
+	}
+}
+
+// TestCloseAfterWrite tests the connection is closed once a final response
+// has been written.
+func TestCloseAfterWrite(t *testing.T) {
+	tests.ResetLog()
+	defer tests.DisplayLog()
+
+	t.Log("Given the need to send a final response and disconnect.")
+	{
+		reasons := make(chan tcp.CloseReason, 1)
+
+		// Create a configuration.
+		cfg := tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  tcpReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptIntPool: tcp.OptIntPool{
+				RecvMinPoolSize: func() int { return 2 },
+				RecvMaxPoolSize: func() int { return 1000 },
+				SendMinPoolSize: func() int { return 2 },
+				SendMaxPoolSize: func() int { return 1000 },
+			},
+
+			OptDisconnect: tcp.OptDisconnect{
+				OnDisconnect: func(remoteAddr string, reason tcp.CloseReason) {
+					reasons <- reason
+				},
+			},
+		}
+
+		// Create a new in-memory TCP value.
+		u, connector, err := tcp.NewInMemory("traceID", "TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new in-memory TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to create a new in-memory TCP listener.", tests.Success)
+
+		// Start accepting client data.
+		if err := u.Start("traceID"); err != nil {
+			t.Fatal("\tShould be able to start the TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to start the TCP listener.", tests.Success)
+
+		defer u.Stop("traceID")
+
+		conn, err := connector.Connect()
+		if err != nil {
+			t.Fatal("\tShould be able to connect in memory.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to connect in memory.", tests.Success)
+
+		defer conn.Close()
+
+		// Wait for the connection to join.
+		for i := 0; len(u.Connections()) == 0; i++ {
+			if i == 100 {
+				t.Fatal("\tShould see the connection join.", tests.Failed)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Log("\tShould see the connection join.", tests.Success)
+
+		resp := tcp.Response{
+			TCPAddr:         conn.LocalAddr().(*net.TCPAddr),
+			Data:            []byte("BYE\n"),
+			Length:          4,
+			CloseAfterWrite: true,
+		}
+
+		if err := u.Do("traceID", &resp); err != nil {
+			t.Fatal("\tShould be able to send the final response.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to send the final response.", tests.Success)
+
+		bufReader := bufio.NewReader(conn)
+		if response, err := bufReader.ReadString('\n'); err != nil || response != "BYE\n" {
+			t.Fatal("\tShould receive the final response before the close.", tests.Failed, response, err)
+		}
+		t.Log("\tShould receive the final response before the close.", tests.Success)
+
+		if _, err := bufReader.ReadByte(); err != io.EOF {
+			t.Fatal("\tShould see the connection closed.", tests.Failed, err)
+		}
+		t.Log("\tShould see the connection closed.", tests.Success)
+
+		if reason := <-reasons; reason != tcp.CloseDropped {
+			t.Fatal("\tShould report the server dropped the connection.", tests.Failed, reason)
+		}
+		t.Log("\tShould report the server dropped the connection.", tests.Success)
+	}
+}
+
+// TestOnLeak tests a leak is reported when there are too many connections.
+func TestOnLeak(t *testing.T) {
+	tests.ResetLog()
+	defer tests.DisplayLog()
+
+	t.Log("Given the need to detect connections that are not being removed.")
+	{
+		leaks := make(chan int, 2)
+
+		// Create a configuration.
+		cfg := tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  tcpReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptIntPool: tcp.OptIntPool{
+				RecvMinPoolSize: func() int { return 2 },
+				RecvMaxPoolSize: func() int { return 1000 },
+				SendMinPoolSize: func() int { return 2 },
+				SendMaxPoolSize: func() int { return 1000 },
+			},
+
+			OptLeak: tcp.OptLeak{
+				LeakThreshold: -1,
+				OnLeak: func(count int) {
+					leaks <- count
+				},
+			},
+		}
+
+		if _, _, err := tcp.NewInMemory("traceID", "TEST", cfg); err != tcp.ErrInvalidLeakThreshold {
+			t.Fatal("\tShould not accept a negative leak threshold.", tests.Failed, err)
+		}
+		t.Log("\tShould not accept a negative leak threshold.", tests.Success)
+
+		cfg.LeakThreshold = 1
+
+		// Create a new in-memory TCP value.
+		u, connector, err := tcp.NewInMemory("traceID", "TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new in-memory TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to create a new in-memory TCP listener.", tests.Success)
+
+		// Start accepting client data.
+		if err := u.Start("traceID"); err != nil {
+			t.Fatal("\tShould be able to start the TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to start the TCP listener.", tests.Success)
+
+		defer u.Stop("traceID")
+
+		for i := 0; i < 3; i++ {
+			conn, err := connector.Connect()
+			if err != nil {
+				t.Fatal("\tShould be able to connect in memory.", tests.Failed, err)
+			}
+			t.Log("\tShould be able to connect in memory.", tests.Success)
+
+			defer conn.Close()
+		}
+
+		// Wait for the connections to join.
+		for i := 0; len(u.Connections()) != 3; i++ {
+			if i == 100 {
+				t.Fatal("\tShould see the connections join.", tests.Failed)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Log("\tShould see the connections join.", tests.Success)
+
+		if count := <-leaks; count != 2 {
+			t.Fatal("\tShould report the leak once above the threshold.", tests.Failed, count)
+		}
+		t.Log("\tShould report the leak once above the threshold.", tests.Success)
+
+		select {
+		case count := <-leaks:
+			t.Fatal("\tShould only report the leak once.", tests.Failed, count)
+		default:
+			t.Log("\tShould only report the leak once.", tests.Success)
+		}
+	}
+}
+
+// TestSplitAddr tests client addresses are split into a host and port.
+func TestSplitAddr(t *testing.T) {
+	tests.ResetLog()
+	defer tests.DisplayLog()
+
+	addrs := []struct {
+		addr string
+		host string
+		port int
+		err  error
+	}{
+		{"127.0.0.1:8080", "127.0.0.1", 8080, nil},
+		{"[::1]:8080", "::1", 8080, nil},
+		{"[fe80::1%eth0]:8080", "fe80::1%eth0", 8080, nil},
+		{"[::ffff:10.0.0.1]:0", "::ffff:10.0.0.1", 0, nil},
+		{"localhost:80", "localhost", 80, nil},
+		{"::1", "", 0, tcp.ErrInvalidAddr},
+		{"127.0.0.1", "", 0, tcp.ErrInvalidAddr},
+		{"127.0.0.1:http", "", 0, tcp.ErrInvalidAddr},
+		{"127.0.0.1:70000", "", 0, tcp.ErrInvalidAddr},
+		{"[::1]:", "", 0, tcp.ErrInvalidAddr},
+	}
+
+	t.Log("Given the need to split client addresses into a host and port.")
+	{
+		for _, a := range addrs {
+			t.Logf("\tWhen splitting %q", a.addr)
+			{
+				host, port, err := tcp.SplitAddr(a.addr)
+				if host != a.host || port != a.port || err != a.err {
+					t.Fatal("\t\tShould get the expected host and port.", tests.Failed, host, port, err)
+				}
+				t.Log("\t\tShould get the expected host and port.", tests.Success)
+			}
+		}
+	}
+}
+
+// TestLoadShedFunc tests connections are dropped while the host is
+// overloaded.
+func TestLoadShedFunc(t *testing.T) {
+	tests.ResetLog()
+	defer tests.DisplayLog()
+
+	t.Log("Given the need to shed connections while the host is overloaded.")
+	{
+		overloaded := int32(1)
+		reasons := make(chan string, 1)
+
+		// Create a configuration.
+		cfg := tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  tcpReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptIntPool: tcp.OptIntPool{
+				RecvMinPoolSize: func() int { return 2 },
+				RecvMaxPoolSize: func() int { return 1000 },
+				SendMinPoolSize: func() int { return 2 },
+				SendMaxPoolSize: func() int { return 1000 },
+			},
+
+			OptAdmit: tcp.OptAdmit{
+				LoadShedFunc: func() bool {
+					return atomic.LoadInt32(&overloaded) == 1
+				},
+			},
+
+			OptDrop: tcp.OptDrop{
+				OnDrop: func(reason string, remoteAddr string) {
+					reasons <- reason
+				},
+			},
+		}
+
+		// Create a new in-memory TCP value.
+		u, connector, err := tcp.NewInMemory("traceID", "TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new in-memory TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to create a new in-memory TCP listener.", tests.Success)
+
+		// Start accepting client data.
+		if err := u.Start("traceID"); err != nil {
+			t.Fatal("\tShould be able to start the TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to start the TCP listener.", tests.Success)
+
+		defer u.Stop("traceID")
+
+		conn, err := connector.Connect()
+		if err != nil {
+			t.Fatal("\tShould be able to connect in memory.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to connect in memory.", tests.Success)
+
+		if _, err := conn.Read(make([]byte, 1)); err == nil {
+			t.Fatal("\tShould drop the connection while overloaded.", tests.Failed)
+		}
+		t.Log("\tShould drop the connection while overloaded.", tests.Success)
+
+		if reason := <-reasons; reason != tcp.DropReasonLoadShed {
+			t.Fatal("\tShould report the load shed reason.", tests.Failed, reason)
+		}
+		t.Log("\tShould report the load shed reason.", tests.Success)
+
+		if n := u.Stats().Drops[tcp.DropReasonLoadShed]; n != 1 {
+			t.Fatal("\tShould count the connection that was shed.", tests.Failed, n)
+		}
+		t.Log("\tShould count the connection that was shed.", tests.Success)
+
+		atomic.StoreInt32(&overloaded, 0)
+
+		if conn, err = connector.Connect(); err != nil {
+			t.Fatal("\tShould be able to connect in memory.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to connect in memory.", tests.Success)
+
+		defer conn.Close()
+
+		go conn.Write([]byte("Hello\n"))
+
+		bufReader := bufio.NewReader(conn)
+		if response, err := bufReader.ReadString('\n'); err != nil || response != "GOT IT\n" {
+			t.Fatal("\tShould receive the string \"GOT IT\" once the load drops.", tests.Failed, response, err)
+		}
+		t.Log("\tShould receive the string \"GOT IT\" once the load drops.", tests.Success)
+	}
+}
+
+// TestResponseBuffers tests a response made of several segments is written
+// without copying them together.
+func TestResponseBuffers(t *testing.T) {
+	tests.ResetLog()
+	defer tests.DisplayLog()
+
+	t.Log("Given the need to write a response made of several segments.")
+	{
+		// Create a configuration.
+		cfg := tcp.Config{
+			NetType: "tcp4",
+			Addr:    ":0",
+
+			ConnHandler: tcpConnWriterHandler{},
+			ReqHandler:  tcpReqHandler{},
+			RespHandler: tcpBuffersRespHandler{},
+
+			OptIntPool: tcp.OptIntPool{
+				RecvMinPoolSize: func() int { return 2 },
+				RecvMaxPoolSize: func() int { return 1000 },
+				SendMinPoolSize: func() int { return 2 },
+				SendMaxPoolSize: func() int { return 1000 },
+			},
+		}
+
+		// Create a new TCP value.
+		u, err := tcp.New("traceID", "TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to create a new TCP listener.", tests.Success)
+
+		// Start accepting client data.
+		if err := u.Start("traceID"); err != nil {
+			t.Fatal("\tShould be able to start the TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to start the TCP listener.", tests.Success)
+
+		defer u.Stop("traceID")
+
+		conn, err := net.Dial("tcp4", u.Addr().String())
+		if err != nil {
+			t.Fatal("\tShould be able to dial a new TCP connection.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to dial a new TCP connection.", tests.Success)
+
+		defer conn.Close()
+
+		// Wait for the connection to join.
+		for i := 0; len(u.Connections()) == 0; i++ {
+			if i == 100 {
+				t.Fatal("\tShould see the connection join.", tests.Failed)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Log("\tShould see the connection join.", tests.Success)
+
+		resp := tcp.Response{
+			TCPAddr: conn.LocalAddr().(*net.TCPAddr),
+			Data:    []byte("HEAD "),
+			Buffers: net.Buffers{[]byte("BODY"), []byte("\n")},
+			Sent:    make(chan error, 1),
+		}
+
+		if err := u.Do("traceID", &resp); err != nil {
+			t.Fatal("\tShould be able to send the response.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to send the response.", tests.Success)
+
+		if response, err := bufio.NewReader(conn).ReadString('\n'); err != nil || response != "HEAD BODY\n" {
+			t.Fatal("\tShould receive the data followed by the buffers.", tests.Failed, response, err)
+		}
+		t.Log("\tShould receive the data followed by the buffers.", tests.Success)
+
+		if err := <-resp.Sent; err != nil || len(resp.Buffers) != 2 {
+			t.Fatal("\tShould leave the buffers of the response as they were.", tests.Failed, resp.Buffers, err)
+		}
+		t.Log("\tShould leave the buffers of the response as they were.", tests.Success)
+	}
+}
+
+// TestOnConnCountChange tests changes to the number of connections are
+// reported once they settle.
+func TestOnConnCountChange(t *testing.T) {
+	tests.ResetLog()
+	defer tests.DisplayLog()
+
+	t.Log("Given the need to scale with the number of connections.")
+	{
+		counts := make(chan int, 10)
+
+		// Create a configuration.
+		cfg := tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  tcpReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptIntPool: tcp.OptIntPool{
+				RecvMinPoolSize: func() int { return 2 },
+				RecvMaxPoolSize: func() int { return 1000 },
+				SendMinPoolSize: func() int { return 2 },
+				SendMaxPoolSize: func() int { return 1000 },
+			},
+
+			OptConnCount: tcp.OptConnCount{
+				OnConnCountChange: func(count int) {
+					counts <- count
+				},
+				ConnCountDebounce: 200 * time.Millisecond,
+			},
+		}
+
+		// Create a new in-memory TCP value.
+		u, connector, err := tcp.NewInMemory("traceID", "TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new in-memory TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to create a new in-memory TCP listener.", tests.Success)
+
+		// Start accepting client data.
+		if err := u.Start("traceID"); err != nil {
+			t.Fatal("\tShould be able to start the TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to start the TCP listener.", tests.Success)
+
+		defer u.Stop("traceID")
+
+		var conns []net.Conn
+		for i := 0; i < 5; i++ {
+			conn, err := connector.Connect()
+			if err != nil {
+				t.Fatal("\tShould be able to connect in memory.", tests.Failed, err)
+			}
+			t.Log("\tShould be able to connect in memory.", tests.Success)
+
+			conns = append(conns, conn)
+		}
+
+		if count := <-counts; count != 5 {
+			t.Fatal("\tShould report the count once the connections settle.", tests.Failed, count)
+		}
+		t.Log("\tShould report the count once the connections settle.", tests.Success)
+
+		for _, conn := range conns {
+			conn.Close()
+		}
+
+		if count := <-counts; count != 0 {
+			t.Fatal("\tShould report the count once the disconnects settle.", tests.Failed, count)
+		}
+		t.Log("\tShould report the count once the disconnects settle.", tests.Success)
+
+		if n := len(counts); n != 0 {
+			t.Fatal("\tShould not report every change.", tests.Failed, n)
+		}
+		t.Log("\tShould not report every change.", tests.Success)
+	}
+}
+
+// TestWriteClientGone tests responses for a client that was removed while
+// they waited are not written.
+func TestWriteClientGone(t *testing.T) {
+	tests.ResetLog()
+	defer tests.DisplayLog()
+
+	t.Log("Given the need to skip writes to a client that is gone.")
+	{
+		// Create a configuration with a single send routine so
+		// responses wait behind the one being written.
+		cfg := tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  tcpReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptIntPool: tcp.OptIntPool{
+				RecvMinPoolSize: func() int { return 2 },
+				RecvMaxPoolSize: func() int { return 1000 },
+				SendMinPoolSize: func() int { return 1 },
+				SendMaxPoolSize: func() int { return 1 },
+			},
+		}
+
+		// Create a new in-memory TCP value.
+		u, connector, err := tcp.NewInMemory("traceID", "TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new in-memory TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to create a new in-memory TCP listener.", tests.Success)
+
+		// Start accepting client data.
+		if err := u.Start("traceID"); err != nil {
+			t.Fatal("\tShould be able to start the TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to start the TCP listener.", tests.Success)
+
+		defer u.Stop("traceID")
+
+		conn, err := connector.Connect()
+		if err != nil {
+			t.Fatal("\tShould be able to connect in memory.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to connect in memory.", tests.Success)
+
+		defer conn.Close()
+
+		// Wait for the connection to join.
+		for i := 0; len(u.Connections()) == 0; i++ {
+			if i == 100 {
+				t.Fatal("\tShould see the connection join.", tests.Failed)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Log("\tShould see the connection join.", tests.Success)
+
+		// The client isn't reading, so the first response blocks the
+		// only send routine and the second waits.
+		errs := make(chan error, 2)
+		for i := 0; i < 2; i++ {
+			resp := tcp.Response{
+				TCPAddr: conn.LocalAddr().(*net.TCPAddr),
+				Data:    []byte("GOT IT\n"),
+				Length:  7,
+				Sent:    errs,
+			}
+			go u.Do("traceID", &resp)
+		}
+
+		for i := 0; u.Connections()[0].Pending != 2; i++ {
+			if i == 100 {
+				t.Fatal("\tShould have two pending responses.", tests.Failed)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Log("\tShould have two pending responses.", tests.Success)
+
+		// Removing the client fails the write in progress.
+		u.ResetConnections("traceID")
+
+		if err := <-errs; err == nil || err == tcp.ErrClientGone {
+			t.Fatal("\tShould fail the write in progress.", tests.Failed, err)
+		}
+		t.Log("\tShould fail the write in progress.", tests.Success)
+
+		if err := <-errs; err != tcp.ErrClientGone {
+			t.Fatal("\tShould not write the response that was waiting.", tests.Failed, err)
+		}
+		t.Log("\tShould not write the response that was waiting.", tests.Success)
+	}
+}
+
+// TestCIDR tests connections are accepted and dropped by the address
+// range they come from, and the ranges can be replaced while running.
+func TestCIDR(t *testing.T) {
+	tests.ResetLog()
+	defer tests.DisplayLog()
+
+	t.Log("Given the need to accept connections by address range.")
+	{
+		reasons := make(chan string, 1)
+
+		// Create a configuration.
+		cfg := tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  tcpReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptIntPool: tcp.OptIntPool{
+				RecvMinPoolSize: func() int { return 2 },
+				RecvMaxPoolSize: func() int { return 1000 },
+				SendMinPoolSize: func() int { return 2 },
+				SendMaxPoolSize: func() int { return 1000 },
+			},
+
+			OptCIDR: tcp.OptCIDR{
+				DenyCIDRs:       []string{"not a cidr"},
+				DropDeniedConns: true,
+			},
+
+			OptDrop: tcp.OptDrop{
+				OnDrop: func(reason string, remoteAddr string) {
+					reasons <- reason
+				},
+			},
+		}
+
+		if _, _, err := tcp.NewInMemory("traceID", "TEST", cfg); err != tcp.ErrInvalidCIDR {
+			t.Fatal("\tShould not be able to create a TCP listener with an invalid CIDR.", tests.Failed, err)
+		}
+		t.Log("\tShould not be able to create a TCP listener with an invalid CIDR.", tests.Success)
+
+		cfg.DenyCIDRs = []string{"127.0.0.0/8"}
+
+		// Create a new in-memory TCP value.
+		u, connector, err := tcp.NewInMemory("traceID", "TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new in-memory TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to create a new in-memory TCP listener.", tests.Success)
+
+		// Start accepting client data.
+		if err := u.Start("traceID"); err != nil {
+			t.Fatal("\tShould be able to start the TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to start the TCP listener.", tests.Success)
+
+		defer u.Stop("traceID")
+
+		conn, err := connector.Connect()
+		if err != nil {
+			t.Fatal("\tShould be able to connect in memory.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to connect in memory.", tests.Success)
+
+		if _, err := conn.Read(make([]byte, 1)); err == nil {
+			t.Fatal("\tShould drop a connection from a denied range.", tests.Failed)
+		}
+		t.Log("\tShould drop a connection from a denied range.", tests.Success)
+
+		if reason := <-reasons; reason != tcp.DropReasonCIDR {
+			t.Fatal("\tShould report the CIDR reason.", tests.Failed, reason)
+		}
+		t.Log("\tShould report the CIDR reason.", tests.Success)
+
+		if err := u.SetDenyCIDRs(nil); err != nil {
+			t.Fatal("\tShould be able to clear the deny list.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to clear the deny list.", tests.Success)
+
+		if conn, err = connector.Connect(); err != nil {
+			t.Fatal("\tShould be able to connect in memory.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to connect in memory.", tests.Success)
+
+		defer conn.Close()
+
+		go conn.Write([]byte("Hello\n"))
+
+		bufReader := bufio.NewReader(conn)
+		if response, err := bufReader.ReadString('\n'); err != nil || response != "GOT IT\n" {
+			t.Fatal("\tShould receive the string \"GOT IT\" once the range is no longer denied.", tests.Failed, response, err)
+		}
+		t.Log("\tShould receive the string \"GOT IT\" once the range is no longer denied.", tests.Success)
+
+		if err := u.SetAllowCIDRs([]string{"bad"}); err != tcp.ErrInvalidCIDR {
+			t.Fatal("\tShould not be able to set an invalid allow list.", tests.Failed, err)
+		}
+		t.Log("\tShould not be able to set an invalid allow list.", tests.Success)
+
+		if err := u.SetAllowCIDRs([]string{"127.0.0.1"}); err != nil {
+			t.Fatal("\tShould be able to set the allow list.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to set the allow list.", tests.Success)
+
+		if n := len(u.Connections()); n != 1 {
+			t.Fatal("\tShould keep a connection the allow list still allows.", tests.Failed, n)
+		}
+		t.Log("\tShould keep a connection the allow list still allows.", tests.Success)
+
+		if err := u.SetAllowCIDRs([]string{"10.0.0.0/8"}); err != nil {
+			t.Fatal("\tShould be able to set the allow list.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to set the allow list.", tests.Success)
+
+		if _, err := bufReader.ReadByte(); err == nil {
+			t.Fatal("\tShould drop a live connection the allow list no longer allows.", tests.Failed)
+		}
+		t.Log("\tShould drop a live connection the allow list no longer allows.", tests.Success)
+	}
+}
+
+// TestFirstByteTimeout tests clients that never send anything are dropped.
+func TestFirstByteTimeout(t *testing.T) {
+	tests.ResetLog()
+	defer tests.DisplayLog()
+
+	t.Log("Given the need to drop clients that connect and send nothing.")
+	{
+		reasons := make(chan tcp.CloseReason, 1)
+
+		// Create a configuration.
+		cfg := tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  tcpReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptIntPool: tcp.OptIntPool{
+				RecvMinPoolSize: func() int { return 2 },
+				RecvMaxPoolSize: func() int { return 1000 },
+				SendMinPoolSize: func() int { return 2 },
+				SendMaxPoolSize: func() int { return 1000 },
+			},
+
+			OptTimeout: tcp.OptTimeout{
+				FirstByteTimeout: 50 * time.Millisecond,
+			},
+
+			OptDisconnect: tcp.OptDisconnect{
+				OnDisconnect: func(remoteAddr string, reason tcp.CloseReason) {
+					reasons <- reason
+				},
+			},
+		}
+
+		// Create a new in-memory TCP value.
+		u, connector, err := tcp.NewInMemory("traceID", "TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new in-memory TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to create a new in-memory TCP listener.", tests.Success)
+
+		// Start accepting client data.
+		if err := u.Start("traceID"); err != nil {
+			t.Fatal("\tShould be able to start the TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to start the TCP listener.", tests.Success)
+
+		defer u.Stop("traceID")
+
+		conn, err := connector.Connect()
+		if err != nil {
+			t.Fatal("\tShould be able to connect in memory.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to connect in memory.", tests.Success)
+
+		if _, err := conn.Read(make([]byte, 1)); err == nil {
+			t.Fatal("\tShould drop a client that sends nothing.", tests.Failed)
+		}
+		t.Log("\tShould drop a client that sends nothing.", tests.Success)
+
+		if reason := <-reasons; reason != tcp.CloseFirstByteTimeout {
+			t.Fatal("\tShould report the first byte timeout reason.", tests.Failed, reason)
+		}
+		t.Log("\tShould report the first byte timeout reason.", tests.Success)
+
+		if conn, err = connector.Connect(); err != nil {
+			t.Fatal("\tShould be able to connect in memory.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to connect in memory.", tests.Success)
+
+		defer conn.Close()
+
+		bufReader := bufio.NewReader(conn)
+		for i := 0; i < 2; i++ {
+			go conn.Write([]byte("Hello\n"))
+
+			if response, err := bufReader.ReadString('\n'); err != nil || response != "GOT IT\n" {
+				t.Fatal("\tShould receive the string \"GOT IT\".", tests.Failed, response, err)
+			}
+			t.Log("\tShould receive the string \"GOT IT\".", tests.Success)
+
+			// Idle past the timeout, which no longer applies.
+			time.Sleep(100 * time.Millisecond)
+		}
+	}
+}
+
+// TestConnectionsOlderThan tests long lived connections can be found and
+// dropped.
+func TestConnectionsOlderThan(t *testing.T) {
+	tests.ResetLog()
+	defer tests.DisplayLog()
+
+	t.Log("Given the need to recycle long lived connections.")
+	{
+		// Create a configuration.
+		cfg := tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  tcpReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptIntPool: tcp.OptIntPool{
+				RecvMinPoolSize: func() int { return 2 },
+				RecvMaxPoolSize: func() int { return 1000 },
+				SendMinPoolSize: func() int { return 2 },
+				SendMaxPoolSize: func() int { return 1000 },
+			},
+		}
+
+		// Create a new in-memory TCP value.
+		u, connector, err := tcp.NewInMemory("traceID", "TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new in-memory TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to create a new in-memory TCP listener.", tests.Success)
+
+		// Start accepting client data.
+		if err := u.Start("traceID"); err != nil {
+			t.Fatal("\tShould be able to start the TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to start the TCP listener.", tests.Success)
+
+		defer u.Stop("traceID")
+
+		var conns []net.Conn
+		for i := 0; i < 2; i++ {
+			conn, err := connector.Connect()
+			if err != nil {
+				t.Fatal("\tShould be able to connect in memory.", tests.Failed, err)
+			}
+			t.Log("\tShould be able to connect in memory.", tests.Success)
+
+			defer conn.Close()
+			conns = append(conns, conn)
+
+			for j := 0; len(u.Connections()) != i+1; j++ {
+				if j == 100 {
+					t.Fatal("\tShould have the connection joined.", tests.Failed)
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			t.Log("\tShould have the connection joined.", tests.Success)
+
+			if i == 0 {
+				time.Sleep(100 * time.Millisecond)
+			}
+		}
+
+		if stats := u.Stats(); stats.AcceptedPlain != 2 || stats.AcceptedTLS != 0 {
+			t.Fatal("\tShould count the connections as plaintext.", tests.Failed, stats.AcceptedPlain, stats.AcceptedTLS)
+		}
+		t.Log("\tShould count the connections as plaintext.", tests.Success)
+
+		oldest := conns[0].LocalAddr().String()
+
+		infos := u.ConnectionsOlderThan(50 * time.Millisecond)
+		if len(infos) != 1 || infos[0].Addr != oldest {
+			t.Fatal("\tShould find only the older connection.", tests.Failed, infos)
+		}
+		t.Log("\tShould find only the older connection.", tests.Success)
+
+		infos = u.ConnectionsOlderThan(0)
+		if len(infos) != 2 || infos[0].Addr != oldest {
+			t.Fatal("\tShould find every connection, oldest first.", tests.Failed, infos)
+		}
+		t.Log("\tShould find every connection, oldest first.", tests.Success)
+
+		if err := u.DropConnection("traceID", oldest); err != nil {
+			t.Fatal("\tShould be able to drop the older connection.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to drop the older connection.", tests.Success)
+
+		if _, err := conns[0].Read(make([]byte, 1)); err == nil {
+			t.Fatal("\tShould have the older connection closed.", tests.Failed)
+		}
+		t.Log("\tShould have the older connection closed.", tests.Success)
+
+		if n := len(u.Connections()); n != 1 {
+			t.Fatal("\tShould keep the newer connection.", tests.Failed, n)
+		}
+		t.Log("\tShould keep the newer connection.", tests.Success)
+
+		if err := u.DropConnection("traceID", oldest); err == nil {
+			t.Fatal("\tShould not be able to drop a connection that is gone.", tests.Failed)
+		}
+		t.Log("\tShould not be able to drop a connection that is gone.", tests.Success)
+	}
+}
+
+// TestResponseMiddleware tests every response can be changed before it
+// is written.
+func TestResponseMiddleware(t *testing.T) {
+	tests.ResetLog()
+	defer tests.DisplayLog()
+
+	t.Log("Given the need to change every response in one place.")
+	{
+		// Create a configuration.
+		cfg := tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  tcpReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptIntPool: tcp.OptIntPool{
+				RecvMinPoolSize: func() int { return 2 },
+				RecvMaxPoolSize: func() int { return 1000 },
+				SendMinPoolSize: func() int { return 2 },
+				SendMaxPoolSize: func() int { return 1000 },
+			},
+
+			OptMiddleware: tcp.OptMiddleware{
+				ResponseMiddleware: func(r *tcp.Response) {
+					r.Data = append([]byte("V1 "), r.Data...)
+					r.Length = len(r.Data)
+				},
+			},
+		}
+
+		// Create a new in-memory TCP value.
+		u, connector, err := tcp.NewInMemory("traceID", "TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new in-memory TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to create a new in-memory TCP listener.", tests.Success)
+
+		// Start accepting client data.
+		if err := u.Start("traceID"); err != nil {
+			t.Fatal("\tShould be able to start the TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to start the TCP listener.", tests.Success)
+
+		defer u.Stop("traceID")
+
+		conn, err := connector.Connect()
+		if err != nil {
+			t.Fatal("\tShould be able to connect in memory.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to connect in memory.", tests.Success)
+
+		defer conn.Close()
+
+		go conn.Write([]byte("Hello\n"))
+
+		bufReader := bufio.NewReader(conn)
+		if response, err := bufReader.ReadString('\n'); err != nil || response != "V1 GOT IT\n" {
+			t.Fatal("\tShould receive the string \"V1 GOT IT\".", tests.Failed, response, err)
+		}
+		t.Log("\tShould receive the string \"V1 GOT IT\".", tests.Success)
+	}
+}
+
+// TestRequestMiddleware tests every request can be checked before it is
+// processed, and skipped or have the connection dropped.
+func TestRequestMiddleware(t *testing.T) {
+	tests.ResetLog()
+	defer tests.DisplayLog()
+
+	t.Log("Given the need to check every request in one place.")
+	{
+		var seen int32
+		reasons := make(chan tcp.CloseReason, 1)
+
+		// Create a configuration.
+		cfg := tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  tcpReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptIntPool: tcp.OptIntPool{
+				RecvMinPoolSize: func() int { return 2 },
+				RecvMaxPoolSize: func() int { return 1000 },
+				SendMinPoolSize: func() int { return 2 },
+				SendMaxPoolSize: func() int { return 1000 },
+			},
+
+			OptMiddleware: tcp.OptMiddleware{
+				RequestMiddleware: func(r *tcp.Request) error {
+					atomic.AddInt32(&seen, 1)
+
+					switch string(r.Data) {
+					case "Skip\n":
+						return tcp.ErrSkipRequest
+					case "Bad\n":
+						return errors.New("bad token")
+					}
+					return nil
+				},
+			},
+
+			OptDisconnect: tcp.OptDisconnect{
+				OnDisconnect: func(remoteAddr string, reason tcp.CloseReason) {
+					reasons <- reason
+				},
+			},
+		}
+
+		// Create a new in-memory TCP value.
+		u, connector, err := tcp.NewInMemory("traceID", "TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new in-memory TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to create a new in-memory TCP listener.", tests.Success)
+
+		// Start accepting client data.
+		if err := u.Start("traceID"); err != nil {
+			t.Fatal("\tShould be able to start the TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to start the TCP listener.", tests.Success)
+
+		defer u.Stop("traceID")
+
+		conn, err := connector.Connect()
+		if err != nil {
+			t.Fatal("\tShould be able to connect in memory.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to connect in memory.", tests.Success)
+
+		defer conn.Close()
+
+		go conn.Write([]byte("Skip\nHello\n"))
+
+		bufReader := bufio.NewReader(conn)
+		if response, err := bufReader.ReadString('\n'); err != nil || response != "GOT IT\n" {
+			t.Fatal("\tShould receive the string \"GOT IT\" after a skipped request.", tests.Failed, response, err)
+		}
+		t.Log("\tShould receive the string \"GOT IT\" after a skipped request.", tests.Success)
+
+		for i := 0; atomic.LoadInt32(&seen) != 2; i++ {
+			if i == 100 {
+				t.Fatal("\tShould give every request to the middleware.", tests.Failed, atomic.LoadInt32(&seen))
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Log("\tShould give every request to the middleware.", tests.Success)
+
+		go conn.Write([]byte("Bad\n"))
+
+		if response, err := bufReader.ReadString('\n'); err == nil {
+			t.Fatal("\tShould drop the connection on a middleware error.", tests.Failed, response)
+		}
+		t.Log("\tShould drop the connection on a middleware error.", tests.Success)
+
+		if reason := <-reasons; reason != tcp.CloseDropped {
+			t.Fatal("\tShould report the connection as dropped.", tests.Failed, reason)
+		}
+		t.Log("\tShould report the connection as dropped.", tests.Success)
+	}
+}
+
+// TestPoolBudget tests the pool sizes can be derived from a total number
+// of routines and a split between the pools.
+func TestPoolBudget(t *testing.T) {
+	tests.ResetLog()
+	defer tests.DisplayLog()
+
+	t.Log("Given the need to size the pools from a budget.")
+	{
+		newConfig := func(budget tcp.OptPoolBudget) tcp.Config {
+			return tcp.Config{
+				ConnHandler: tcpConnHandler{},
+				ReqHandler:  tcpReqHandler{},
+				RespHandler: tcpRespHandler{},
+
+				OptPoolBudget: budget,
+			}
+		}
+
+		cfg := newConfig(tcp.OptPoolBudget{PoolBudgetMin: 4, PoolBudgetMax: 10, PoolRecvShare: 0.75})
+
+		// Create a new in-memory TCP value.
+		u, _, err := tcp.NewInMemory("traceID", "TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new in-memory TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to create a new in-memory TCP listener.", tests.Success)
+
+		recvMin, recvMax, sendMin, sendMax := u.PoolSizes()
+		if recvMin != 3 || recvMax != 8 || sendMin != 1 || sendMax != 2 {
+			t.Fatal("\tShould split the budget between the pools.", tests.Failed, recvMin, recvMax, sendMin, sendMax)
+		}
+		t.Log("\tShould split the budget between the pools.", tests.Success)
+
+		u.Stop("traceID")
+
+		cfg = newConfig(tcp.OptPoolBudget{PoolBudgetMax: 2})
+		cfg.RecvMinPoolSize = func() int { return 1 }
+		if _, _, err := tcp.NewInMemory("traceID", "TEST", cfg); err != tcp.ErrInvalidPoolConfiguration {
+			t.Fatal("\tShould not be able to use a budget with pool sizes.", tests.Failed, err)
+		}
+		t.Log("\tShould not be able to use a budget with pool sizes.", tests.Success)
+
+		invalid := []tcp.OptPoolBudget{
+			{PoolBudgetMax: 1},
+			{PoolBudgetMin: 4, PoolBudgetMax: 2},
+			{PoolBudgetMax: 4, PoolRecvShare: 1},
+			{PoolBudgetMax: 4, PoolRecvShare: -0.5},
+		}
+
+		for _, budget := range invalid {
+			if _, _, err := tcp.NewInMemory("traceID", "TEST", newConfig(budget)); err != tcp.ErrInvalidPoolBudget {
+				t.Fatalf("\tShould not be able to use budget %+v. %s %v", budget, tests.Failed, err)
+			}
+			t.Logf("\tShould not be able to use budget %+v. %s", budget, tests.Success)
+		}
+	}
+}
+
+// TestRequestSeq tests requests are numbered per connection, starting at 1.
+func TestRequestSeq(t *testing.T) {
+	tests.ResetLog()
+	defer tests.DisplayLog()
+
+	t.Log("Given the need to number the requests read from each connection.")
+	{
+		// Create a configuration.
+		cfg := tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  tcpSeqReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptIntPool: tcp.OptIntPool{
+				RecvMinPoolSize: func() int { return 2 },
+				RecvMaxPoolSize: func() int { return 1000 },
+				SendMinPoolSize: func() int { return 2 },
+				SendMaxPoolSize: func() int { return 1000 },
+			},
+		}
+
+		// Create a new in-memory TCP value.
+		u, connector, err := tcp.NewInMemory("traceID", "TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new in-memory TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to create a new in-memory TCP listener.", tests.Success)
+
+		// Start accepting client data.
+		if err := u.Start("traceID"); err != nil {
+			t.Fatal("\tShould be able to start the TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to start the TCP listener.", tests.Success)
+
+		defer u.Stop("traceID")
+
+		for _, requests := range []int{3, 2} {
+			conn, err := connector.Connect()
+			if err != nil {
+				t.Fatal("\tShould be able to connect in memory.", tests.Failed, err)
+			}
+			t.Log("\tShould be able to connect in memory.", tests.Success)
+
+			defer conn.Close()
+
+			// Send one request at a time, since responses are not
+			// written in order, and only once the previous response
+			// is done with the writer.
+			bufReader := bufio.NewReader(conn)
+			for seq := 1; seq <= requests; seq++ {
+				for i := 0; seq > 1 && !tcpIdle(u, conn); i++ {
+					if i == 100 {
+						t.Fatal("\tShould finish writing the previous response.", tests.Failed)
+					}
+					time.Sleep(10 * time.Millisecond)
+				}
+
+				go conn.Write([]byte("Hello\n"))
+
+				want := fmt.Sprintf("SEQ %d\n", seq)
+				if response, err := bufReader.ReadString('\n'); err != nil || response != want {
+					t.Fatalf("\tShould receive sequence number %d. %s %q %v", seq, tests.Failed, response, err)
+				}
+				t.Logf("\tShould receive sequence number %d. %s", seq, tests.Success)
+			}
+		}
+	}
+}
+
+// TestPauseAccept tests accepting can be paused while existing connections
+// keep being serviced.
+func TestPauseAccept(t *testing.T) {
+	tests.ResetLog()
+	defer tests.DisplayLog()
+
+	t.Log("Given the need to pause accepting new connections.")
+	{
+		// Create a configuration.
+		cfg := tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  tcpReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptIntPool: tcp.OptIntPool{
+				RecvMinPoolSize: func() int { return 2 },
+				RecvMaxPoolSize: func() int { return 1000 },
+				SendMinPoolSize: func() int { return 2 },
+				SendMaxPoolSize: func() int { return 1000 },
+			},
+		}
+
+		// Create a new in-memory TCP value.
+		u, connector, err := tcp.NewInMemory("traceID", "TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new in-memory TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to create a new in-memory TCP listener.", tests.Success)
+
+		// Start accepting client data.
+		if err := u.Start("traceID"); err != nil {
+			t.Fatal("\tShould be able to start the TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to start the TCP listener.", tests.Success)
+
+		defer u.Stop("traceID")
+
+		conn, err := connector.Connect()
+		if err != nil {
+			t.Fatal("\tShould be able to connect in memory.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to connect in memory.", tests.Success)
+
+		defer conn.Close()
+
+		u.PauseAccept(true)
+
+		connected := make(chan net.Conn, 1)
+		go func() {
+			conn, err := connector.Connect()
+			if err != nil {
+				close(connected)
+				return
+			}
+			connected <- conn
+		}()
+
+		go conn.Write([]byte("Hello\n"))
+
+		bufReader := bufio.NewReader(conn)
+		if response, err := bufReader.ReadString('\n'); err != nil || response != "GOT IT\n" {
+			t.Fatal("\tShould keep servicing an existing connection while paused.", tests.Failed, response, err)
+		}
+		t.Log("\tShould keep servicing an existing connection while paused.", tests.Success)
+
+		time.Sleep(100 * time.Millisecond)
+
+		if n := len(u.Connections()); n != 1 {
+			t.Fatal("\tShould not add a new connection while paused.", tests.Failed, n)
+		}
+		t.Log("\tShould not add a new connection while paused.", tests.Success)
+
+		u.PauseAccept(false)
+
+		var waiting net.Conn
+		select {
+		case waiting = <-connected:
+			if waiting == nil {
+				t.Fatal("\tShould accept the waiting connection once resumed.", tests.Failed)
+			}
+
+		case <-time.After(time.Second):
+			t.Fatal("\tShould accept the waiting connection once resumed.", tests.Failed)
+		}
+
+		defer waiting.Close()
+
+		go waiting.Write([]byte("Hello\n"))
+
+		if response, err := bufio.NewReader(waiting).ReadString('\n'); err != nil || response != "GOT IT\n" {
+			t.Fatal("\tShould accept the waiting connection once resumed.", tests.Failed, response, err)
+		}
+		t.Log("\tShould accept the waiting connection once resumed.", tests.Success)
+	}
+}
+
+// TestProbeFunc tests health probes are answered without adding a client
+// connection, and other connections see the bytes the probe check read.
+func TestProbeFunc(t *testing.T) {
+	tests.ResetLog()
+	defer tests.DisplayLog()
+
+	t.Log("Given the need to answer health probes.")
+	{
+		// Create a configuration.
+		cfg := tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  tcpReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptIntPool: tcp.OptIntPool{
+				RecvMinPoolSize: func() int { return 2 },
+				RecvMaxPoolSize: func() int { return 1000 },
+				SendMinPoolSize: func() int { return 2 },
+				SendMaxPoolSize: func() int { return 1000 },
+			},
+
+			OptProbe: tcp.OptProbe{
+				ProbeFunc: func(conn net.Conn) bool {
+					buf := make([]byte, 5)
+
+					conn.SetReadDeadline(time.Now().Add(time.Second))
+					_, err := io.ReadFull(conn, buf)
+					conn.SetReadDeadline(time.Time{})
+
+					if err != nil || string(buf) != "PING\n" {
+						return false
+					}
+
+					conn.Write([]byte("PONG\n"))
+					return true
+				},
+			},
+		}
+
+		// Create a new in-memory TCP value.
+		u, connector, err := tcp.NewInMemory("traceID", "TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new in-memory TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to create a new in-memory TCP listener.", tests.Success)
+
+		// Start accepting client data.
+		if err := u.Start("traceID"); err != nil {
+			t.Fatal("\tShould be able to start the TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to start the TCP listener.", tests.Success)
+
+		defer u.Stop("traceID")
+
+		probe, err := connector.Connect()
+		if err != nil {
+			t.Fatal("\tShould be able to connect in memory.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to connect in memory.", tests.Success)
+
+		defer probe.Close()
+
+		go probe.Write([]byte("PING\n"))
+
+		if response, err := bufio.NewReader(probe).ReadString('\n'); err != nil || response != "PONG\n" {
+			t.Fatal("\tShould receive the string \"PONG\" for a probe.", tests.Failed, response, err)
+		}
+		t.Log("\tShould receive the string \"PONG\" for a probe.", tests.Success)
+
+		if _, err := probe.Read(make([]byte, 1)); err == nil {
+			t.Fatal("\tShould close the connection once the probe is answered.", tests.Failed)
+		}
+		t.Log("\tShould close the connection once the probe is answered.", tests.Success)
+
+		if stats := u.Stats(); stats.Probes != 1 || stats.AcceptedTotal != 0 {
+			t.Fatal("\tShould count the probe but not as a client connection.", tests.Failed, stats.Probes, stats.AcceptedTotal)
+		}
+		t.Log("\tShould count the probe but not as a client connection.", tests.Success)
+
+		conn, err := connector.Connect()
+		if err != nil {
+			t.Fatal("\tShould be able to connect in memory.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to connect in memory.", tests.Success)
+
+		defer conn.Close()
+
+		go conn.Write([]byte("Hello\n"))
+
+		if response, err := bufio.NewReader(conn).ReadString('\n'); err != nil || response != "GOT IT\n" {
+			t.Fatal("\tShould receive the string \"GOT IT\" when the bytes read by the probe check are replayed.", tests.Failed, response, err)
+		}
+		t.Log("\tShould receive the string \"GOT IT\" when the bytes read by the probe check are replayed.", tests.Success)
+	}
+}
+
+// TestMaxReadDuration tests clients that trickle a request in are dropped
+// while idle clients are not.
+func TestMaxReadDuration(t *testing.T) {
+	tests.ResetLog()
+	defer tests.DisplayLog()
+
+	t.Log("Given the need to bound the time taken to read a request.")
+	{
+		reasons := make(chan tcp.CloseReason, 1)
+
+		// Create a configuration.
+		cfg := tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  tcpReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptIntPool: tcp.OptIntPool{
+				RecvMinPoolSize: func() int { return 2 },
+				RecvMaxPoolSize: func() int { return 1000 },
+				SendMinPoolSize: func() int { return 2 },
+				SendMaxPoolSize: func() int { return 1000 },
+			},
+
+			OptTimeout: tcp.OptTimeout{
+				MaxReadDuration: 100 * time.Millisecond,
+			},
+
+			OptDisconnect: tcp.OptDisconnect{
+				OnDisconnect: func(remoteAddr string, reason tcp.CloseReason) {
+					reasons <- reason
+				},
+			},
+		}
+
+		// Create a new in-memory TCP value.
+		u, connector, err := tcp.NewInMemory("traceID", "TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new in-memory TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to create a new in-memory TCP listener.", tests.Success)
+
+		// Start accepting client data.
+		if err := u.Start("traceID"); err != nil {
+			t.Fatal("\tShould be able to start the TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to start the TCP listener.", tests.Success)
+
+		defer u.Stop("traceID")
+
+		conn, err := connector.Connect()
+		if err != nil {
+			t.Fatal("\tShould be able to connect in memory.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to connect in memory.", tests.Success)
+
+		defer conn.Close()
+
+		// Stay idle for longer than the duration between requests.
+		bufReader := bufio.NewReader(conn)
+		for i := 0; i < 2; i++ {
+			time.Sleep(200 * time.Millisecond)
+
+			go conn.Write([]byte("Hello\n"))
+
+			if response, err := bufReader.ReadString('\n'); err != nil || response != "GOT IT\n" {
+				t.Fatal("\tShould receive the string \"GOT IT\" after being idle.", tests.Failed, response, err)
+			}
+			t.Log("\tShould receive the string \"GOT IT\" after being idle.", tests.Success)
+		}
+
+		// Send part of a request and never finish it.
+		go conn.Write([]byte("Hel"))
+
+		if _, err := bufReader.ReadByte(); err == nil {
+			t.Fatal("\tShould drop a client that doesn't finish a request.", tests.Failed)
+		}
+		t.Log("\tShould drop a client that doesn't finish a request.", tests.Success)
+
+		if reason := <-reasons; reason != tcp.CloseReadTimeout {
+			t.Fatal("\tShould report the read timeout reason.", tests.Failed, reason)
+		}
+		t.Log("\tShould report the read timeout reason.", tests.Success)
+	}
+}
+
+// TestAcceptFilters tests accepted connections are run through the chain
+// of filters in order.
+func TestAcceptFilters(t *testing.T) {
+	tests.ResetLog()
+	defer tests.DisplayLog()
+
+	t.Log("Given the need to filter connections with a chain of checks.")
+	{
+		var secondCalls int32
+		reasons := make(chan string, 1)
+
+		// denyPort returns a filter that denies the connection from the
+		// specified in-memory port.
+		denyPort := func(port int, reason string, calls *int32) tcp.AcceptFilter {
+			return func(conn net.Conn) (bool, string) {
+				if calls != nil {
+					atomic.AddInt32(calls, 1)
+				}
+				if conn.RemoteAddr().(*net.TCPAddr).Port == port {
+					return false, reason
+				}
+				return true, ""
+			}
+		}
+
+		// Create a configuration.
+		cfg := tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  tcpReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptIntPool: tcp.OptIntPool{
+				RecvMinPoolSize: func() int { return 2 },
+				RecvMaxPoolSize: func() int { return 1000 },
+				SendMinPoolSize: func() int { return 2 },
+				SendMaxPoolSize: func() int { return 1000 },
+			},
+
+			OptAdmit: tcp.OptAdmit{
+				AcceptFilters: []tcp.AcceptFilter{
+					denyPort(1, "blocked", nil),
+					denyPort(2, "", &secondCalls),
+				},
+			},
+
+			OptDrop: tcp.OptDrop{
+				OnDrop: func(reason string, remoteAddr string) {
+					reasons <- reason
+				},
+			},
+		}
+
+		// Create a new in-memory TCP value.
+		u, connector, err := tcp.NewInMemory("traceID", "TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new in-memory TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to create a new in-memory TCP listener.", tests.Success)
+
+		// Start accepting client data.
+		if err := u.Start("traceID"); err != nil {
+			t.Fatal("\tShould be able to start the TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to start the TCP listener.", tests.Success)
+
+		defer u.Stop("traceID")
+
+		for _, want := range []string{"blocked", tcp.DropReasonFilter} {
+			conn, err := connector.Connect()
+			if err != nil {
+				t.Fatal("\tShould be able to connect in memory.", tests.Failed, err)
+			}
+			t.Log("\tShould be able to connect in memory.", tests.Success)
+
+			if _, err := conn.Read(make([]byte, 1)); err == nil {
+				t.Fatal("\tShould drop a connection a filter denies.", tests.Failed)
+			}
+			t.Log("\tShould drop a connection a filter denies.", tests.Success)
+
+			if reason := <-reasons; reason != want {
+				t.Fatalf("\tShould report the reason %q. %s %q", want, tests.Failed, reason)
+			}
+			t.Logf("\tShould report the reason %q. %s", want, tests.Success)
+		}
+
+		if n := atomic.LoadInt32(&secondCalls); n != 1 {
+			t.Fatal("\tShould stop at the first filter that denies.", tests.Failed, n)
+		}
+		t.Log("\tShould stop at the first filter that denies.", tests.Success)
+
+		conn, err := connector.Connect()
+		if err != nil {
+			t.Fatal("\tShould be able to connect in memory.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to connect in memory.", tests.Success)
+
+		defer conn.Close()
+
+		go conn.Write([]byte("Hello\n"))
+
+		if response, err := bufio.NewReader(conn).ReadString('\n'); err != nil || response != "GOT IT\n" {
+			t.Fatal("\tShould receive the string \"GOT IT\" once every filter allows it.", tests.Failed, response, err)
+		}
+		t.Log("\tShould receive the string \"GOT IT\" once every filter allows it.", tests.Success)
+	}
+}
+
+// TestGoroutineCount tests the goroutines owned by a TCP value are counted
+// and none are left after Stop.
+func TestGoroutineCount(t *testing.T) {
+	tests.ResetLog()
+	defer tests.DisplayLog()
+
+	t.Log("Given the need to count the goroutines a TCP value owns.")
+	{
+		// Create a configuration.
+		cfg := tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  tcpReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptIntPool: tcp.OptIntPool{
+				RecvMinPoolSize: func() int { return 2 },
+				RecvMaxPoolSize: func() int { return 2 },
+				SendMinPoolSize: func() int { return 2 },
+				SendMaxPoolSize: func() int { return 2 },
+			},
+		}
+
+		// Create a new in-memory TCP value.
+		u, connector, err := tcp.NewInMemory("traceID", "TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new in-memory TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to create a new in-memory TCP listener.", tests.Success)
+
+		// Start accepting client data.
+		if err := u.Start("traceID"); err != nil {
+			t.Fatal("\tShould be able to start the TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to start the TCP listener.", tests.Success)
+
+		conn, err := connector.Connect()
+		if err != nil {
+			t.Fatal("\tShould be able to connect in memory.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to connect in memory.", tests.Success)
+
+		defer conn.Close()
+
+		// The accept and read routines, and two workers and a manager
+		// for each pool.
+		for i := 0; u.GoroutineCount() != 8; i++ {
+			if i == 100 {
+				t.Fatal("\tShould count the goroutines while running.", tests.Failed, u.GoroutineCount())
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Log("\tShould count the goroutines while running.", tests.Success)
+
+		u.Stop("traceID")
+
+		if n := u.GoroutineCount(); n != 0 {
+			t.Fatal("\tShould own no goroutines after Stop.", tests.Failed, n)
+		}
+		t.Log("\tShould own no goroutines after Stop.", tests.Success)
+	}
+}
+
+// TestWriteBufferSize tests the writes for a response are batched by the
+// write buffer each connection owns.
+func TestWriteBufferSize(t *testing.T) {
+	tests.ResetLog()
+	defer tests.DisplayLog()
+
+	t.Log("Given the need to batch the small writes made for a response.")
+	{
+		wrapped := make(chan *tcpCountConn, 1)
+
+		// Create a configuration.
+		cfg := tcp.Config{
+			ConnHandler: tcpConnWriterHandler{},
+			ReqHandler:  tcpReqHandler{},
+			RespHandler: tcpChattyRespHandler{},
+
+			OptIntPool: tcp.OptIntPool{
+				RecvMinPoolSize: func() int { return 2 },
+				RecvMaxPoolSize: func() int { return 1000 },
+				SendMinPoolSize: func() int { return 2 },
+				SendMaxPoolSize: func() int { return 1000 },
+			},
+
+			OptBuffer: tcp.OptBuffer{
+				WriteBufferSize: 64,
+			},
+
+			OptWrap: tcp.OptWrap{
+				WrapConn: func(conn net.Conn) net.Conn {
+					c := tcpCountConn{Conn: conn}
+					wrapped <- &c
+					return &c
+				},
+			},
+		}
+
+		// Create a new in-memory TCP value.
+		u, connector, err := tcp.NewInMemory("traceID", "TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new in-memory TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to create a new in-memory TCP listener.", tests.Success)
+
+		// Start accepting client data.
+		if err := u.Start("traceID"); err != nil {
+			t.Fatal("\tShould be able to start the TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to start the TCP listener.", tests.Success)
+
+		defer u.Stop("traceID")
+
+		conn, err := connector.Connect()
+		if err != nil {
+			t.Fatal("\tShould be able to connect in memory.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to connect in memory.", tests.Success)
+
+		defer conn.Close()
+
+		go conn.Write([]byte("Hello\n"))
+
+		if response, err := bufio.NewReader(conn).ReadString('\n'); err != nil || response != "GOT IT\n" {
+			t.Fatal("\tShould receive the string \"GOT IT\".", tests.Failed, response, err)
+		}
+		t.Log("\tShould receive the string \"GOT IT\".", tests.Success)
+
+		// The count is final once the response is done with.
+		for i := 0; !tcpIdle(u, conn); i++ {
+			if i == 100 {
+				t.Fatal("\tShould finish writing the response.", tests.Failed)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		if n := atomic.LoadInt64(&(<-wrapped).writes); n != 1 {
+			t.Fatal("\tShould write the response to the connection once.", tests.Failed, n)
+		}
+		t.Log("\tShould write the response to the connection once.", tests.Success)
+	}
+}
+
+// TestMaxWriteErrors tests a connection is dropped once writes to it fail
+// too many times in a row.
+func TestMaxWriteErrors(t *testing.T) {
+	tests.ResetLog()
+	defer tests.DisplayLog()
+
+	t.Log("Given the need to drop connections that keep failing to be written to.")
+	{
+		reasons := make(chan tcp.CloseReason, 1)
+
+		// Create a configuration.
+		cfg := tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  tcpReqHandler{},
+			RespHandler: tcpFailRespHandler{},
+
+			OptIntPool: tcp.OptIntPool{
+				RecvMinPoolSize: func() int { return 2 },
+				RecvMaxPoolSize: func() int { return 1000 },
+				SendMinPoolSize: func() int { return 2 },
+				SendMaxPoolSize: func() int { return 1000 },
+			},
+
+			OptWriteErrors: tcp.OptWriteErrors{
+				MaxWriteErrors: 2,
+			},
+
+			OptDisconnect: tcp.OptDisconnect{
+				OnDisconnect: func(remoteAddr string, reason tcp.CloseReason) {
+					reasons <- reason
+				},
+			},
+		}
+
+		// Create a new in-memory TCP value.
+		u, connector, err := tcp.NewInMemory("traceID", "TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new in-memory TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to create a new in-memory TCP listener.", tests.Success)
+
+		// Start accepting client data.
+		if err := u.Start("traceID"); err != nil {
+			t.Fatal("\tShould be able to start the TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to start the TCP listener.", tests.Success)
+
+		defer u.Stop("traceID")
+
+		conn, err := connector.Connect()
+		if err != nil {
+			t.Fatal("\tShould be able to connect in memory.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to connect in memory.", tests.Success)
+
+		defer conn.Close()
+
+		for i := 0; len(u.Connections()) != 1; i++ {
+			if i == 100 {
+				t.Fatal("\tShould have the connection joined.", tests.Failed)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Log("\tShould have the connection joined.", tests.Success)
+
+		bufReader := bufio.NewReader(conn)
+
+		// send writes a response and waits for it to be done with.
+		send := func(data string) error {
+			resp := tcp.Response{
+				TCPAddr: conn.LocalAddr().(*net.TCPAddr),
+				Data:    []byte(data),
+				Length:  len(data),
+				Sent:    make(chan error, 1),
+			}
+			if err := u.Do("traceID", &resp); err != nil {
+				return err
+			}
+
+			if data != "FAIL\n" {
+				if response, err := bufReader.ReadString('\n'); err != nil || response != data {
+					return fmt.Errorf("got %q, %v", response, err)
+				}
+			}
+
+			return <-resp.Sent
+		}
+
+		for _, data := range []string{"FAIL\n", "GOT IT\n", "FAIL\n"} {
+			if err := send(data); (err == nil) != (data == "GOT IT\n") {
+				t.Fatalf("\tShould only fail to write %q. %s %v", data, tests.Failed, err)
+			}
+			t.Logf("\tShould only fail to write %q. %s", data, tests.Success)
+		}
+
+		if n := len(u.Connections()); n != 1 {
+			t.Fatal("\tShould keep a connection when a write succeeds between errors.", tests.Failed, n)
+		}
+		t.Log("\tShould keep a connection when a write succeeds between errors.", tests.Success)
+
+		send("FAIL\n")
+
+		if _, err := bufReader.ReadByte(); err == nil {
+			t.Fatal("\tShould drop the connection after too many errors in a row.", tests.Failed)
+		}
+		t.Log("\tShould drop the connection after too many errors in a row.", tests.Success)
+
+		if reason := <-reasons; reason != tcp.CloseWriteErrors {
+			t.Fatal("\tShould report the write errors reason.", tests.Failed, reason)
+		}
+		t.Log("\tShould report the write errors reason.", tests.Success)
+	}
+}
+
+// TestAcceptNilConn tests a listener that returns no connection and no
+// error doesn't stop connections from being accepted.
+func TestAcceptNilConn(t *testing.T) {
+	tests.ResetLog()
+	defer tests.DisplayLog()
+
+	t.Log("Given the need to survive a listener that returns no connection.")
+	{
+		l, err := net.Listen("tcp4", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal("\tShould be able to listen.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to listen.", tests.Success)
+
+		nl := tcpNilListener{Listener: l, nils: 3}
+
+		// Create a configuration.
+		cfg := tcp.Config{
+			NetType: "tcp4",
+			Addr:    l.Addr().String(),
+
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  tcpReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptIntPool: tcp.OptIntPool{
+				RecvMinPoolSize: func() int { return 2 },
+				RecvMaxPoolSize: func() int { return 1000 },
+				SendMinPoolSize: func() int { return 2 },
+				SendMaxPoolSize: func() int { return 1000 },
+			},
+		}
+
+		u, err := tcp.New("traceID", "TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to create a new TCP listener.", tests.Success)
+
+		u.UseListener(&nl)
+
+		// Start accepting client data.
+		if err := u.Start("traceID"); err != nil {
+			t.Fatal("\tShould be able to start the TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to start the TCP listener.", tests.Success)
+
+		defer u.Stop("traceID")
+
+		conn, err := net.Dial("tcp4", l.Addr().String())
+		if err != nil {
+			t.Fatal("\tShould be able to dial a new TCP connection.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to dial a new TCP connection.", tests.Success)
+
+		defer conn.Close()
+
+		conn.Write([]byte("Hello\n"))
+
+		if response, err := bufio.NewReader(conn).ReadString('\n'); err != nil || response != "GOT IT\n" {
+			t.Fatal("\tShould receive the string \"GOT IT\" after the listener returned no connection.", tests.Failed, response, err)
+		}
+		t.Log("\tShould receive the string \"GOT IT\" after the listener returned no connection.", tests.Success)
+
+		if n := atomic.LoadInt32(&nl.calls); n <= nl.nils {
+			t.Fatal("\tShould keep calling Accept.", tests.Failed, n)
+		}
+		t.Log("\tShould keep calling Accept.", tests.Success)
+	}
+}
+
+// TestDropRates tests drops are reported as a rate over the last minute.
+func TestDropRates(t *testing.T) {
+	tests.ResetLog()
+	defer tests.DisplayLog()
+
+	t.Log("Given the need to report the rate connections are dropped by reason.")
+	{
+		now := time.Now()
+		events := []time.Time{
+			now.Add(-2 * time.Minute),
+			now.Add(-61 * time.Second),
+			now.Add(-59 * time.Second),
+			now.Add(-time.Second),
+			now,
+		}
+
+		if rate := tcp.RollingRate(events, now); rate != 3.0/60 {
+			t.Fatal("\tShould only count the events in the last minute.", tests.Failed, rate)
+		}
+		t.Log("\tShould only count the events in the last minute.", tests.Success)
+
+		if rate := tcp.RollingRate(events, now.Add(30*time.Second)); rate != 2.0/60 {
+			t.Fatal("\tShould stop counting events as the window moves.", tests.Failed, rate)
+		}
+		t.Log("\tShould stop counting events as the window moves.", tests.Success)
+
+		reasons := make(chan string, 3)
+
+		// Create a configuration.
+		cfg := tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  tcpReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptIntPool: tcp.OptIntPool{
+				RecvMinPoolSize: func() int { return 2 },
+				RecvMaxPoolSize: func() int { return 1000 },
+				SendMinPoolSize: func() int { return 2 },
+				SendMaxPoolSize: func() int { return 1000 },
+			},
+
+			OptAdmit: tcp.OptAdmit{
+				AcceptFilters: []tcp.AcceptFilter{
+					func(conn net.Conn) (bool, string) { return false, "blocked" },
+				},
+			},
+
+			OptDrop: tcp.OptDrop{
+				OnDrop: func(reason string, remoteAddr string) {
+					reasons <- reason
+				},
+			},
+		}
+
+		// Create a new in-memory TCP value.
+		u, connector, err := tcp.NewInMemory("traceID", "TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new in-memory TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to create a new in-memory TCP listener.", tests.Success)
+
+		// Start accepting client data.
+		if err := u.Start("traceID"); err != nil {
+			t.Fatal("\tShould be able to start the TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to start the TCP listener.", tests.Success)
+
+		defer u.Stop("traceID")
+
+		if rates := u.DropRates(); len(rates) != 0 {
+			t.Fatal("\tShould have no drop rates before a drop.", tests.Failed, rates)
+		}
+		t.Log("\tShould have no drop rates before a drop.", tests.Success)
+
+		for i := 0; i < 3; i++ {
+			conn, err := connector.Connect()
+			if err != nil {
+				t.Fatal("\tShould be able to connect in memory.", tests.Failed, err)
+			}
+			defer conn.Close()
+			<-reasons
+		}
+		t.Log("\tShould be able to connect in memory.", tests.Success)
+
+		if rate := u.DropRates()["blocked"]; rate != 3.0/60 {
+			t.Fatal("\tShould report the drops per second over the last minute.", tests.Failed, rate)
+		}
+		t.Log("\tShould report the drops per second over the last minute.", tests.Success)
+	}
+}
+
+// TestBroadcastFunc tests we can send a response to the clients that
+// match a predicate.
+func TestBroadcastFunc(t *testing.T) {
+	tests.ResetLog()
+	defer tests.DisplayLog()
+
+	t.Log("Given the need to send data to the clients matching a predicate.")
+	{
+		// Create a configuration.
+		cfg := tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  tcpReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptIntPool: tcp.OptIntPool{
+				RecvMinPoolSize: func() int { return 2 },
+				RecvMaxPoolSize: func() int { return 1000 },
+				SendMinPoolSize: func() int { return 2 },
+				SendMaxPoolSize: func() int { return 1000 },
+			},
+		}
+
+		// Create a new in-memory TCP value.
+		u, connector, err := tcp.NewInMemory("traceID", "TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new in-memory TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to create a new in-memory TCP listener.", tests.Success)
+
+		// Start accepting client data.
+		if err := u.Start("traceID"); err != nil {
+			t.Fatal("\tShould be able to start the TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to start the TCP listener.", tests.Success)
+
+		defer u.Stop("traceID")
+
+		var conns []net.Conn
+		var readers []*bufio.Reader
+		for i := 0; i < 3; i++ {
+			conn, err := connector.Connect()
+			if err != nil {
+				t.Fatal("\tShould be able to connect in memory.", tests.Failed, err)
+			}
+			t.Log("\tShould be able to connect in memory.", tests.Success)
+
+			defer conn.Close()
+
+			conns = append(conns, conn)
+			readers = append(readers, bufio.NewReader(conn))
+		}
+
+		for len(u.Connections()) != 3 {
+			time.Sleep(time.Millisecond)
+		}
+
+		// Match every connection but the second one.
+		match := func(ci tcp.ConnInfo) bool {
+			return ci.Addr != "127.0.0.1:2"
+		}
+
+		sent, err := u.BroadcastFunc("traceID", match, []byte("MATCHED\n"))
+		if err != nil || sent != 2 {
+			t.Fatal("\tShould send to the two matching clients.", tests.Failed, sent, err)
+		}
+		t.Log("\tShould send to the two matching clients.", tests.Success)
+
+		for _, i := range []int{0, 2} {
+			if response, err := readers[i].ReadString('\n'); err != nil || response != "MATCHED\n" {
+				t.Fatal("\tShould receive the string \"MATCHED\".", tests.Failed, response, err)
+			}
+			t.Log("\tShould receive the string \"MATCHED\".", tests.Success)
+		}
+
+		// The next response the second client reads is for its own request.
+		go conns[1].Write([]byte("Hello\n"))
+
+		if response, err := readers[1].ReadString('\n'); err != nil || response != "GOT IT\n" {
+			t.Fatal("\tShould not send to the client that doesn't match.", tests.Failed, response, err)
+		}
+		t.Log("\tShould not send to the client that doesn't match.", tests.Success)
+
+		sent, err = u.BroadcastFunc("traceID", func(tcp.ConnInfo) bool { return false }, []byte("NONE\n"))
+		if err != nil || sent != 0 {
+			t.Fatal("\tShould send nothing when no client matches.", tests.Failed, sent, err)
+		}
+		t.Log("\tShould send nothing when no client matches.", tests.Success)
+	}
+}
+
+// TestEvents tests connections being added and removed are reported on
+// the events channel.
+func TestEvents(t *testing.T) {
+	tests.ResetLog()
+	defer tests.DisplayLog()
+
+	t.Log("Given the need to receive connect and disconnect events.")
+	{
+		// Create a configuration.
+		cfg := tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  tcpReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptIntPool: tcp.OptIntPool{
+				RecvMinPoolSize: func() int { return 2 },
+				RecvMaxPoolSize: func() int { return 1000 },
+				SendMinPoolSize: func() int { return 2 },
+				SendMaxPoolSize: func() int { return 1000 },
+			},
+		}
+
+		// Create a new in-memory TCP value.
+		u, connector, err := tcp.NewInMemory("traceID", "TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new in-memory TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to create a new in-memory TCP listener.", tests.Success)
+
+		// Start accepting client data.
+		if err := u.Start("traceID"); err != nil {
+			t.Fatal("\tShould be able to start the TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to start the TCP listener.", tests.Success)
+
+		defer u.Stop("traceID")
+
+		var ids []uint64
+		for i := 0; i < 2; i++ {
+			conn, err := connector.Connect()
+			if err != nil {
+				t.Fatal("\tShould be able to connect in memory.", tests.Failed, err)
+			}
+			t.Log("\tShould be able to connect in memory.", tests.Success)
+
+			e := <-u.Events()
+			if e.Type != tcp.Connected || e.Addr != fmt.Sprintf("127.0.0.1:%d", i+1) || e.Time.IsZero() {
+				t.Fatal("\tShould receive a connected event.", tests.Failed, e)
+			}
+			t.Log("\tShould receive a connected event.", tests.Success)
+
+			ids = append(ids, e.ID)
+
+			conn.Close()
+
+			e = <-u.Events()
+			if e.Type != tcp.Disconnected || e.ID != ids[i] || e.Reason != tcp.CloseClientEOF {
+				t.Fatal("\tShould receive a disconnected event with the reason.", tests.Failed, e)
+			}
+			t.Log("\tShould receive a disconnected event with the reason.", tests.Success)
+		}
+
+		if ids[0] == ids[1] {
+			t.Fatal("\tShould give each connection its own ID.", tests.Failed, ids)
+		}
+		t.Log("\tShould give each connection its own ID.", tests.Success)
+	}
+}
+
+// TestLinger tests a linger of 0 resets connections when they are closed.
+func TestLinger(t *testing.T) {
+	tests.ResetLog()
+	defer tests.DisplayLog()
+
+	t.Log("Given the need to control what closing a connection does with unsent data.")
+	{
+		linger := -1
+
+		// Create a configuration.
+		cfg := tcp.Config{
+			NetType: "tcp4",
+			Addr:    "127.0.0.1:0",
+
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  tcpReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptIntPool: tcp.OptIntPool{
+				RecvMinPoolSize: func() int { return 2 },
+				RecvMaxPoolSize: func() int { return 1000 },
+				SendMinPoolSize: func() int { return 2 },
+				SendMaxPoolSize: func() int { return 1000 },
+			},
+
+			OptListen: tcp.OptListen{
+				Linger: &linger,
+			},
+		}
+
+		if _, err := tcp.New("traceID", "TEST", cfg); err != tcp.ErrInvalidLinger {
+			t.Fatal("\tShould not be able to use a negative linger.", tests.Failed, err)
+		}
+		t.Log("\tShould not be able to use a negative linger.", tests.Success)
+
+		linger = 0
+
+		// Create a new TCP value.
+		u, err := tcp.New("traceID", "TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to create a new TCP listener.", tests.Success)
+
+		// Start accepting client data.
+		if err := u.Start("traceID"); err != nil {
+			t.Fatal("\tShould be able to start the TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to start the TCP listener.", tests.Success)
+
+		defer u.Stop("traceID")
+
+		conn, err := net.Dial("tcp4", u.Addr().String())
+		if err != nil {
+			t.Fatal("\tShould be able to dial a new TCP connection.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to dial a new TCP connection.", tests.Success)
+
+		defer conn.Close()
+
+		for len(u.Connections()) != 1 {
+			time.Sleep(time.Millisecond)
+		}
+
+		if err := u.DropConnection("traceID", conn.LocalAddr().String()); err != nil {
+			t.Fatal("\tShould be able to drop the connection.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to drop the connection.", tests.Success)
+
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		if _, err := conn.Read(make([]byte, 1)); err == nil || err == io.EOF || !strings.Contains(err.Error(), "reset") {
+			t.Fatal("\tShould have the connection reset instead of closed.", tests.Failed, err)
+		}
+		t.Log("\tShould have the connection reset instead of closed.", tests.Success)
+	}
+}
+
+// TestDuplicateWait tests a connection from an address that is still
+// connected waits for the old connection to be removed.
+func TestDuplicateWait(t *testing.T) {
+	tests.ResetLog()
+	defer tests.DisplayLog()
+
+	t.Log("Given the need to accept a client reconnecting before its old connection is removed.")
+	{
+		// Create a configuration.
+		cfg := tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  tcpReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptIntPool: tcp.OptIntPool{
+				RecvMinPoolSize: func() int { return 2 },
+				RecvMaxPoolSize: func() int { return 1000 },
+				SendMinPoolSize: func() int { return 2 },
+				SendMaxPoolSize: func() int { return 1000 },
+			},
+
+			OptTimeout: tcp.OptTimeout{
+				DuplicateWait: -1,
+			},
+
+			// Every connection comes from the same address.
+			OptWrap: tcp.OptWrap{
+				WrapConn: func(conn net.Conn) net.Conn {
+					return tcpNamedConn{Conn: conn, name: "client-1"}
+				},
+			},
+		}
+
+		if _, _, err := tcp.NewInMemory("traceID", "TEST", cfg); err != tcp.ErrInvalidDuplicateWait {
+			t.Fatal("\tShould not be able to use a negative duplicate wait.", tests.Failed, err)
+		}
+		t.Log("\tShould not be able to use a negative duplicate wait.", tests.Success)
+
+		cfg.DuplicateWait = 200 * time.Millisecond
+
+		// Create a new in-memory TCP value.
+		u, connector, err := tcp.NewInMemory("traceID", "TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new in-memory TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to create a new in-memory TCP listener.", tests.Success)
+
+		// Start accepting client data.
+		if err := u.Start("traceID"); err != nil {
+			t.Fatal("\tShould be able to start the TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to start the TCP listener.", tests.Success)
+
+		defer u.Stop("traceID")
+
+		old, err := connector.Connect()
+		if err != nil {
+			t.Fatal("\tShould be able to connect in memory.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to connect in memory.", tests.Success)
+
+		for len(u.Connections()) != 1 {
+			time.Sleep(time.Millisecond)
+		}
+
+		conn, err := connector.Connect()
+		if err != nil {
+			t.Fatal("\tShould be able to connect in memory.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to connect in memory.", tests.Success)
+
+		defer conn.Close()
+
+		// Remove the old connection while the new one waits to join.
+		time.Sleep(20 * time.Millisecond)
+		old.Close()
+
+		for u.AcceptedTotal() != 2 {
+			time.Sleep(time.Millisecond)
+		}
+
+		go u.DoMulti("traceID", []string{"client-1"}, []byte("MULTI\n"))
+
+		if response, err := bufio.NewReader(conn).ReadString('\n'); err != nil || response != "MULTI\n" {
+			t.Fatal("\tShould accept the new connection once the old one is removed.", tests.Failed, response, err)
+		}
+		t.Log("\tShould accept the new connection once the old one is removed.", tests.Success)
+
+		dup, err := connector.Connect()
+		if err != nil {
+			t.Fatal("\tShould be able to connect in memory.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to connect in memory.", tests.Success)
+
+		defer dup.Close()
+
+		if _, err := dup.Read(make([]byte, 1)); err == nil {
+			t.Fatal("\tShould drop the connection once the wait is over.", tests.Failed)
+		}
+		t.Log("\tShould drop the connection once the wait is over.", tests.Success)
+
+		if n := u.Drops()[tcp.DropReasonDuplicate]; n != 1 {
+			t.Fatal("\tShould count one duplicate dropped.", tests.Failed, n)
+		}
+		t.Log("\tShould count one duplicate dropped.", tests.Success)
+	}
+}
+
+// TestStatsDelta tests the counters are reported as the change since the
+// previous call.
+func TestStatsDelta(t *testing.T) {
+	tests.ResetLog()
+	defer tests.DisplayLog()
+
+	t.Log("Given the need to report the change in the stats between calls.")
+	{
+		reasons := make(chan string, 1)
+
+		// Create a configuration.
+		cfg := tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  tcpReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptIntPool: tcp.OptIntPool{
+				RecvMinPoolSize: func() int { return 2 },
+				RecvMaxPoolSize: func() int { return 1000 },
+				SendMinPoolSize: func() int { return 2 },
+				SendMaxPoolSize: func() int { return 1000 },
+			},
+
+			// Drop the second connection.
+			OptAdmit: tcp.OptAdmit{
+				AcceptFilters: []tcp.AcceptFilter{
+					func(conn net.Conn) (bool, string) {
+						return conn.RemoteAddr().(*net.TCPAddr).Port != 2, "blocked"
+					},
+				},
+			},
+
+			OptDrop: tcp.OptDrop{
+				OnDrop: func(reason string, remoteAddr string) {
+					reasons <- reason
+				},
+			},
+		}
+
+		// Create a new in-memory TCP value.
+		u, connector, err := tcp.NewInMemory("traceID", "TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new in-memory TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to create a new in-memory TCP listener.", tests.Success)
+
+		// Start accepting client data.
+		if err := u.Start("traceID"); err != nil {
+			t.Fatal("\tShould be able to start the TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to start the TCP listener.", tests.Success)
+
+		defer u.Stop("traceID")
+
+		conn, err := connector.Connect()
+		if err != nil {
+			t.Fatal("\tShould be able to connect in memory.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to connect in memory.", tests.Success)
+
+		defer conn.Close()
+
+		go conn.Write([]byte("Hello\n"))
+
+		if response, err := bufio.NewReader(conn).ReadString('\n'); err != nil || response != "GOT IT\n" {
+			t.Fatal("\tShould receive the string \"GOT IT\".", tests.Failed, response, err)
+		}
+		t.Log("\tShould receive the string \"GOT IT\".", tests.Success)
+
+		dropped, err := connector.Connect()
+		if err != nil {
+			t.Fatal("\tShould be able to connect in memory.", tests.Failed, err)
+		}
+		defer dropped.Close()
+		<-reasons
+
+		for u.StatsRecv().Executed == 0 {
+			time.Sleep(time.Millisecond)
+		}
+
+		d := u.StatsDelta()
+		if d.AcceptedTotal != 1 || d.Drops["blocked"] != 1 || d.RecvExecuted != 1 || d.Interval <= 0 {
+			t.Fatal("\tShould report the counters since New on the first call.", tests.Failed, d)
+		}
+		t.Log("\tShould report the counters since New on the first call.", tests.Success)
+
+		if d.Current.Connections != 1 {
+			t.Fatal("\tShould report the current number of connections.", tests.Failed, d.Current.Connections)
+		}
+		t.Log("\tShould report the current number of connections.", tests.Success)
+
+		other, err := connector.Connect()
+		if err != nil {
+			t.Fatal("\tShould be able to connect in memory.", tests.Failed, err)
+		}
+		defer other.Close()
+
+		for u.AcceptedTotal() != 2 {
+			time.Sleep(time.Millisecond)
+		}
+
+		d = u.StatsDelta()
+		if d.AcceptedTotal != 1 || len(d.Drops) != 0 || d.RecvExecuted != 0 || d.Current.AcceptedTotal != 2 {
+			t.Fatal("\tShould report only the change since the previous call.", tests.Failed, d)
+		}
+		t.Log("\tShould report only the change since the previous call.", tests.Success)
+	}
+}
+
+// TestMaxPendingResponses tests responses are rejected while too many are
+// waiting to be written across all clients.
+func TestMaxPendingResponses(t *testing.T) {
+	tests.ResetLog()
+	defer tests.DisplayLog()
+
+	t.Log("Given the need to cap the responses waiting to be written.")
+	{
+		// Create a configuration.
+		cfg := tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  tcpReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptIntPool: tcp.OptIntPool{
+				RecvMinPoolSize: func() int { return 2 },
+				RecvMaxPoolSize: func() int { return 1000 },
+				SendMinPoolSize: func() int { return 2 },
+				SendMaxPoolSize: func() int { return 1000 },
+			},
+
+			OptBackpressure: tcp.OptBackpressure{
+				MaxPendingResponses: 2,
+			},
+		}
+
+		// Create a new in-memory TCP value.
+		u, connector, err := tcp.NewInMemory("traceID", "TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new in-memory TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to create a new in-memory TCP listener.", tests.Success)
+
+		// Start accepting client data.
+		if err := u.Start("traceID"); err != nil {
+			t.Fatal("\tShould be able to start the TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to start the TCP listener.", tests.Success)
+
+		defer u.Stop("traceID")
+
+		conn, err := connector.Connect()
+		if err != nil {
+			t.Fatal("\tShould be able to connect in memory.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to connect in memory.", tests.Success)
+
+		defer conn.Close()
+
+		for len(u.Connections()) != 1 {
+			time.Sleep(time.Millisecond)
+		}
+
+		// The client isn't reading, so the responses stay pending.
+		addrs := []string{"127.0.0.1:1"}
+		for i := 0; i < 2; i++ {
+			if errs := u.DoMulti("traceID", addrs, []byte("PENDING\n")); len(errs) != 0 {
+				t.Fatal("\tShould be able to send a response under the limit.", tests.Failed, errs)
+			}
+		}
+		t.Log("\tShould be able to send a response under the limit.", tests.Success)
+
+		if n := u.Stats().PendingResponses; n != 2 {
+			t.Fatal("\tShould report two responses pending.", tests.Failed, n)
+		}
+		t.Log("\tShould report two responses pending.", tests.Success)
+
+		if errs := u.DoMulti("traceID", addrs, []byte("PENDING\n")); errs[addrs[0]] != tcp.ErrTooManyPending {
+			t.Fatal("\tShould reject a response at the limit.", tests.Failed, errs)
+		}
+		t.Log("\tShould reject a response at the limit.", tests.Success)
+
+		all := func(tcp.ConnInfo) bool { return true }
+		if sent, err := u.BroadcastFunc("traceID", all, []byte("PENDING\n")); sent != 0 || err != tcp.ErrTooManyPending {
+			t.Fatal("\tShould reject a broadcast at the limit.", tests.Failed, sent, err)
+		}
+		t.Log("\tShould reject a broadcast at the limit.", tests.Success)
+
+		bufReader := bufio.NewReader(conn)
+		for i := 0; i < 2; i++ {
+			if response, err := bufReader.ReadString('\n'); err != nil || response != "PENDING\n" {
+				t.Fatal("\tShould receive the string \"PENDING\".", tests.Failed, response, err)
+			}
+		}
+		t.Log("\tShould receive the string \"PENDING\".", tests.Success)
+
+		for u.Stats().PendingResponses != 0 {
+			time.Sleep(time.Millisecond)
+		}
+
+		if errs := u.DoMulti("traceID", addrs, []byte("PENDING\n")); len(errs) != 0 {
+			t.Fatal("\tShould be able to send a response once the pending ones are written.", tests.Failed, errs)
+		}
+		t.Log("\tShould be able to send a response once the pending ones are written.", tests.Success)
+
+		if response, err := bufReader.ReadString('\n'); err != nil || response != "PENDING\n" {
+			t.Fatal("\tShould receive the string \"PENDING\".", tests.Failed, response, err)
+		}
+		t.Log("\tShould receive the string \"PENDING\".", tests.Success)
+	}
+}
+
+// TestHijack tests a request can take over its connection as a raw byte
+// stream.
+func TestHijack(t *testing.T) {
+	tests.ResetLog()
+	defer tests.DisplayLog()
+
+	t.Log("Given the need to hijack a connection from a request.")
+	{
+		errs := make(chan error, 1)
+		reasons := make(chan tcp.CloseReason, 1)
+
+		// Create a configuration.
+		cfg := tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  tcpHijackReqHandler{errs: errs},
+			RespHandler: tcpRespHandler{},
+
+			OptIntPool: tcp.OptIntPool{
+				RecvMinPoolSize: func() int { return 2 },
+				RecvMaxPoolSize: func() int { return 1000 },
+				SendMinPoolSize: func() int { return 2 },
+				SendMaxPoolSize: func() int { return 1000 },
+			},
+
+			OptDisconnect: tcp.OptDisconnect{
+				OnDisconnect: func(remoteAddr string, reason tcp.CloseReason) {
+					reasons <- reason
+				},
+			},
+		}
+
+		// Create a new in-memory TCP value.
+		u, connector, err := tcp.NewInMemory("traceID", "TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new in-memory TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to create a new in-memory TCP listener.", tests.Success)
+
+		// Start accepting client data.
+		if err := u.Start("traceID"); err != nil {
+			t.Fatal("\tShould be able to start the TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to start the TCP listener.", tests.Success)
+
+		defer u.Stop("traceID")
+
+		conn, err := connector.Connect()
+		if err != nil {
+			t.Fatal("\tShould be able to connect in memory.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to connect in memory.", tests.Success)
+
+		defer conn.Close()
+
+		bufReader := bufio.NewReader(conn)
+
+		go conn.Write([]byte("Hello\n"))
+
+		if response, err := bufReader.ReadString('\n'); err != nil || response != "GOT IT\n" {
+			t.Fatal("\tShould receive the string \"GOT IT\" before the hijack.", tests.Failed, response, err)
+		}
+		t.Log("\tShould receive the string \"GOT IT\" before the hijack.", tests.Success)
+
+		go conn.Write([]byte("HIJACK\n"))
+
+		if err := <-errs; err != nil {
+			t.Fatal("\tShould be able to hijack the connection.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to hijack the connection.", tests.Success)
+
+		if response, err := bufReader.ReadString('\n'); err != nil || response != "HIJACKED\n" {
+			t.Fatal("\tShould receive the string \"HIJACKED\" on the raw connection.", tests.Failed, response, err)
+		}
+		t.Log("\tShould receive the string \"HIJACKED\" on the raw connection.", tests.Success)
+
+		if reason := <-reasons; reason != tcp.CloseHijacked {
+			t.Fatal("\tShould remove the connection as hijacked.", tests.Failed, reason)
+		}
+		t.Log("\tShould remove the connection as hijacked.", tests.Success)
+
+		if n := len(u.Connections()); n != 0 {
+			t.Fatal("\tShould no longer manage the connection.", tests.Failed, n)
+		}
+		t.Log("\tShould no longer manage the connection.", tests.Success)
+
+		// The raw connection echoes what it is sent, which a request
+		// would have answered with "GOT IT".
+		go conn.Write([]byte("Hello\n"))
+
+		if response, err := bufReader.ReadString('\n'); err != nil || response != "Hello\n" {
+			t.Fatal("\tShould be able to use the raw connection.", tests.Failed, response, err)
+		}
+		t.Log("\tShould be able to use the raw connection.", tests.Success)
+	}
+}
+
+// TestFlushInterval tests responses are flushed on a timer instead of
+// after each response.
+func TestFlushInterval(t *testing.T) {
+	tests.ResetLog()
+	defer tests.DisplayLog()
+
+	t.Log("Given the need to batch responses and flush them within an interval.")
+	{
+		wrapped := make(chan *tcpCountConn, 1)
+
+		// Create a configuration.
+		cfg := tcp.Config{
+			ConnHandler: tcpConnWriterHandler{},
+			ReqHandler:  tcpReqHandler{},
+			RespHandler: tcpChattyRespHandler{},
+
+			OptIntPool: tcp.OptIntPool{
+				RecvMinPoolSize: func() int { return 2 },
+				RecvMaxPoolSize: func() int { return 1000 },
+				SendMinPoolSize: func() int { return 2 },
+				SendMaxPoolSize: func() int { return 1000 },
+			},
+
+			OptBuffer: tcp.OptBuffer{
+				WriteBufferSize: 64,
+				FlushInterval:   -1,
+			},
+
+			OptWrap: tcp.OptWrap{
+				WrapConn: func(conn net.Conn) net.Conn {
+					c := tcpCountConn{Conn: conn}
+					wrapped <- &c
+					return &c
+				},
+			},
+		}
+
+		if _, _, err := tcp.NewInMemory("traceID", "TEST", cfg); err != tcp.ErrInvalidFlushInterval {
+			t.Fatal("\tShould not be able to use a negative flush interval.", tests.Failed, err)
+		}
+		t.Log("\tShould not be able to use a negative flush interval.", tests.Success)
+
+		cfg.FlushInterval = 200 * time.Millisecond
+
+		// Create a new in-memory TCP value.
+		u, connector, err := tcp.NewInMemory("traceID", "TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new in-memory TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to create a new in-memory TCP listener.", tests.Success)
+
+		// Start accepting client data.
+		if err := u.Start("traceID"); err != nil {
+			t.Fatal("\tShould be able to start the TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to start the TCP listener.", tests.Success)
+
+		defer u.Stop("traceID")
+
+		conn, err := connector.Connect()
+		if err != nil {
+			t.Fatal("\tShould be able to connect in memory.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to connect in memory.", tests.Success)
+
+		defer conn.Close()
+
+		cc := <-wrapped
+		for len(u.Connections()) != 1 {
+			time.Sleep(time.Millisecond)
+		}
+
+		// Nothing is read until the responses are flushed, so they
+		// are all waiting in the buffer.
+		start := time.Now()
+		for _, data := range []string{"ONE\n", "TWO\n", "THREE\n"} {
+			if errs := u.DoMulti("traceID", []string{"127.0.0.1:1"}, []byte(data)); len(errs) != 0 {
+				t.Fatal("\tShould be able to send a response.", tests.Failed, errs)
+			}
+		}
+		t.Log("\tShould be able to send a response.", tests.Success)
+
+		for !tcpIdle(u, conn) {
+			time.Sleep(time.Millisecond)
+		}
+
+		// Responses for the same client are not ordered.
+		got := make(map[string]bool)
+		bufReader := bufio.NewReader(conn)
+		for i := 0; i < 3; i++ {
+			response, err := bufReader.ReadString('\n')
+			if err != nil {
+				t.Fatal("\tShould receive the responses once flushed.", tests.Failed, err)
+			}
+			got[response] = true
+		}
+		if !got["ONE\n"] || !got["TWO\n"] || !got["THREE\n"] {
+			t.Fatal("\tShould receive the responses once flushed.", tests.Failed, got)
+		}
+		t.Log("\tShould receive the responses once flushed.", tests.Success)
+
+		if d := time.Since(start); d > 2*time.Second {
+			t.Fatal("\tShould flush within the interval.", tests.Failed, d)
+		}
+		t.Log("\tShould flush within the interval.", tests.Success)
+
+		// The counts are updated once the write returns.
+		for i := 0; atomic.LoadInt64(&cc.writes) == 0 && i < 100; i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		if n, written := atomic.LoadInt64(&cc.writes), atomic.LoadInt64(&cc.written); n != 1 || written != 14 {
+			t.Fatal("\tShould write the responses to the connection once.", tests.Failed, n, written)
+		}
+		t.Log("\tShould write the responses to the connection once.", tests.Success)
+	}
+}
+
+// TestTLSVersionRejected tests clients that only support TLS versions
+// below MinVersion are reported as dropped for their version.
+func TestTLSVersionRejected(t *testing.T) {
+	tests.ResetLog()
+	defer tests.DisplayLog()
+
+	t.Log("Given the need to audit clients attempting old TLS versions.")
+	{
+		cert, err := newCertificate("good.example")
+		if err != nil {
+			t.Fatal("\tShould be able to create a certificate.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to create a certificate.", tests.Success)
+
+		reasons := make(chan string, 1)
+
+		var binds int64
+
+		// Create a configuration.
+		cfg := tcp.Config{
+			ConnHandler: tcpCountBindHandler{binds: &binds},
+			ReqHandler:  tcpReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptIntPool: tcp.OptIntPool{
+				RecvMinPoolSize: func() int { return 2 },
+				RecvMaxPoolSize: func() int { return 1000 },
+				SendMinPoolSize: func() int { return 2 },
+				SendMaxPoolSize: func() int { return 1000 },
+			},
+
+			OptTLS: tcp.OptTLS{
+				TLSConfig: &tls.Config{
+					Certificates: []tls.Certificate{cert},
+					MinVersion:   tls.VersionTLS12,
+				},
+			},
+
+			OptDrop: tcp.OptDrop{
+				OnDrop: func(reason string, remoteAddr string) {
+					reasons <- reason
+				},
+			},
+		}
+
+		// Create a new in-memory TCP value.
+		u, connector, err := tcp.NewInMemory("traceID", "TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new in-memory TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to create a new in-memory TCP listener.", tests.Success)
+
+		// Start accepting client data.
+		if err := u.Start("traceID"); err != nil {
+			t.Fatal("\tShould be able to start the TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to start the TCP listener.", tests.Success)
+
+		defer u.Stop("traceID")
+
+		connect := func(maxVersion uint16) (*tls.Conn, error) {
+			conn, err := connector.Connect()
+			if err != nil {
+				return nil, err
+			}
+
+			tc := tls.Client(conn, &tls.Config{
+				ServerName:         "good.example",
+				InsecureSkipVerify: true,
+				MinVersion:         tls.VersionTLS10,
+				MaxVersion:         maxVersion,
+			})
+			if err := tc.Handshake(); err != nil {
+				conn.Close()
+				return nil, err
+			}
+
+			return tc, nil
+		}
+
+		tc, err := connect(tls.VersionTLS13)
+		if err != nil {
+			t.Fatal("\tShould be able to connect with a current TLS version.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to connect with a current TLS version.", tests.Success)
+
+		defer tc.Close()
+
+		for i := 0; len(u.Connections()) != 1; i++ {
+			if i == 100 {
+				t.Fatal("\tShould have the connection joined.", tests.Failed)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Log("\tShould have the connection joined.", tests.Success)
+
+		if _, err := connect(tls.VersionTLS11); err == nil {
+			t.Fatal("\tShould not be able to connect with an old TLS version.", tests.Failed)
+		}
+		t.Log("\tShould not be able to connect with an old TLS version.", tests.Success)
+
+		select {
+		case reason := <-reasons:
+			if reason != tcp.DropReasonTLSVersion {
+				t.Fatal("\tShould report the TLS version drop reason.", tests.Failed, reason)
+			}
+			t.Log("\tShould report the TLS version drop reason.", tests.Success)
+
+		case <-time.After(time.Second):
+			t.Fatal("\tShould report the TLS version drop reason.", tests.Failed)
+		}
+
+		if n := u.Drops()[tcp.DropReasonTLSVersion]; n != 1 {
+			t.Fatal("\tShould count the connection rejected for its TLS version.", tests.Failed, n)
+		}
+		t.Log("\tShould count the connection rejected for its TLS version.", tests.Success)
+
+		if stat := u.Stats(); stat.AcceptedTotal != 1 || stat.AcceptedTLS != 1 {
+			t.Fatal("\tShould not count the rejected connection as accepted.", tests.Failed, stat.AcceptedTotal, stat.AcceptedTLS)
+		}
+		t.Log("\tShould not count the rejected connection as accepted.", tests.Success)
+
+		if n := atomic.LoadInt64(&binds); n != 1 {
+			t.Fatal("\tShould not bind the rejected connection.", tests.Failed, n)
+		}
+		t.Log("\tShould not bind the rejected connection.", tests.Success)
+
+		if n := len(u.Connections()); n != 1 {
+			t.Fatal("\tShould not add the rejected connection.", tests.Failed, n)
+		}
+		t.Log("\tShould not add the rejected connection.", tests.Success)
+	}
+}
+
+// TestNewResponse tests a response built from a request carries what is
+// needed to correlate it with the request.
+func TestNewResponse(t *testing.T) {
+	tests.ResetLog()
+	defer tests.DisplayLog()
+
+	t.Log("Given the need to correlate a response with its request.")
+	{
+		r := tcp.Request{
+			TCPAddr: &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9000},
+			ReadAt:  time.Now(),
+			Seq:     7,
+			Data:    []byte("Hello\n"),
+			Length:  6,
+		}
+
+		resp := r.NewResponse([]byte("GOT IT\n"))
+
+		if resp.Seq != r.Seq || !resp.ReadAt.Equal(r.ReadAt) {
+			t.Fatal("\tShould carry the sequence number and read time of the request.", tests.Failed, resp.Seq, resp.ReadAt)
+		}
+		t.Log("\tShould carry the sequence number and read time of the request.", tests.Success)
+
+		if resp.TCPAddr.String() != "127.0.0.1:9000" {
+			t.Fatal("\tShould be addressed to the client that sent the request.", tests.Failed, resp.TCPAddr)
+		}
+		t.Log("\tShould be addressed to the client that sent the request.", tests.Success)
+
+		if string(resp.Data) != "GOT IT\n" || resp.Length != 7 {
+			t.Fatal("\tShould hold the data and its length.", tests.Failed, string(resp.Data), resp.Length)
+		}
+		t.Log("\tShould hold the data and its length.", tests.Success)
+	}
+}
+
+// TestMaxRecvPending tests reading pauses while too many requests are
+// waiting on the recv pool and resumes once the pool catches up.
+func TestMaxRecvPending(t *testing.T) {
+	tests.ResetLog()
+	defer tests.DisplayLog()
+
+	t.Log("Given the need to stop reading while the recv pool is saturated.")
+	{
+		h := tcpHoldReqHandler{
+			started: make(chan uint64, 3),
+			release: make(chan struct{}),
+		}
+
+		// Create a configuration with a single recv routine.
+		cfg := tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  h,
+			RespHandler: tcpRespHandler{},
+
+			OptIntPool: tcp.OptIntPool{
+				RecvMinPoolSize: func() int { return 1 },
+				RecvMaxPoolSize: func() int { return 1 },
+				SendMinPoolSize: func() int { return 2 },
+				SendMaxPoolSize: func() int { return 1000 },
+			},
+
+			OptBackpressure: tcp.OptBackpressure{
+				MaxRecvPending: 1,
+			},
+		}
+
+		// Create a new in-memory TCP value.
+		u, connector, err := tcp.NewInMemory("traceID", "TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new in-memory TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to create a new in-memory TCP listener.", tests.Success)
+
+		// Start accepting client data.
+		if err := u.Start("traceID"); err != nil {
+			t.Fatal("\tShould be able to start the TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to start the TCP listener.", tests.Success)
+
+		defer u.Stop("traceID")
+
+		var conns []net.Conn
+		for i := 0; i < 3; i++ {
+			conn, err := connector.Connect()
+			if err != nil {
+				t.Fatal("\tShould be able to connect in memory.", tests.Failed, err)
+			}
+			t.Log("\tShould be able to connect in memory.", tests.Success)
+
+			defer conn.Close()
+			conns = append(conns, conn)
+		}
+
+		var readers []*bufio.Reader
+		for _, conn := range conns {
+			readers = append(readers, bufio.NewReader(conn))
+		}
+
+		// The first request holds the only recv routine.
+		go conns[0].Write([]byte("Hello\n"))
+		<-h.started
+
+		// The second and third requests wait on the recv pool in order.
+		go conns[1].Write([]byte("Hello\n"))
+		for u.StatsRecv().Pending != 1 {
+			time.Sleep(time.Millisecond)
+		}
+		go conns[2].Write([]byte("Hello\n"))
+		for u.StatsRecv().Pending != 2 {
+			time.Sleep(time.Millisecond)
+		}
+
+		// Once the second request is accepted the third is still waiting,
+		// so the second connection must not read again.
+		h.release <- struct{}{}
+		<-h.started
+
+		// The in-memory write only returns once the request is read.
+		written := make(chan struct{})
+		go func() {
+			conns[1].Write([]byte("Hello\n"))
+			close(written)
+		}()
+
+		select {
+		case <-written:
+			t.Fatal("\tShould not read while the recv pool is saturated.", tests.Failed)
+		case <-time.After(100 * time.Millisecond):
+			t.Log("\tShould not read while the recv pool is saturated.", tests.Success)
+		}
+
+		// Let the third request be accepted so the pool catches up.
+		h.release <- struct{}{}
+		<-h.started
+
+		select {
+		case <-written:
+			t.Log("\tShould resume reading once the recv pool catches up.", tests.Success)
+		case <-time.After(time.Second):
+			t.Fatal("\tShould resume reading once the recv pool catches up.", tests.Failed)
+		}
+
+		h.release <- struct{}{}
+		<-h.started
+		h.release <- struct{}{}
+
+		for i, n := range []int{1, 2, 1} {
+			if response, err := readers[n].ReadString('\n'); err != nil || response != "GOT IT\n" {
+				t.Fatal("\tShould receive the string \"GOT IT\".", tests.Failed, i, response, err)
+			}
+		}
+		if response, err := readers[0].ReadString('\n'); err != nil || response != "GOT IT\n" {
+			t.Fatal("\tShould receive the string \"GOT IT\".", tests.Failed, response, err)
+		}
+		t.Log("\tShould receive the string \"GOT IT\".", tests.Success)
+	}
+}
+
+// TestWriteError tests a write that fails is reported in the response
+// when the RespHandler doesn't report it.
+func TestWriteError(t *testing.T) {
+	tests.ResetLog()
+	defer tests.DisplayLog()
+
+	t.Log("Given the need to know a response failed to be written.")
+	{
+		// Create a configuration with writes that fail.
+		cfg := tcp.Config{
+			ConnHandler: tcpConnWriterHandler{},
+			ReqHandler:  tcpReqHandler{},
+			RespHandler: tcpUncheckedRespHandler{},
+
+			OptIntPool: tcp.OptIntPool{
+				RecvMinPoolSize: func() int { return 2 },
+				RecvMaxPoolSize: func() int { return 1000 },
+				SendMinPoolSize: func() int { return 2 },
+				SendMaxPoolSize: func() int { return 1000 },
+			},
+
+			WrapConn: func(conn net.Conn) net.Conn {
+				return tcpFailWriteConn{conn}
+			},
+		}
+
+		// Create a new in-memory TCP value.
+		u, connector, err := tcp.NewInMemory("traceID", "TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new in-memory TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to create a new in-memory TCP listener.", tests.Success)
+
+		// Start accepting client data.
+		if err := u.Start("traceID"); err != nil {
+			t.Fatal("\tShould be able to start the TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to start the TCP listener.", tests.Success)
+
+		defer u.Stop("traceID")
+
+		conn, err := connector.Connect()
+		if err != nil {
+			t.Fatal("\tShould be able to connect in memory.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to connect in memory.", tests.Success)
+
+		defer conn.Close()
+
+		// Wait for the connection to join.
+		for i := 0; len(u.Connections()) == 0; i++ {
+			if i == 100 {
+				t.Fatal("\tShould see the connection join.", tests.Failed)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Log("\tShould see the connection join.", tests.Success)
+
+		resp := tcp.Response{
+			TCPAddr: conn.LocalAddr().(*net.TCPAddr),
+			Data:    []byte("PUSH\n"),
+			Length:  5,
+			Sent:    make(chan error, 1),
+		}
+
+		if err := u.Do("traceID", &resp); err != nil {
+			t.Fatal("\tShould be able to send the response.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to send the response.", tests.Success)
+
+		select {
+		case err := <-resp.Sent:
+			if err != errWriteRefused {
+				t.Fatal("\tShould report the write failed.", tests.Failed, err)
+			}
+			t.Log("\tShould report the write failed.", tests.Success)
+
+		case <-time.After(time.Second):
+			t.Fatal("\tShould report the write failed.", tests.Failed, "timeout")
+		}
+	}
+}
+
+// TestMaxWriteErrorsConn tests a connection is dropped once writes to the
+// connection fail too many times in a row, when the RespHandler doesn't
+// report the errors itself.
+func TestMaxWriteErrorsConn(t *testing.T) {
+	tests.ResetLog()
+	defer tests.DisplayLog()
+
+	t.Log("Given the need to drop connections that refuse writes.")
+	{
+		reasons := make(chan tcp.CloseReason, 1)
+
+		// Create a configuration with writes that fail.
+		cfg := tcp.Config{
+			ConnHandler: tcpConnWriterHandler{},
+			ReqHandler:  tcpReqHandler{},
+			RespHandler: tcpUncheckedRespHandler{},
+
+			OptIntPool: tcp.OptIntPool{
+				RecvMinPoolSize: func() int { return 2 },
+				RecvMaxPoolSize: func() int { return 1000 },
+				SendMinPoolSize: func() int { return 2 },
+				SendMaxPoolSize: func() int { return 1000 },
+			},
+
+			OptWriteErrors: tcp.OptWriteErrors{
+				MaxWriteErrors: 2,
+			},
+
+			OptDisconnect: tcp.OptDisconnect{
+				OnDisconnect: func(remoteAddr string, reason tcp.CloseReason) {
+					reasons <- reason
+				},
+			},
+
+			WrapConn: func(conn net.Conn) net.Conn {
+				return tcpFailWriteConn{conn}
+			},
+		}
+
+		// Create a new in-memory TCP value.
+		u, connector, err := tcp.NewInMemory("traceID", "TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new in-memory TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to create a new in-memory TCP listener.", tests.Success)
+
+		// Start accepting client data.
+		if err := u.Start("traceID"); err != nil {
+			t.Fatal("\tShould be able to start the TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to start the TCP listener.", tests.Success)
+
+		defer u.Stop("traceID")
+
+		conn, err := connector.Connect()
+		if err != nil {
+			t.Fatal("\tShould be able to connect in memory.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to connect in memory.", tests.Success)
+
+		defer conn.Close()
+
+		for i := 0; len(u.Connections()) != 1; i++ {
+			if i == 100 {
+				t.Fatal("\tShould have the connection joined.", tests.Failed)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Log("\tShould have the connection joined.", tests.Success)
+
+		for i := 0; i < 2; i++ {
+			resp := tcp.Response{
+				TCPAddr: conn.LocalAddr().(*net.TCPAddr),
+				Data:    []byte("PUSH\n"),
+				Length:  5,
+				Sent:    make(chan error, 1),
+			}
+			if err := u.Do("traceID", &resp); err != nil {
+				t.Fatal("\tShould be able to send the response.", tests.Failed, err)
+			}
+
+			if err := <-resp.Sent; err != errWriteRefused {
+				t.Fatal("\tShould report the write failed.", tests.Failed, err)
+			}
+		}
+		t.Log("\tShould report the writes failed.", tests.Success)
+
+		select {
+		case reason := <-reasons:
+			if reason != tcp.CloseWriteErrors {
+				t.Fatal("\tShould report the write errors reason.", tests.Failed, reason)
+			}
+			t.Log("\tShould report the write errors reason.", tests.Success)
+
+		case <-time.After(time.Second):
+			t.Fatal("\tShould drop the connection after too many errors in a row.", tests.Failed)
+		}
+	}
+}
+
+// TestCloseAfterWriteError tests a connection isn't closed after a
+// response with CloseAfterWrite fails to be written.
+func TestCloseAfterWriteError(t *testing.T) {
+	tests.ResetLog()
+	defer tests.DisplayLog()
+
+	t.Log("Given the need to keep a connection when the final response fails.")
+	{
+		reasons := make(chan tcp.CloseReason, 1)
+
+		// Create a configuration with writes that fail.
+		cfg := tcp.Config{
+			ConnHandler: tcpConnWriterHandler{},
+			ReqHandler:  tcpReqHandler{},
+			RespHandler: tcpUncheckedRespHandler{},
+
+			OptIntPool: tcp.OptIntPool{
+				RecvMinPoolSize: func() int { return 2 },
+				RecvMaxPoolSize: func() int { return 1000 },
+				SendMinPoolSize: func() int { return 2 },
+				SendMaxPoolSize: func() int { return 1000 },
+			},
+
+			OptDisconnect: tcp.OptDisconnect{
+				OnDisconnect: func(remoteAddr string, reason tcp.CloseReason) {
+					reasons <- reason
+				},
+			},
+
+			WrapConn: func(conn net.Conn) net.Conn {
+				return tcpFailWriteConn{conn}
+			},
+		}
+
+		// Create a new in-memory TCP value.
+		u, connector, err := tcp.NewInMemory("traceID", "TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new in-memory TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to create a new in-memory TCP listener.", tests.Success)
+
+		// Start accepting client data.
+		if err := u.Start("traceID"); err != nil {
+			t.Fatal("\tShould be able to start the TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to start the TCP listener.", tests.Success)
+
+		defer u.Stop("traceID")
+
+		conn, err := connector.Connect()
+		if err != nil {
+			t.Fatal("\tShould be able to connect in memory.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to connect in memory.", tests.Success)
+
+		defer conn.Close()
+
+		for i := 0; len(u.Connections()) != 1; i++ {
+			if i == 100 {
+				t.Fatal("\tShould have the connection joined.", tests.Failed)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Log("\tShould have the connection joined.", tests.Success)
+
+		resp := tcp.Response{
+			TCPAddr:         conn.LocalAddr().(*net.TCPAddr),
+			Data:            []byte("BYE\n"),
+			Length:          4,
+			CloseAfterWrite: true,
+			Sent:            make(chan error, 1),
+		}
+		if err := u.Do("traceID", &resp); err != nil {
+			t.Fatal("\tShould be able to send the response.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to send the response.", tests.Success)
+
+		if err := <-resp.Sent; err != errWriteRefused {
+			t.Fatal("\tShould report the write failed.", tests.Failed, err)
+		}
+		t.Log("\tShould report the write failed.", tests.Success)
+
+		select {
+		case reason := <-reasons:
+			t.Fatal("\tShould keep the connection after the write failed.", tests.Failed, reason)
+		case <-time.After(100 * time.Millisecond):
+		}
+
+		if n := len(u.Connections()); n != 1 {
+			t.Fatal("\tShould keep the connection after the write failed.", tests.Failed, n)
+		}
+		t.Log("\tShould keep the connection after the write failed.", tests.Success)
+	}
+}
+
+// TestStopGrace tests responses are still written during the grace period
+// and connections are closed once it ends.
+func TestStopGrace(t *testing.T) {
+	tests.ResetLog()
+	defer tests.DisplayLog()
+
+	t.Log("Given the need to finish in-flight responses before stopping.")
+	{
+		h := tcpHoldReqHandler{
+			started: make(chan uint64, 1),
+			release: make(chan struct{}),
+		}
+		reasons := make(chan tcp.CloseReason, 1)
+
+		const grace = 300 * time.Millisecond
+
+		// Create a configuration with a grace period.
+		cfg := tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  h,
+			RespHandler: tcpRespHandler{},
+
+			OptIntPool: tcp.OptIntPool{
+				RecvMinPoolSize: func() int { return 2 },
+				RecvMaxPoolSize: func() int { return 1000 },
+				SendMinPoolSize: func() int { return 2 },
+				SendMaxPoolSize: func() int { return 1000 },
+			},
+
+			OptDisconnect: tcp.OptDisconnect{
+				OnDisconnect: func(remoteAddr string, reason tcp.CloseReason) {
+					reasons <- reason
+				},
+			},
+
+			StopGrace: grace,
+		}
+
+		// Create a new in-memory TCP value.
+		u, connector, err := tcp.NewInMemory("traceID", "TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new in-memory TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to create a new in-memory TCP listener.", tests.Success)
+
+		// Start accepting client data.
+		if err := u.Start("traceID"); err != nil {
+			t.Fatal("\tShould be able to start the TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to start the TCP listener.", tests.Success)
+
+		conn, err := connector.Connect()
+		if err != nil {
+			t.Fatal("\tShould be able to connect in memory.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to connect in memory.", tests.Success)
+
+		defer conn.Close()
+
+		go conn.Write([]byte("Hello\n"))
+		<-h.started
+
+		// Stop while the request is still being processed.
+		start := time.Now()
+		stopped := make(chan struct{})
+		go func() {
+			u.Stop("traceID")
+			close(stopped)
+		}()
+
+		time.Sleep(grace / 3)
+		h.release <- struct{}{}
+
+		bufReader := bufio.NewReader(conn)
+		if response, err := bufReader.ReadString('\n'); err != nil || response != "GOT IT\n" {
+			t.Fatal("\tShould receive the response during the grace period.", tests.Failed, response, err)
+		}
+		t.Log("\tShould receive the response during the grace period.", tests.Success)
+
+		if _, err := bufReader.ReadByte(); err == nil {
+			t.Fatal("\tShould close the connection once the grace period ends.", tests.Failed)
+		}
+		if d := time.Since(start); d < grace {
+			t.Fatal("\tShould close the connection once the grace period ends.", tests.Failed, d)
+		}
+		t.Log("\tShould close the connection once the grace period ends.", tests.Success)
+
+		if reason := <-reasons; reason != tcp.CloseShutdown {
+			t.Fatal("\tShould report the shutdown reason.", tests.Failed, reason)
+		}
+		t.Log("\tShould report the shutdown reason.", tests.Success)
+
+		select {
+		case <-stopped:
+			t.Log("\tShould return from Stop.", tests.Success)
+		case <-time.After(time.Second):
+			t.Fatal("\tShould return from Stop.", tests.Failed)
+		}
+	}
+}
+
+// TestDoContext tests DoContext gives up waiting on a busy send pool once
+// the context is done and returns the context's error.
+func TestDoContext(t *testing.T) {
+	tests.ResetLog()
+	defer tests.DisplayLog()
+
+	t.Log("Given the need to stop waiting on a busy send pool.")
+	{
+		// Create a configuration with a single send routine.
+		cfg := tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  tcpReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptIntPool: tcp.OptIntPool{
+				RecvMinPoolSize: func() int { return 2 },
+				RecvMaxPoolSize: func() int { return 1000 },
+				SendMinPoolSize: func() int { return 1 },
+				SendMaxPoolSize: func() int { return 1 },
+			},
+		}
+
+		// Create a new in-memory TCP value.
+		u, connector, err := tcp.NewInMemory("traceID", "TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new in-memory TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to create a new in-memory TCP listener.", tests.Success)
+
+		// Start accepting client data.
+		if err := u.Start("traceID"); err != nil {
+			t.Fatal("\tShould be able to start the TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to start the TCP listener.", tests.Success)
+
+		defer u.Stop("traceID")
+
+		conn, err := connector.Connect()
+		if err != nil {
+			t.Fatal("\tShould be able to connect in memory.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to connect in memory.", tests.Success)
+
+		defer conn.Close()
+
+		for i := 0; len(u.Connections()) != 1; i++ {
+			if i == 100 {
+				t.Fatal("\tShould have the connection joined.", tests.Failed)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Log("\tShould have the connection joined.", tests.Success)
+
+		newResponse := func() *tcp.Response {
+			return &tcp.Response{
+				TCPAddr: conn.LocalAddr().(*net.TCPAddr),
+				Data:    []byte("PUSH\n"),
+				Length:  5,
+			}
+		}
+
+		// The in-memory write blocks until it is read, so the only send
+		// routine is busy until the client reads.
+		if err := u.Do("traceID", newResponse()); err != nil {
+			t.Fatal("\tShould be able to send the response.", tests.Failed, err)
+		}
+		for u.StatsSend().Active != 1 {
+			time.Sleep(time.Millisecond)
+		}
+		t.Log("\tShould be able to send the response.", tests.Success)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(50*time.Millisecond, cancel)
+
+		if err := u.DoContext(ctx, "traceID", newResponse()); err != context.Canceled {
+			t.Fatal("\tShould return the error of a cancelled context.", tests.Failed, err)
+		}
+		t.Log("\tShould return the error of a cancelled context.", tests.Success)
+
+		ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		if err := u.DoContext(ctx, "traceID", newResponse()); err != context.DeadlineExceeded {
+			t.Fatal("\tShould return the error of a context past its deadline.", tests.Failed, err)
+		}
+		t.Log("\tShould return the error of a context past its deadline.", tests.Success)
+
+		if stat := u.Stats(); stat.PendingResponses != 1 {
+			t.Fatal("\tShould release the responses that weren't posted.", tests.Failed, stat.PendingResponses)
+		}
+		t.Log("\tShould release the responses that weren't posted.", tests.Success)
+
+		if response, err := bufio.NewReader(conn).ReadString('\n'); err != nil || response != "PUSH\n" {
+			t.Fatal("\tShould receive the posted response.", tests.Failed, response, err)
+		}
+		t.Log("\tShould receive the posted response.", tests.Success)
+	}
+}
+
+// TestHandshakeTimeout tests TLS clients that don't finish the handshake
+// are closed without being added.
+func TestHandshakeTimeout(t *testing.T) {
+	tests.ResetLog()
+	defer tests.DisplayLog()
+
+	t.Log("Given the need to drop TLS clients that never finish the handshake.")
+	{
+		cert, err := newCertificate("good.example")
+		if err != nil {
+			t.Fatal("\tShould be able to create a certificate.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to create a certificate.", tests.Success)
+
+		// Create a configuration.
+		cfg := tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  tcpReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptIntPool: tcp.OptIntPool{
+				RecvMinPoolSize: func() int { return 2 },
+				RecvMaxPoolSize: func() int { return 1000 },
+				SendMinPoolSize: func() int { return 2 },
+				SendMaxPoolSize: func() int { return 1000 },
+			},
+
+			OptTLS: tcp.OptTLS{
+				TLSConfig:        &tls.Config{Certificates: []tls.Certificate{cert}},
+				HandshakeTimeout: -time.Second,
+			},
+		}
+
+		if _, _, err := tcp.NewInMemory("traceID", "TEST", cfg); err != tcp.ErrInvalidHandshakeTimeout {
+			t.Fatal("\tShould not accept a negative handshake timeout.", tests.Failed, err)
+		}
+		t.Log("\tShould not accept a negative handshake timeout.", tests.Success)
+
+		cfg.HandshakeTimeout = 50 * time.Millisecond
+
+		// Create a new in-memory TCP value.
+		u, connector, err := tcp.NewInMemory("traceID", "TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new in-memory TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to create a new in-memory TCP listener.", tests.Success)
+
+		// Start accepting client data.
+		if err := u.Start("traceID"); err != nil {
+			t.Fatal("\tShould be able to start the TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to start the TCP listener.", tests.Success)
+
+		defer u.Stop("traceID")
+
+		// A silent client doesn't hold up the next one.
+		silent, err := connector.Connect()
+		if err != nil {
+			t.Fatal("\tShould be able to connect in memory.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to connect in memory.", tests.Success)
+
+		defer silent.Close()
+
+		conn, err := connector.Connect()
+		if err != nil {
+			t.Fatal("\tShould be able to connect in memory.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to connect in memory.", tests.Success)
+
+		tc := tls.Client(conn, &tls.Config{ServerName: "good.example", InsecureSkipVerify: true})
+		defer tc.Close()
+
+		if err := tc.Handshake(); err != nil {
+			t.Fatal("\tShould be able to finish the handshake behind a silent client.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to finish the handshake behind a silent client.", tests.Success)
+
+		silent.SetReadDeadline(time.Now().Add(time.Second))
+		if _, err := silent.Read(make([]byte, 1)); err != io.EOF {
+			t.Fatal("\tShould close the client that didn't finish the handshake.", tests.Failed, err)
+		}
+		t.Log("\tShould close the client that didn't finish the handshake.", tests.Success)
+
+		if stat := u.Stats(); stat.AcceptedTotal != 1 {
+			t.Fatal("\tShould only count the client that finished the handshake.", tests.Failed, stat.AcceptedTotal)
+		}
+		t.Log("\tShould only count the client that finished the handshake.", tests.Success)
+	}
+}
+
+// TestMaxConcurrentHandshakes tests TLS clients are dropped while too many
+// handshakes are running.
+func TestMaxConcurrentHandshakes(t *testing.T) {
+	tests.ResetLog()
+	defer tests.DisplayLog()
+
+	t.Log("Given the need to limit the TLS handshakes running at once.")
+	{
+		cert, err := newCertificate("good.example")
+		if err != nil {
+			t.Fatal("\tShould be able to create a certificate.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to create a certificate.", tests.Success)
+
+		// Create a configuration.
+		cfg := tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  tcpReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptIntPool: tcp.OptIntPool{
+				RecvMinPoolSize: func() int { return 2 },
+				RecvMaxPoolSize: func() int { return 1000 },
+				SendMinPoolSize: func() int { return 2 },
+				SendMaxPoolSize: func() int { return 1000 },
+			},
+
+			OptTLS: tcp.OptTLS{
+				TLSConfig:               &tls.Config{Certificates: []tls.Certificate{cert}},
+				MaxConcurrentHandshakes: -1,
+			},
+		}
+
+		if _, _, err := tcp.NewInMemory("traceID", "TEST", cfg); err != tcp.ErrInvalidMaxHandshakes {
+			t.Fatal("\tShould not accept a negative handshake limit.", tests.Failed, err)
+		}
+		t.Log("\tShould not accept a negative handshake limit.", tests.Success)
+
+		cfg.MaxConcurrentHandshakes = 1
+
+		// Create a new in-memory TCP value.
+		u, connector, err := tcp.NewInMemory("traceID", "TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new in-memory TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to create a new in-memory TCP listener.", tests.Success)
+
+		// Start accepting client data.
+		if err := u.Start("traceID"); err != nil {
+			t.Fatal("\tShould be able to start the TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to start the TCP listener.", tests.Success)
+
+		defer u.Stop("traceID")
+
+		connect := func() (*tls.Conn, error) {
+			conn, err := connector.Connect()
+			if err != nil {
+				return nil, err
+			}
+
+			tc := tls.Client(conn, &tls.Config{ServerName: "good.example", InsecureSkipVerify: true})
+			if err := tc.Handshake(); err != nil {
+				conn.Close()
+				return nil, err
+			}
+
+			return tc, nil
+		}
+
+		// A silent client holds the only handshake.
+		silent, err := connector.Connect()
+		if err != nil {
+			t.Fatal("\tShould be able to connect in memory.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to connect in memory.", tests.Success)
+
+		if _, err := connect(); err == nil {
+			t.Fatal("\tShould not be able to connect while the handshakes are in use.", tests.Failed)
+		}
+		t.Log("\tShould not be able to connect while the handshakes are in use.", tests.Success)
+
+		if n := u.Drops()[tcp.DropReasonHandshakes]; n != 1 {
+			t.Fatal("\tShould count the connection dropped for the handshake limit.", tests.Failed, n)
+		}
+		t.Log("\tShould count the connection dropped for the handshake limit.", tests.Success)
+
+		// The handshake is freed once the silent client goes away.
+		silent.Close()
+
+		tc, err := connect()
+		if err != nil {
+			t.Fatal("\tShould be able to connect once a handshake is free.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to connect once a handshake is free.", tests.Success)
+
+		tc.Close()
+	}
+}
+
+// TestWriteRateLimitGone tests a paced write stops waiting once the
+// connection is dropped.
+func TestWriteRateLimitGone(t *testing.T) {
+	tests.ResetLog()
+	defer tests.DisplayLog()
+
+	t.Log("Given the need to free the send pool from paced writes to dropped clients.")
+	{
+		// Create a configuration.
+		cfg := tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  tcpReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptIntPool: tcp.OptIntPool{
+				RecvMinPoolSize: func() int { return 2 },
+				RecvMaxPoolSize: func() int { return 1000 },
+				SendMinPoolSize: func() int { return 2 },
+				SendMaxPoolSize: func() int { return 1000 },
+			},
+
+			// Each response is 5 bytes, so the second one waits
+			// 5 seconds.
+			OptRateLimit: tcp.OptRateLimit{
+				WriteRateLimit: 1,
+			},
+		}
+
+		// Create a new in-memory TCP value.
+		u, connector, err := tcp.NewInMemory("traceID", "TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new in-memory TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to create a new in-memory TCP listener.", tests.Success)
+
+		// Start accepting client data.
+		if err := u.Start("traceID"); err != nil {
+			t.Fatal("\tShould be able to start the TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to start the TCP listener.", tests.Success)
+
+		defer u.Stop("traceID")
+
+		conn, err := connector.Connect()
+		if err != nil {
+			t.Fatal("\tShould be able to connect in memory.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to connect in memory.", tests.Success)
+
+		defer conn.Close()
+
+		for i := 0; len(u.Connections()) != 1; i++ {
+			if i == 100 {
+				t.Fatal("\tShould have the connection joined.", tests.Failed)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Log("\tShould have the connection joined.", tests.Success)
+
+		bufReader := bufio.NewReader(conn)
+
+		var resps []*tcp.Response
+		for i := 0; i < 2; i++ {
+			resp := tcp.Response{
+				TCPAddr: conn.LocalAddr().(*net.TCPAddr),
+				Data:    []byte("PUSH\n"),
+				Length:  5,
+				Sent:    make(chan error, 1),
+			}
+			if err := u.Do("traceID", &resp); err != nil {
+				t.Fatal("\tShould be able to send the response.", tests.Failed, err)
+			}
+			resps = append(resps, &resp)
+
+			// The first response is written right away.
+			if i == 0 {
+				if _, err := bufReader.ReadString('\n'); err != nil {
+					t.Fatal("\tShould be able to read the response from the connection.", tests.Failed, err)
+				}
+				<-resp.Sent
+			}
+		}
+		t.Log("\tShould be able to send the responses.", tests.Success)
+
+		start := time.Now()
+		if err := u.DropConnection("traceID", conn.LocalAddr().String()); err != nil {
+			t.Fatal("\tShould be able to drop the connection.", tests.Failed, err)
+		}
+
+		select {
+		case err := <-resps[1].Sent:
+			if err != tcp.ErrClientGone {
+				t.Fatal("\tShould report the client is gone.", tests.Failed, err)
+			}
+			t.Log("\tShould report the client is gone.", tests.Success)
+
+		case <-time.After(time.Second):
+			t.Fatal("\tShould stop pacing once the connection is dropped.", tests.Failed)
+		}
+
+		if d := time.Since(start); d > 500*time.Millisecond {
+			t.Fatal("\tShould stop pacing once the connection is dropped.", tests.Failed, d)
+		}
+		t.Log("\tShould stop pacing once the connection is dropped.", tests.Success)
+	}
+}
+
+// TestRequestConn tests a request carries the connection it was read from.
+func TestRequestConn(t *testing.T) {
+	tests.ResetLog()
+	defer tests.DisplayLog()
+
+	t.Log("Given the need to reach the connection a request was read from.")
+	{
+		h := tcpConnReqHandler{conns: make(chan net.Conn, 1)}
+		wrapped := make(chan net.Conn, 1)
+
+		// Create a configuration.
+		cfg := tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  h,
+			RespHandler: tcpRespHandler{},
+
+			OptIntPool: tcp.OptIntPool{
+				RecvMinPoolSize: func() int { return 2 },
+				RecvMaxPoolSize: func() int { return 1000 },
+				SendMinPoolSize: func() int { return 2 },
+				SendMaxPoolSize: func() int { return 1000 },
+			},
+
+			WrapConn: func(conn net.Conn) net.Conn {
+				c := &tcpCountConn{Conn: conn}
+				wrapped <- c
+				return c
+			},
+		}
+
+		// Create a new in-memory TCP value.
+		u, connector, err := tcp.NewInMemory("traceID", "TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new in-memory TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to create a new in-memory TCP listener.", tests.Success)
+
+		// Start accepting client data.
+		if err := u.Start("traceID"); err != nil {
+			t.Fatal("\tShould be able to start the TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to start the TCP listener.", tests.Success)
+
+		defer u.Stop("traceID")
+
+		conn, err := connector.Connect()
+		if err != nil {
+			t.Fatal("\tShould be able to connect in memory.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to connect in memory.", tests.Success)
+
+		defer conn.Close()
+
+		go conn.Write([]byte("Hello\n"))
+
+		if response, err := bufio.NewReader(conn).ReadString('\n'); err != nil || response != "GOT IT\n" {
+			t.Fatal("\tShould receive the string \"GOT IT\".", tests.Failed, response, err)
+		}
+		t.Log("\tShould receive the string \"GOT IT\".", tests.Success)
+
+		rc := <-h.conns
+		if rc != <-wrapped {
+			t.Fatal("\tShould carry the connection the request was read from.", tests.Failed, rc)
+		}
+		t.Log("\tShould carry the connection the request was read from.", tests.Success)
+
+		if rc.RemoteAddr().String() != conn.LocalAddr().String() {
+			t.Fatal("\tShould carry the address of the client.", tests.Failed, rc.RemoteAddr())
+		}
+		t.Log("\tShould carry the address of the client.", tests.Success)
+	}
+}
+
+// TestSentUnbuffered tests a response with an unbuffered Sent channel is
+// refused instead of having its error dropped.
+func TestSentUnbuffered(t *testing.T) {
+	tests.ResetLog()
+	defer tests.DisplayLog()
+
+	t.Log("Given the need to always deliver the error of a response on Sent.")
+	{
+		// Create a configuration.
+		cfg := tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  tcpReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptIntPool: tcp.OptIntPool{
+				RecvMinPoolSize: func() int { return 2 },
+				RecvMaxPoolSize: func() int { return 1000 },
+				SendMinPoolSize: func() int { return 2 },
+				SendMaxPoolSize: func() int { return 1000 },
+			},
+		}
+
+		// Create a new in-memory TCP value.
+		u, connector, err := tcp.NewInMemory("traceID", "TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new in-memory TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to create a new in-memory TCP listener.", tests.Success)
+
+		// Start accepting client data.
+		if err := u.Start("traceID"); err != nil {
+			t.Fatal("\tShould be able to start the TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to start the TCP listener.", tests.Success)
+
+		defer u.Stop("traceID")
+
+		conn, err := connector.Connect()
+		if err != nil {
+			t.Fatal("\tShould be able to connect in memory.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to connect in memory.", tests.Success)
+
+		defer conn.Close()
+
+		for i := 0; len(u.Connections()) != 1; i++ {
+			if i == 100 {
+				t.Fatal("\tShould have the connection joined.", tests.Failed)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Log("\tShould have the connection joined.", tests.Success)
+
+		resp := tcp.Response{
+			TCPAddr: conn.LocalAddr().(*net.TCPAddr),
+			Data:    []byte("PUSH\n"),
+			Length:  5,
+			Sent:    make(chan error),
+		}
+
+		if err := u.Do("traceID", &resp); err != tcp.ErrSentUnbuffered {
+			t.Fatal("\tShould refuse a Sent channel with no buffer.", tests.Failed, err)
+		}
+		t.Log("\tShould refuse a Sent channel with no buffer.", tests.Success)
+
+		if stat := u.Stats(); stat.PendingResponses != 0 {
+			t.Fatal("\tShould not hold on to the refused response.", tests.Failed, stat.PendingResponses)
+		}
+		t.Log("\tShould not hold on to the refused response.", tests.Success)
+	}
+}
+
+// TestProbeTimeout tests ProbeFunc is only given connections that pass the
+// filters and a silent client can't hold up accepting.
+func TestProbeTimeout(t *testing.T) {
+	tests.ResetLog()
+	defer tests.DisplayLog()
+
+	t.Log("Given the need to bound the time spent probing a connection.")
+	{
+		var probes int32
+		reasons := make(chan string, 1)
+
+		// Create a configuration.
+		cfg := tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  tcpReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptIntPool: tcp.OptIntPool{
+				RecvMinPoolSize: func() int { return 2 },
+				RecvMaxPoolSize: func() int { return 1000 },
+				SendMinPoolSize: func() int { return 2 },
+				SendMaxPoolSize: func() int { return 1000 },
+			},
+
+			OptAdmit: tcp.OptAdmit{
+				AcceptFilters: []tcp.AcceptFilter{
+					func(conn net.Conn) (bool, string) {
+						return conn.RemoteAddr().(*net.TCPAddr).Port != 1, "blocked"
+					},
+				},
+			},
+
+			OptDrop: tcp.OptDrop{
+				OnDrop: func(reason string, remoteAddr string) {
+					reasons <- reason
+				},
+			},
+
+			// The deadline set by the probe is held to the ProbeTimeout.
+			OptProbe: tcp.OptProbe{
+				ProbeFunc: func(conn net.Conn) bool {
+					atomic.AddInt32(&probes, 1)
+
+					buf := make([]byte, 5)
+
+					conn.SetReadDeadline(time.Now().Add(time.Hour))
+					if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "PING\n" {
+						return false
+					}
+
+					conn.Write([]byte("PONG\n"))
+					return true
+				},
+				ProbeTimeout: 50 * time.Millisecond,
+			},
+		}
+
+		// Create a new in-memory TCP value.
+		u, connector, err := tcp.NewInMemory("traceID", "TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new in-memory TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to create a new in-memory TCP listener.", tests.Success)
+
+		// Start accepting client data.
+		if err := u.Start("traceID"); err != nil {
+			t.Fatal("\tShould be able to start the TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to start the TCP listener.", tests.Success)
+
+		defer u.Stop("traceID")
+
+		var conns []net.Conn
+		for i := 0; i < 3; i++ {
+			conn, err := connector.Connect()
+			if err != nil {
+				t.Fatal("\tShould be able to connect in memory.", tests.Failed, err)
+			}
+			t.Log("\tShould be able to connect in memory.", tests.Success)
+
+			defer conn.Close()
+			conns = append(conns, conn)
+		}
+
+		if reason := <-reasons; reason != "blocked" {
+			t.Fatal("\tShould drop the connection the filter blocks.", tests.Failed, reason)
+		}
+		t.Log("\tShould drop the connection the filter blocks.", tests.Success)
+
+		// The second connection is silent, the third is a probe.
+		start := time.Now()
+		go conns[2].Write([]byte("PING\n"))
+
+		if response, err := bufio.NewReader(conns[2]).ReadString('\n'); err != nil || response != "PONG\n" {
+			t.Fatal("\tShould receive the string \"PONG\" behind a silent client.", tests.Failed, response, err)
+		}
+		if d := time.Since(start); d > time.Second {
+			t.Fatal("\tShould receive the string \"PONG\" behind a silent client.", tests.Failed, d)
+		}
+		t.Log("\tShould receive the string \"PONG\" behind a silent client.", tests.Success)
+
+		if n := atomic.LoadInt32(&probes); n != 2 {
+			t.Fatal("\tShould only probe connections the filters allow.", tests.Failed, n)
+		}
+		t.Log("\tShould only probe connections the filters allow.", tests.Success)
+
+		// The silent client is added once its probe times out.
+		go conns[1].Write([]byte("Hello\n"))
+
+		if response, err := bufio.NewReader(conns[1]).ReadString('\n'); err != nil || response != "GOT IT\n" {
+			t.Fatal("\tShould receive the string \"GOT IT\" after the probe timed out.", tests.Failed, response, err)
+		}
+		t.Log("\tShould receive the string \"GOT IT\" after the probe timed out.", tests.Success)
+	}
+}
+
+// TestHijackWriteTimeout tests the write deadline set for responses doesn't
+// apply to a hijacked connection.
+func TestHijackWriteTimeout(t *testing.T) {
+	tests.ResetLog()
+	defer tests.DisplayLog()
+
+	t.Log("Given the need to write to a hijacked connection after the write timeout.")
+	{
+		errs := make(chan error, 1)
+
+		const timeout = 50 * time.Millisecond
+
+		// Create a configuration.
+		cfg := tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  tcpHijackReqHandler{errs: errs},
+			RespHandler: tcpRespHandler{},
+
+			OptIntPool: tcp.OptIntPool{
+				RecvMinPoolSize: func() int { return 2 },
+				RecvMaxPoolSize: func() int { return 1000 },
+				SendMinPoolSize: func() int { return 2 },
+				SendMaxPoolSize: func() int { return 1000 },
+			},
+
+			OptTimeout: tcp.OptTimeout{
+				WriteTimeout: timeout,
+			},
+		}
+
+		// Create a new in-memory TCP value.
+		u, connector, err := tcp.NewInMemory("traceID", "TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new in-memory TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to create a new in-memory TCP listener.", tests.Success)
+
+		// Start accepting client data.
+		if err := u.Start("traceID"); err != nil {
+			t.Fatal("\tShould be able to start the TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to start the TCP listener.", tests.Success)
+
+		defer u.Stop("traceID")
+
+		conn, err := connector.Connect()
+		if err != nil {
+			t.Fatal("\tShould be able to connect in memory.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to connect in memory.", tests.Success)
+
+		defer conn.Close()
+
+		bufReader := bufio.NewReader(conn)
+
+		// The response leaves a write deadline on the connection.
+		go conn.Write([]byte("Hello\n"))
+
+		if response, err := bufReader.ReadString('\n'); err != nil || response != "GOT IT\n" {
+			t.Fatal("\tShould receive the string \"GOT IT\" before the hijack.", tests.Failed, response, err)
+		}
+		t.Log("\tShould receive the string \"GOT IT\" before the hijack.", tests.Success)
+
+		go conn.Write([]byte("HIJACK\n"))
+
+		if err := <-errs; err != nil {
+			t.Fatal("\tShould be able to hijack the connection.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to hijack the connection.", tests.Success)
+
+		if response, err := bufReader.ReadString('\n'); err != nil || response != "HIJACKED\n" {
+			t.Fatal("\tShould receive the string \"HIJACKED\" on the raw connection.", tests.Failed, response, err)
+		}
+		t.Log("\tShould receive the string \"HIJACKED\" on the raw connection.", tests.Success)
+
+		// Let the write deadline of the response pass.
+		time.Sleep(2 * timeout)
+
+		go conn.Write([]byte("Hello\n"))
+
+		if response, err := bufReader.ReadString('\n'); err != nil || response != "Hello\n" {
+			t.Fatal("\tShould be able to write to the raw connection after the write timeout.", tests.Failed, response, err)
+		}
+		t.Log("\tShould be able to write to the raw connection after the write timeout.", tests.Success)
+	}
+}
+
+// TestFlushIntervalWriteTimeout tests a timed flush isn't failed by the
+// write deadline of the responses it flushes.
+func TestFlushIntervalWriteTimeout(t *testing.T) {
+	tests.ResetLog()
+	defer tests.DisplayLog()
+
+	t.Log("Given the need to flush on a timer longer than the write timeout.")
+	{
+		reasons := make(chan tcp.CloseReason, 1)
+
+		// Create a configuration.
+		cfg := tcp.Config{
+			ConnHandler: tcpConnWriterHandler{},
+			ReqHandler:  tcpReqHandler{},
+			RespHandler: tcpChattyRespHandler{},
+
+			OptIntPool: tcp.OptIntPool{
+				RecvMinPoolSize: func() int { return 2 },
+				RecvMaxPoolSize: func() int { return 1000 },
+				SendMinPoolSize: func() int { return 2 },
+				SendMaxPoolSize: func() int { return 1000 },
+			},
+
+			OptBuffer: tcp.OptBuffer{
+				WriteBufferSize: 64,
+				FlushInterval:   200 * time.Millisecond,
+			},
+
+			OptTimeout: tcp.OptTimeout{
+				WriteTimeout: 50 * time.Millisecond,
+			},
+
+			OptWriteErrors: tcp.OptWriteErrors{
+				MaxWriteErrors: 1,
+			},
+
+			OptDisconnect: tcp.OptDisconnect{
+				OnDisconnect: func(remoteAddr string, reason tcp.CloseReason) {
+					reasons <- reason
+				},
+			},
+		}
+
+		// Create a new in-memory TCP value.
+		u, connector, err := tcp.NewInMemory("traceID", "TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new in-memory TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to create a new in-memory TCP listener.", tests.Success)
+
+		// Start accepting client data.
+		if err := u.Start("traceID"); err != nil {
+			t.Fatal("\tShould be able to start the TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to start the TCP listener.", tests.Success)
+
+		defer u.Stop("traceID")
+
+		conn, err := connector.Connect()
+		if err != nil {
+			t.Fatal("\tShould be able to connect in memory.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to connect in memory.", tests.Success)
+
+		defer conn.Close()
+
+		for i := 0; len(u.Connections()) != 1; i++ {
+			if i == 100 {
+				t.Fatal("\tShould have the connection joined.", tests.Failed)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Log("\tShould have the connection joined.", tests.Success)
+
+		go conn.Write([]byte("Hello\n"))
+
+		if response, err := bufio.NewReader(conn).ReadString('\n'); err != nil || response != "GOT IT\n" {
+			t.Fatal("\tShould receive the response once flushed.", tests.Failed, response, err)
+		}
+		t.Log("\tShould receive the response once flushed.", tests.Success)
+
+		select {
+		case reason := <-reasons:
+			t.Fatal("\tShould keep the connection after the timed flush.", tests.Failed, reason)
+		case <-time.After(100 * time.Millisecond):
+			t.Log("\tShould keep the connection after the timed flush.", tests.Success)
+		}
+	}
+}
+
+// TestStopGracefulInFlight tests StopGraceful waits for requests still
+// being processed before it drops the connection.
+func TestStopGracefulInFlight(t *testing.T) {
+	tests.ResetLog()
+	defer tests.DisplayLog()
+
+	t.Log("Given the need to drain requests that are still being processed.")
+	{
+		h := tcpHoldReqHandler{
+			started: make(chan uint64, 1),
+			release: make(chan struct{}),
+		}
+
+		// Create a configuration.
+		cfg := tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  h,
+			RespHandler: tcpRespHandler{},
+
+			OptIntPool: tcp.OptIntPool{
+				RecvMinPoolSize: func() int { return 2 },
+				RecvMaxPoolSize: func() int { return 1000 },
+				SendMinPoolSize: func() int { return 2 },
+				SendMaxPoolSize: func() int { return 1000 },
+			},
+		}
+
+		// Create a new in-memory TCP value.
+		u, connector, err := tcp.NewInMemory("traceID", "TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new in-memory TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to create a new in-memory TCP listener.", tests.Success)
+
+		drained := make(chan string, 1)
+		u.OnDrained = func(remoteAddr string) {
+			drained <- remoteAddr
+		}
+
+		// Start accepting client data.
+		if err := u.Start("traceID"); err != nil {
+			t.Fatal("\tShould be able to start the TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to start the TCP listener.", tests.Success)
+
+		conn, err := connector.Connect()
+		if err != nil {
+			t.Fatal("\tShould be able to connect in memory.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to connect in memory.", tests.Success)
+
+		defer conn.Close()
+
+		go conn.Write([]byte("Hello\n"))
+		<-h.started
+
+		// Stop while the request is still being processed.
+		stopped := make(chan error, 1)
+		go func() {
+			stopped <- u.StopGraceful("traceID", 5*time.Second, nil)
+		}()
+
+		time.Sleep(300 * time.Millisecond)
+
+		select {
+		case addr := <-drained:
+			t.Fatal("\tShould not drain the connection while the request is processed.", tests.Failed, addr)
+		default:
+			t.Log("\tShould not drain the connection while the request is processed.", tests.Success)
+		}
+
+		h.release <- struct{}{}
+
+		bufReader := bufio.NewReader(conn)
+		if response, err := bufReader.ReadString('\n'); err != nil || response != "GOT IT\n" {
+			t.Fatal("\tShould receive the response to the request.", tests.Failed, response, err)
+		}
+		t.Log("\tShould receive the response to the request.", tests.Success)
+
+		select {
+		case err := <-stopped:
+			if err != nil {
+				t.Fatal("\tShould be able to gracefully stop the TCP listener.", tests.Failed, err)
+			}
+			t.Log("\tShould be able to gracefully stop the TCP listener.", tests.Success)
+		case <-time.After(time.Second):
+			t.Fatal("\tShould be able to gracefully stop the TCP listener.", tests.Failed)
+		}
+
+		select {
+		case <-drained:
+			t.Log("\tShould report the connection as drained.", tests.Success)
+		default:
+			t.Fatal("\tShould report the connection as drained.", tests.Failed)
+		}
+	}
+}
+
+// TestBindUnwrap tests the connection given to Bind can be unwrapped to
+// the accepted connection.
+func TestBindUnwrap(t *testing.T) {
+	tests.ResetLog()
+	defer tests.DisplayLog()
+
+	t.Log("Given the need to reach the accepted connection from Bind.")
+	{
+		h := tcpUnwrapConnHandler{
+			conns: make(chan net.Conn, 1),
+		}
+
+		// Create a configuration with a write timeout.
+		cfg := tcp.Config{
+			NetType: "tcp4",
+			Addr:    ":0",
+
+			ConnHandler: h,
+			ReqHandler:  tcpReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptIntPool: tcp.OptIntPool{
+				RecvMinPoolSize: func() int { return 2 },
+				RecvMaxPoolSize: func() int { return 1000 },
+				SendMinPoolSize: func() int { return 2 },
+				SendMaxPoolSize: func() int { return 1000 },
+			},
+
+			OptTimeout: tcp.OptTimeout{
+				WriteTimeout: time.Second,
+			},
+		}
+
+		// Create a new TCP value.
+		u, err := tcp.New("traceID", "TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to create a new TCP listener.", tests.Success)
+
+		// Start accepting client data.
+		if err := u.Start("traceID"); err != nil {
+			t.Fatal("\tShould be able to start the TCP listener.", tests.Failed, err)
+		}
+		defer u.Stop("traceID")
+		t.Log("\tShould be able to start the TCP listener.", tests.Success)
+
+		conn, err := net.Dial("tcp4", u.Addr().String())
+		if err != nil {
+			t.Fatal("\tShould be able to dial a new TCP connection.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to dial a new TCP connection.", tests.Success)
+
+		defer conn.Close()
+
+		select {
+		case c := <-h.conns:
+			if _, ok := c.(*net.TCPConn); !ok {
+				t.Fatalf("\tShould unwrap to the *net.TCPConn. %s %T", tests.Failed, c)
+			}
+			t.Log("\tShould unwrap to the *net.TCPConn.", tests.Success)
+		case <-time.After(time.Second):
+			t.Fatal("\tShould bind the connection.", tests.Failed)
+		}
+	}
+}
+
+// TestWriteRateLimitPool tests a paced write doesn't hold the send pool
+// while the other connections have responses to write.
+func TestWriteRateLimitPool(t *testing.T) {
+	tests.ResetLog()
+	defer tests.DisplayLog()
+
+	t.Log("Given the need to pace a slow connection without starving the others.")
+	{
+		// Create a configuration with a single send routine.
+		cfg := tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  tcpReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptIntPool: tcp.OptIntPool{
+				RecvMinPoolSize: func() int { return 2 },
+				RecvMaxPoolSize: func() int { return 1000 },
+				SendMinPoolSize: func() int { return 1 },
+				SendMaxPoolSize: func() int { return 1 },
+			},
+
+			// Each response is 5 bytes, so the second one to a
+			// connection waits 5 seconds.
+			OptRateLimit: tcp.OptRateLimit{
+				WriteRateLimit: 1,
+			},
+		}
+
+		// Create a new in-memory TCP value.
+		u, connector, err := tcp.NewInMemory("traceID", "TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new in-memory TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to create a new in-memory TCP listener.", tests.Success)
+
+		// Start accepting client data.
+		if err := u.Start("traceID"); err != nil {
+			t.Fatal("\tShould be able to start the TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to start the TCP listener.", tests.Success)
+
+		defer u.Stop("traceID")
+
+		var conns []net.Conn
+		for i := 0; i < 2; i++ {
+			conn, err := connector.Connect()
+			if err != nil {
+				t.Fatal("\tShould be able to connect in memory.", tests.Failed, err)
+			}
+			defer conn.Close()
+
+			conns = append(conns, conn)
+		}
+		t.Log("\tShould be able to connect in memory.", tests.Success)
+
+		for i := 0; len(u.Connections()) != 2; i++ {
+			if i == 100 {
+				t.Fatal("\tShould have the connections joined.", tests.Failed)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Log("\tShould have the connections joined.", tests.Success)
+
+		push := func(conn net.Conn) error {
+			resp := tcp.Response{
+				TCPAddr: conn.LocalAddr().(*net.TCPAddr),
+				Data:    []byte("PUSH\n"),
+				Length:  5,
+			}
+			return u.Do("traceID", &resp)
+		}
+
+		// The first response to the slow connection is written right
+		// away and the second one waits on the rate limit.
+		slow := bufio.NewReader(conns[0])
+		for i := 0; i < 2; i++ {
+			if err := push(conns[0]); err != nil {
+				t.Fatal("\tShould be able to send the response.", tests.Failed, err)
+			}
+			if i == 0 {
+				if _, err := slow.ReadString('\n'); err != nil {
+					t.Fatal("\tShould be able to read the response from the connection.", tests.Failed, err)
+				}
+			}
+		}
+		t.Log("\tShould be able to send the responses.", tests.Success)
+
+		start := time.Now()
+		if err := push(conns[1]); err != nil {
+			t.Fatal("\tShould be able to send the response.", tests.Failed, err)
+		}
+
+		conns[1].SetReadDeadline(time.Now().Add(time.Second))
+		if response, err := bufio.NewReader(conns[1]).ReadString('\n'); err != nil || response != "PUSH\n" {
+			t.Fatal("\tShould write to the other connection while the slow one waits.", tests.Failed, response, err)
+		}
+		if d := time.Since(start); d > 500*time.Millisecond {
+			t.Fatal("\tShould write to the other connection while the slow one waits.", tests.Failed, d)
+		}
+		t.Log("\tShould write to the other connection while the slow one waits.", tests.Success)
+	}
+}
+
+// TestStopAllGraceful tests several TCP values are drained before the
+// shared pools are shutdown.
+func TestStopAllGraceful(t *testing.T) {
+	tests.ResetLog()
+	defer tests.DisplayLog()
+
+	t.Log("Given the need to drain several TCP values that share pools.")
+	{
+		poolCfg := pool.Config{
+			MinRoutines: func() int { return 2 },
+			MaxRoutines: func() int { return 1000 },
+		}
+
+		recv, err := pool.New("traceID", "Test-Recv", poolCfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a work pool for the recv.", tests.Failed, err)
+		}
+
+		send, err := pool.New("traceID", "Test-Send", poolCfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a work pool for the send.", tests.Failed, err)
+		}
+
+		h := tcpHoldReqHandler{
+			started: make(chan uint64, 1),
+			release: make(chan struct{}),
+		}
+
+		// Create a configuration.
+		cfg := tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  h,
+			RespHandler: tcpRespHandler{},
+
+			OptUserPool: tcp.OptUserPool{
+				RecvPool: recv,
+				SendPool: send,
+			},
+		}
+
+		var instances []*tcp.TCP
+		var conns []net.Conn
+
+		for i := 0; i < 2; i++ {
+			u, connector, err := tcp.NewInMemory("traceID", "TEST"+strconv.Itoa(i), cfg)
+			if err != nil {
+				t.Fatal("\tShould be able to create a new in-memory TCP listener.", tests.Failed, err)
+			}
+			t.Log("\tShould be able to create a new in-memory TCP listener.", tests.Success)
+
+			if err := u.Start("traceID"); err != nil {
+				t.Fatal("\tShould be able to start the TCP listener.", tests.Failed, err)
+			}
+			t.Log("\tShould be able to start the TCP listener.", tests.Success)
+
+			conn, err := connector.Connect()
+			if err != nil {
+				t.Fatal("\tShould be able to connect in memory.", tests.Failed, err)
+			}
+			t.Log("\tShould be able to connect in memory.", tests.Success)
+
+			defer conn.Close()
+
+			instances = append(instances, u)
+			conns = append(conns, conn)
+		}
+
+		// Leave a request of the first value being processed.
+		go conns[0].Write([]byte("Hello\n"))
+		<-h.started
+
+		stopped := make(chan error, 1)
+		go func() {
+			stopped <- tcp.StopAllGraceful("traceID", 5*time.Second, instances, recv, send, recv)
+		}()
+
+		time.Sleep(300 * time.Millisecond)
+		h.release <- struct{}{}
+
+		bufReader := bufio.NewReader(conns[0])
+		if response, err := bufReader.ReadString('\n'); err != nil || response != "GOT IT\n" {
+			t.Fatal("\tShould receive the response to the request being processed.", tests.Failed, response, err)
+		}
+		t.Log("\tShould receive the response to the request being processed.", tests.Success)
+
+		select {
+		case err := <-stopped:
+			if err != nil {
+				t.Fatal("\tShould be able to stop all the TCP values.", tests.Failed, err)
+			}
+			t.Log("\tShould be able to stop all the TCP values.", tests.Success)
+		case <-time.After(time.Second):
+			t.Fatal("\tShould be able to stop all the TCP values.", tests.Failed)
+		}
+
+		for i, u := range instances {
+			if u.Running() {
+				t.Fatal("\tShould not be running after StopAllGraceful.", tests.Failed, i)
+			}
+		}
+		t.Log("\tShould not be running after StopAllGraceful.", tests.Success)
+
+		if _, err := bufReader.ReadByte(); err == nil {
+			t.Fatal("\tShould close the client connections.", tests.Failed)
+		}
+		t.Log("\tShould close the client connections.", tests.Success)
+
+		if recv.IsRunning() || send.IsRunning() {
+			t.Fatal("\tShould shutdown the shared pools.", tests.Failed)
+		}
+		t.Log("\tShould shutdown the shared pools.", tests.Success)
+	}
+}
+
+// TestProbeTLS tests ProbeFunc is given the connection before TLS and
+// probes don't hold up accepting or skip the TLS handshake.
+func TestProbeTLS(t *testing.T) {
+	tests.ResetLog()
+	defer tests.DisplayLog()
+
+	t.Log("Given the need to probe connections to a TLS listener.")
+	{
+		cert, err := newCertificate("good.example")
+		if err != nil {
+			t.Fatal("\tShould be able to create a certificate.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to create a certificate.", tests.Success)
+
+		var probedTLS int32
+
+		// Create a configuration.
+		cfg := tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  tcpReqHandler{},
+			RespHandler: tcpRespHandler{},
+
+			OptIntPool: tcp.OptIntPool{
+				RecvMinPoolSize: func() int { return 2 },
+				RecvMaxPoolSize: func() int { return 1000 },
+				SendMinPoolSize: func() int { return 2 },
+				SendMaxPoolSize: func() int { return 1000 },
+			},
+
+			OptTLS: tcp.OptTLS{
+				TLSConfig:        &tls.Config{Certificates: []tls.Certificate{cert}},
+				HandshakeTimeout: 50 * time.Millisecond,
+			},
+
+			// A plain text PING is a probe.
+			OptProbe: tcp.OptProbe{
+				ProbeFunc: func(conn net.Conn) bool {
+					if _, ok := conn.(*tls.Conn); ok {
+						atomic.StoreInt32(&probedTLS, 1)
+					}
+
+					buf := make([]byte, 5)
+					if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "PING\n" {
+						return false
+					}
+
+					conn.Write([]byte("PONG\n"))
+					return true
+				},
+				ProbeTimeout: 50 * time.Millisecond,
+			},
+		}
+
+		// Create a new in-memory TCP value.
+		u, connector, err := tcp.NewInMemory("traceID", "TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new in-memory TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to create a new in-memory TCP listener.", tests.Success)
+
+		// Start accepting client data.
+		if err := u.Start("traceID"); err != nil {
+			t.Fatal("\tShould be able to start the TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to start the TCP listener.", tests.Success)
+
+		defer u.Stop("traceID")
+
+		var conns []net.Conn
+		for i := 0; i < 3; i++ {
+			conn, err := connector.Connect()
+			if err != nil {
+				t.Fatal("\tShould be able to connect in memory.", tests.Failed, err)
+			}
+			t.Log("\tShould be able to connect in memory.", tests.Success)
+
+			defer conn.Close()
+			conns = append(conns, conn)
+		}
+
+		// The first connection is silent, the second is a probe.
+		start := time.Now()
+		go conns[1].Write([]byte("PING\n"))
+
+		if response, err := bufio.NewReader(conns[1]).ReadString('\n'); err != nil || response != "PONG\n" {
+			t.Fatal("\tShould answer a plain text probe.", tests.Failed, response, err)
+		}
+		if d := time.Since(start); d > 500*time.Millisecond {
+			t.Fatal("\tShould answer a plain text probe behind a silent client.", tests.Failed, d)
+		}
+		t.Log("\tShould answer a plain text probe behind a silent client.", tests.Success)
+
+		// The third is a TLS client, which ProbeFunc reads the start of.
+		tc := tls.Client(conns[2], &tls.Config{ServerName: "good.example", InsecureSkipVerify: true})
+		defer tc.Close()
+
+		if err := tc.Handshake(); err != nil {
+			t.Fatal("\tShould be able to finish the handshake after the probe.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to finish the handshake after the probe.", tests.Success)
+
+		go tc.Write([]byte("Hello\n"))
+
+		if response, err := bufio.NewReader(tc).ReadString('\n'); err != nil || response != "GOT IT\n" {
+			t.Fatal("\tShould receive the string \"GOT IT\" over TLS.", tests.Failed, response, err)
+		}
+		t.Log("\tShould receive the string \"GOT IT\" over TLS.", tests.Success)
+
+		if atomic.LoadInt32(&probedTLS) != 0 {
+			t.Fatal("\tShould give ProbeFunc the connection before TLS.", tests.Failed)
+		}
+		t.Log("\tShould give ProbeFunc the connection before TLS.", tests.Success)
+
+		conns[0].SetReadDeadline(time.Now().Add(time.Second))
+		if _, err := conns[0].Read(make([]byte, 1)); err != io.EOF {
+			t.Fatal("\tShould close the silent client once the handshake times out.", tests.Failed, err)
+		}
+		t.Log("\tShould close the silent client once the handshake times out.", tests.Success)
+
+		if stat := u.Stats(); stat.AcceptedTotal != 1 {
+			t.Fatal("\tShould only count the client that finished the handshake.", tests.Failed, stat.AcceptedTotal)
+		}
+		t.Log("\tShould only count the client that finished the handshake.", tests.Success)
+	}
+}
+
+// TestHijackPipelined tests a hijack isn't held up by the read routine
+// waiting to hand a pipelined request to a busy recv pool.
+func TestHijackPipelined(t *testing.T) {
+	tests.ResetLog()
+	defer tests.DisplayLog()
+
+	t.Log("Given the need to hijack a connection with requests pipelined behind it.")
+	{
+		errs := make(chan error, 1)
+
+		// Create a configuration with a single recv routine.
+		cfg := tcp.Config{
+			ConnHandler: tcpConnHandler{},
+			ReqHandler:  tcpHijackReqHandler{errs: errs},
+			RespHandler: tcpRespHandler{},
+
+			OptIntPool: tcp.OptIntPool{
+				RecvMinPoolSize: func() int { return 1 },
+				RecvMaxPoolSize: func() int { return 1 },
+				SendMinPoolSize: func() int { return 2 },
+				SendMaxPoolSize: func() int { return 1000 },
+			},
+		}
+
+		// Create a new in-memory TCP value.
+		u, connector, err := tcp.NewInMemory("traceID", "TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new in-memory TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to create a new in-memory TCP listener.", tests.Success)
+
+		// Start accepting client data.
+		if err := u.Start("traceID"); err != nil {
+			t.Fatal("\tShould be able to start the TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to start the TCP listener.", tests.Success)
+
+		defer u.Stop("traceID")
+
+		conn, err := connector.Connect()
+		if err != nil {
+			t.Fatal("\tShould be able to connect in memory.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to connect in memory.", tests.Success)
+
+		defer conn.Close()
+
+		// The read routine reads B while the only recv routine is
+		// hijacking the connection.
+		go conn.Write([]byte("HIJACK\nB\nC\n"))
+
+		select {
+		case err := <-errs:
+			if err != nil {
+				t.Fatal("\tShould be able to hijack the connection.", tests.Failed, err)
+			}
+			t.Log("\tShould be able to hijack the connection.", tests.Success)
+		case <-time.After(time.Second):
+			t.Fatal("\tShould be able to hijack the connection.", tests.Failed)
+		}
+
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		if response, err := bufio.NewReader(conn).ReadString('\n'); err != nil || response != "HIJACKED\n" {
+			t.Fatal("\tShould receive the string \"HIJACKED\" on the raw connection.", tests.Failed, response, err)
+		}
+		t.Log("\tShould receive the string \"HIJACKED\" on the raw connection.", tests.Success)
+
+		if n := len(u.Connections()); n != 0 {
+			t.Fatal("\tShould no longer manage the connection.", tests.Failed, n)
+		}
+		t.Log("\tShould no longer manage the connection.", tests.Success)
+	}
+}
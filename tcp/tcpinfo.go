@@ -0,0 +1,54 @@
+package tcp
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"syscall"
+	"time"
+)
+
+// ErrTCPInfoNotSupported is returned by ConnTCPInfo on systems other than
+// linux and for connections that aren't backed by a socket.
+var ErrTCPInfoNotSupported = errors.New("TCP info is not supported for this connection")
+
+// TCPInfo contains the kernel's view of the health of a connection.
+type TCPInfo struct {
+	RTT         time.Duration // Smoothed round trip time.
+	RTTVar      time.Duration // Variance of the round trip time.
+	SendCwnd    uint32        // Send congestion window in segments.
+	Unacked     uint32        // Segments sent but not yet acknowledged.
+	Lost        uint32        // Segments currently considered lost.
+	Retransmits uint32        // Segments retransmitted over the life of the connection.
+}
+
+// ConnTCPInfo returns the kernel TCP information for the connection with the
+// specified remote address, like the RTT and retransmits. It is only supported
+// on linux.
+func (t *TCP) ConnTCPInfo(addr string) (*TCPInfo, error) {
+	t.clientsMu.Lock()
+	c, ok := t.clients[addr]
+	t.clientsMu.Unlock()
+
+	if !ok {
+		return nil, fmt.Errorf("IP Address disconnected [ %s ]", addr)
+	}
+
+	// The socket is under the TLS connection.
+	conn := c.conn
+	if tc, ok := conn.(*tls.Conn); ok {
+		conn = tc.NetConn()
+	}
+
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return nil, ErrTCPInfoNotSupported
+	}
+
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+
+	return tcpInfo(rc)
+}
@@ -0,0 +1,37 @@
+//go:build linux && !386
+// +build linux,!386
+
+package tcp
+
+import (
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// tcpInfo reads the TCP_INFO socket option.
+func tcpInfo(rc syscall.RawConn) (*TCPInfo, error) {
+	var info syscall.TCPInfo
+	size := uint32(syscall.SizeofTCPInfo)
+
+	var errno syscall.Errno
+	if err := rc.Control(func(fd uintptr) {
+		_, _, errno = syscall.Syscall6(syscall.SYS_GETSOCKOPT, fd, syscall.IPPROTO_TCP, syscall.TCP_INFO, uintptr(unsafe.Pointer(&info)), uintptr(unsafe.Pointer(&size)), 0)
+	}); err != nil {
+		return nil, err
+	}
+
+	if errno != 0 {
+		return nil, errno
+	}
+
+	// The kernel reports the round trip times in microseconds.
+	return &TCPInfo{
+		RTT:         time.Duration(info.Rtt) * time.Microsecond,
+		RTTVar:      time.Duration(info.Rttvar) * time.Microsecond,
+		SendCwnd:    info.Snd_cwnd,
+		Unacked:     info.Unacked,
+		Lost:        info.Lost,
+		Retransmits: info.Total_retrans,
+	}, nil
+}
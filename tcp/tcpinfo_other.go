@@ -0,0 +1,11 @@
+//go:build !linux || 386
+// +build !linux 386
+
+package tcp
+
+import "syscall"
+
+// tcpInfo is only supported on linux.
+func tcpInfo(rc syscall.RawConn) (*TCPInfo, error) {
+	return nil, ErrTCPInfoNotSupported
+}
@@ -0,0 +1,118 @@
+//go:build prometheus
+// +build prometheus
+
+// Package tcpprom provides a Prometheus collector for the stats of a tcp
+// value. It lives in its own package so only users who want Prometheus
+// depend on it.
+//
+//	u, err := tcp.New("TEST", "TEST", cfg)
+//	if err != nil {
+//	    log.ErrFatal(err, "TEST", "main")
+//	}
+//
+//	prometheus.MustRegister(tcpprom.New(u))
+//
+// The labels of the tcp value, including its name, are added to every
+// metric so several values can be registered at once.
+//
+// This package depends on github.com/prometheus/client_golang which, like
+// the other third party packages used by kit, is not vendored. Vendor it
+// in your project or go get it into your GOPATH. The package is only built
+// with the prometheus build tag, so the rest of kit builds without it:
+//
+//	go build -tags prometheus
+package tcpprom
+
+import (
+	"github.com/ardanlabs/kit/pool"
+	"github.com/ardanlabs/kit/tcp"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// namespace is the prefix for all the metric names.
+const namespace = "tcp"
+
+// Collector implements the prometheus.Collector interface for a tcp value.
+type Collector struct {
+	t *tcp.TCP
+
+	connections   *prometheus.Desc
+	accepted      *prometheus.Desc
+	resets        *prometheus.Desc
+	notAdmitted   *prometheus.Desc
+	drops         *prometheus.Desc
+	bufferedBytes *prometheus.Desc
+	processAvg    *prometheus.Desc
+	poolRoutines  *prometheus.Desc
+	poolPending   *prometheus.Desc
+	poolActive    *prometheus.Desc
+	poolExecuted  *prometheus.Desc
+}
+
+// New creates a collector for the specified tcp value.
+func New(t *tcp.TCP) *Collector {
+	labels := prometheus.Labels(t.Labels())
+
+	desc := func(name string, help string, variable ...string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(namespace, "", name), help, variable, labels)
+	}
+
+	return &Collector{
+		t: t,
+
+		connections:   desc("connections", "Current number of client connections."),
+		accepted:      desc("accepted_total", "Number of connections accepted."),
+		resets:        desc("resets_total", "Number of calls to ResetConnections."),
+		notAdmitted:   desc("not_admitted_total", "Number of connections AdmitFunc did not admit."),
+		drops:         desc("drops_total", "Number of connections dropped by reason.", "reason"),
+		bufferedBytes: desc("buffered_bytes", "Bytes read or waiting to be written that haven't been handled yet."),
+		processAvg:    desc("process_avg_seconds", "Moving average of the time taken to process a request."),
+		poolRoutines:  desc("pool_routines", "Current number of routines in the work pool.", "pool"),
+		poolPending:   desc("pool_pending", "Number of routines waiting to submit work to the pool.", "pool"),
+		poolActive:    desc("pool_active", "Number of routines in the work pool doing work.", "pool"),
+		poolExecuted:  desc("pool_executed_total", "Number of pieces of work executed by the pool.", "pool"),
+	}
+}
+
+// Describe implements the prometheus.Collector interface.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.connections
+	ch <- c.accepted
+	ch <- c.resets
+	ch <- c.notAdmitted
+	ch <- c.drops
+	ch <- c.bufferedBytes
+	ch <- c.processAvg
+	ch <- c.poolRoutines
+	ch <- c.poolPending
+	ch <- c.poolActive
+	ch <- c.poolExecuted
+}
+
+// Collect implements the prometheus.Collector interface. Each call takes
+// a new snapshot of the stats.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	stats := c.t.Stats()
+
+	ch <- prometheus.MustNewConstMetric(c.connections, prometheus.GaugeValue, float64(stats.Connections))
+	ch <- prometheus.MustNewConstMetric(c.accepted, prometheus.CounterValue, float64(stats.AcceptedTotal))
+	ch <- prometheus.MustNewConstMetric(c.resets, prometheus.CounterValue, float64(stats.Resets))
+	ch <- prometheus.MustNewConstMetric(c.notAdmitted, prometheus.CounterValue, float64(stats.NotAdmitted))
+	ch <- prometheus.MustNewConstMetric(c.bufferedBytes, prometheus.GaugeValue, float64(stats.BufferedBytes))
+	ch <- prometheus.MustNewConstMetric(c.processAvg, prometheus.GaugeValue, stats.ProcessAvg.Seconds())
+
+	for reason, n := range stats.Drops {
+		ch <- prometheus.MustNewConstMetric(c.drops, prometheus.CounterValue, float64(n), reason)
+	}
+
+	c.collectPool(ch, "recv", stats.Recv)
+	c.collectPool(ch, "send", stats.Send)
+}
+
+// collectPool sends the metrics for one of the work pools.
+func (c *Collector) collectPool(ch chan<- prometheus.Metric, name string, stat pool.Stat) {
+	ch <- prometheus.MustNewConstMetric(c.poolRoutines, prometheus.GaugeValue, float64(stat.Routines), name)
+	ch <- prometheus.MustNewConstMetric(c.poolPending, prometheus.GaugeValue, float64(stat.Pending), name)
+	ch <- prometheus.MustNewConstMetric(c.poolActive, prometheus.GaugeValue, float64(stat.Active), name)
+	ch <- prometheus.MustNewConstMetric(c.poolExecuted, prometheus.CounterValue, float64(stat.Executed), name)
+}
@@ -0,0 +1,153 @@
+//go:build prometheus
+// +build prometheus
+
+package tcpprom_test
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ardanlabs/kit/tcp"
+	"github.com/ardanlabs/kit/tcp/tcpprom"
+	"github.com/ardanlabs/kit/tests"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// connHandler binds the connection to a buffered reader and writer.
+type connHandler struct{}
+
+// Bind implements the tcp.ConnHandler interface.
+func (connHandler) Bind(traceID string, conn net.Conn) (io.Reader, io.Writer) {
+	return bufio.NewReader(conn), bufio.NewWriter(conn)
+}
+
+// reqHandler reads lines and ignores them.
+type reqHandler struct{}
+
+// Read implements the tcp.ReqHandler interface.
+func (reqHandler) Read(traceID string, ipAddress string, reader io.Reader) ([]byte, int, error) {
+	line, err := reader.(*bufio.Reader).ReadString('\n')
+	return []byte(line), len(line), err
+}
+
+// Process implements the tcp.ReqHandler interface.
+func (reqHandler) Process(traceID string, r *tcp.Request) {}
+
+// respHandler writes the response data.
+type respHandler struct{}
+
+// Write implements the tcp.RespHandler interface.
+func (respHandler) Write(traceID string, r *tcp.Response, writer io.Writer) {
+	writer.Write(r.Data)
+}
+
+// TestCollector tests the stats of a TCP value are collected with its
+// labels and the drops are reported by reason.
+func TestCollector(t *testing.T) {
+	tests.ResetLog()
+	defer tests.DisplayLog()
+
+	t.Log("Given the need to collect the stats of a TCP value.")
+	{
+		dropped := make(chan string, 1)
+
+		// Create a configuration that drops the first connection.
+		cfg := tcp.Config{
+			ConnHandler: connHandler{},
+			ReqHandler:  reqHandler{},
+			RespHandler: respHandler{},
+
+			OptIntPool: tcp.OptIntPool{
+				RecvMinPoolSize: func() int { return 2 },
+				RecvMaxPoolSize: func() int { return 1000 },
+				SendMinPoolSize: func() int { return 2 },
+				SendMaxPoolSize: func() int { return 1000 },
+			},
+
+			OptAdmit: tcp.OptAdmit{
+				AcceptFilters: []tcp.AcceptFilter{
+					func(conn net.Conn) (bool, string) {
+						return conn.RemoteAddr().String() != "127.0.0.1:1", "blocked"
+					},
+				},
+			},
+
+			OptDrop: tcp.OptDrop{
+				OnDrop: func(reason string, remoteAddr string) {
+					dropped <- reason
+				},
+			},
+
+			OptLabels: tcp.OptLabels{
+				Labels: map[string]string{"region": "east"},
+			},
+		}
+
+		// Create a new in-memory TCP value.
+		u, c, err := tcp.NewInMemory("traceID", "TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new in-memory TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to create a new in-memory TCP listener.", tests.Success)
+
+		// Start accepting client data.
+		if err := u.Start("traceID"); err != nil {
+			t.Fatal("\tShould be able to start the TCP listener.", tests.Failed, err)
+		}
+		defer u.Stop("traceID")
+		t.Log("\tShould be able to start the TCP listener.", tests.Success)
+
+		conn, err := c.Connect()
+		if err != nil {
+			t.Fatal("\tShould be able to connect.", tests.Failed, err)
+		}
+		defer conn.Close()
+
+		select {
+		case <-dropped:
+		case <-time.After(time.Second):
+			t.Fatal("\tShould drop the first connection.", tests.Failed)
+		}
+		t.Log("\tShould drop the first connection.", tests.Success)
+
+		conn, err = c.Connect()
+		if err != nil {
+			t.Fatal("\tShould be able to connect.", tests.Failed, err)
+		}
+		defer conn.Close()
+
+		deadline := time.Now().Add(time.Second)
+		for len(u.Connections()) != 1 {
+			if time.Now().After(deadline) {
+				t.Fatal("\tShould accept the second connection.", tests.Failed)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Log("\tShould accept the second connection.", tests.Success)
+
+		collector := tcpprom.New(u)
+
+		expected := `
+# HELP tcp_accepted_total Number of connections accepted.
+# TYPE tcp_accepted_total counter
+tcp_accepted_total{name="TEST",region="east"} 1
+# HELP tcp_drops_total Number of connections dropped by reason.
+# TYPE tcp_drops_total counter
+tcp_drops_total{name="TEST",reason="blocked",region="east"} 1
+`
+		if err := testutil.CollectAndCompare(collector, strings.NewReader(expected), "tcp_accepted_total", "tcp_drops_total"); err != nil {
+			t.Fatal("\tShould collect the metrics with the labels and the drops by reason.", tests.Failed, err)
+		}
+		t.Log("\tShould collect the metrics with the labels and the drops by reason.", tests.Success)
+
+		// 6 scalar metrics, 1 drop reason and 4 metrics for each pool.
+		if n := testutil.CollectAndCount(collector); n != 15 {
+			t.Fatal("\tShould collect every metric.", tests.Failed, n)
+		}
+		t.Log("\tShould collect every metric.", tests.Success)
+	}
+}
@@ -0,0 +1,96 @@
+// Package tcptest provides helpers for testing code that uses the tcp
+// package. The Recorder captures the events a TCP value reports so tests
+// can assert on them without scraping the log.
+//
+//	var rec tcptest.Recorder
+//
+//	cfg := tcp.Config{
+//	    OptEvent: tcp.OptEvent{
+//	        Event: rec.Event,
+//	    },
+//	}
+//
+//	if !rec.Contains("accept", "RATE LIMIT") {
+//	    t.Fatal("Should drop the connection due to the rate limit.")
+//	}
+package tcptest
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Event is a single event reported by a TCP value.
+type Event struct {
+	TraceID string // Trace ID the event was reported with.
+	Context string // Name of the operation reporting the event, like "accept".
+	Message string // Formatted message for the event.
+}
+
+// Recorder records events and is safe for concurrent use. The zero value
+// is ready to use.
+type Recorder struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+// Event records an event. It has the signature of the tcp.OptEvent Event
+// field so it can be assigned to it.
+func (r *Recorder) Event(traceID string, event string, format string, a ...interface{}) {
+	e := Event{
+		TraceID: traceID,
+		Context: event,
+		Message: fmt.Sprintf(format, a...),
+	}
+
+	r.mu.Lock()
+	{
+		r.events = append(r.events, e)
+	}
+	r.mu.Unlock()
+}
+
+// Events returns a copy of the events recorded so far, in the order they
+// were recorded.
+func (r *Recorder) Events() []Event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	events := make([]Event, len(r.events))
+	copy(events, r.events)
+
+	return events
+}
+
+// Find returns the events for the specified context whose message contains
+// the specified text. An empty text matches every message.
+func (r *Recorder) Find(context string, text string) []Event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var events []Event
+	for _, e := range r.events {
+		if e.Context == context && strings.Contains(e.Message, text) {
+			events = append(events, e)
+		}
+	}
+
+	return events
+}
+
+// Contains reports if an event for the specified context has been recorded
+// whose message contains the specified text. An empty text matches every
+// message.
+func (r *Recorder) Contains(context string, text string) bool {
+	return len(r.Find(context, text)) > 0
+}
+
+// Reset removes all the events recorded so far.
+func (r *Recorder) Reset() {
+	r.mu.Lock()
+	{
+		r.events = nil
+	}
+	r.mu.Unlock()
+}
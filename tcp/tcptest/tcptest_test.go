@@ -0,0 +1,142 @@
+package tcptest_test
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/ardanlabs/kit/tcp"
+	"github.com/ardanlabs/kit/tcp/tcptest"
+	"github.com/ardanlabs/kit/tests"
+)
+
+// connHandler binds the connection to a buffered reader and writer.
+type connHandler struct{}
+
+// Bind implements the tcp.ConnHandler interface.
+func (connHandler) Bind(traceID string, conn net.Conn) (io.Reader, io.Writer) {
+	return bufio.NewReader(conn), bufio.NewWriter(conn)
+}
+
+// reqHandler reads lines and ignores them.
+type reqHandler struct{}
+
+// Read implements the tcp.ReqHandler interface.
+func (reqHandler) Read(traceID string, ipAddress string, reader io.Reader) ([]byte, int, error) {
+	line, err := reader.(*bufio.Reader).ReadString('\n')
+	return []byte(line), len(line), err
+}
+
+// Process implements the tcp.ReqHandler interface.
+func (reqHandler) Process(traceID string, r *tcp.Request) {}
+
+// respHandler writes the response data.
+type respHandler struct{}
+
+// Write implements the tcp.RespHandler interface.
+func (respHandler) Write(traceID string, r *tcp.Response, writer io.Writer) {
+	writer.Write(r.Data)
+}
+
+// TestRecorder tests events are recorded and can be matched.
+func TestRecorder(t *testing.T) {
+	tests.ResetLog()
+	defer tests.DisplayLog()
+
+	t.Log("Given the need to record events from concurrent routines.")
+	{
+		var rec tcptest.Recorder
+
+		var wg sync.WaitGroup
+		wg.Add(10)
+		for i := 0; i < 10; i++ {
+			go func(i int) {
+				defer wg.Done()
+				rec.Event("traceID", "accept", "Connection %d", i)
+			}(i)
+		}
+		wg.Wait()
+
+		rec.Event("traceID", "drop", "Client Dropped")
+
+		if n := len(rec.Events()); n != 11 {
+			t.Fatal("\tShould record every event.", tests.Failed, n)
+		}
+		t.Log("\tShould record every event.", tests.Success)
+
+		if !rec.Contains("accept", "Connection 7") {
+			t.Fatal("\tShould find the event with the formatted message.", tests.Failed)
+		}
+		t.Log("\tShould find the event with the formatted message.", tests.Success)
+
+		if rec.Contains("drop", "Connection") {
+			t.Fatal("\tShould only match events for the context.", tests.Failed)
+		}
+		t.Log("\tShould only match events for the context.", tests.Success)
+
+		if n := len(rec.Find("accept", "")); n != 10 {
+			t.Fatal("\tShould find every event for the context.", tests.Failed, n)
+		}
+		t.Log("\tShould find every event for the context.", tests.Success)
+
+		rec.Reset()
+		if n := len(rec.Events()); n != 0 {
+			t.Fatal("\tShould remove the events on reset.", tests.Failed, n)
+		}
+		t.Log("\tShould remove the events on reset.", tests.Success)
+	}
+}
+
+// TestRecorderTCP tests the events of a TCP value are recorded.
+func TestRecorderTCP(t *testing.T) {
+	tests.ResetLog()
+	defer tests.DisplayLog()
+
+	t.Log("Given the need to assert on the events of a TCP value.")
+	{
+		var rec tcptest.Recorder
+
+		// Create a configuration.
+		cfg := tcp.Config{
+			ConnHandler: connHandler{},
+			ReqHandler:  reqHandler{},
+			RespHandler: respHandler{},
+
+			OptIntPool: tcp.OptIntPool{
+				RecvMinPoolSize: func() int { return 2 },
+				RecvMaxPoolSize: func() int { return 1000 },
+				SendMinPoolSize: func() int { return 2 },
+				SendMaxPoolSize: func() int { return 1000 },
+			},
+
+			OptEvent: tcp.OptEvent{
+				Event: rec.Event,
+			},
+		}
+
+		// Create a new in-memory TCP value.
+		u, _, err := tcp.NewInMemory("traceID", "TEST", cfg)
+		if err != nil {
+			t.Fatal("\tShould be able to create a new in-memory TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to create a new in-memory TCP listener.", tests.Success)
+
+		// Start accepting client data.
+		if err := u.Start("traceID"); err != nil {
+			t.Fatal("\tShould be able to start the TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to start the TCP listener.", tests.Success)
+
+		if err := u.Stop("traceID"); err != nil {
+			t.Fatal("\tShould be able to stop the TCP listener.", tests.Failed, err)
+		}
+		t.Log("\tShould be able to stop the TCP listener.", tests.Success)
+
+		if !rec.Contains("accept", "Waiting For Connections") {
+			t.Fatal("\tShould record the accept routine starting.", tests.Failed, rec.Events())
+		}
+		t.Log("\tShould record the accept routine starting.", tests.Success)
+	}
+}